@@ -0,0 +1,92 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+
+	"github.com/spf13/viper"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/agent"
+)
+
+func loadConfig() (*viper.Viper, error) {
+	v := viper.New()
+	v.SetConfigName("ringmanager-agent")
+	v.AddConfigPath("/etc/ringmanager")
+	loadDefaultConfigOptions(v)
+	err := v.ReadInConfig()
+	if err != nil {
+		return nil, err
+	}
+	if interval := v.GetDuration("interval"); interval <= 0 {
+		return nil, fmt.Errorf("Invalid interval %v", v.GetString("interval"))
+	}
+	return v, nil
+}
+
+func loadDefaultConfigOptions(v *viper.Viper) {
+	v.SetDefault("manager_url", "http://127.0.0.1:8090")
+	v.SetDefault("node", defaultNodeAddress())
+	v.SetDefault("swift_dir", "/etc/swift")
+	v.SetDefault("interval", "60s")
+}
+
+// defaultNodeAddress returns the ip of the host, which is how the manager
+// knows the node, or the hostname if it has none
+func defaultNodeAddress() string {
+	hostname, _ := os.Hostname()
+	if addrs, err := net.LookupHost(hostname); err == nil {
+		for _, addr := range addrs {
+			if ip := net.ParseIP(addr); ip != nil && !ip.IsLoopback() {
+				return addr
+			}
+		}
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err == nil {
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() &&
+				ipnet.IP.To4() != nil {
+				return ipnet.IP.String()
+			}
+		}
+	}
+
+	return hostname
+}
+
+func main() {
+	v, err := loadConfig()
+	if err != nil {
+		panic(fmt.Errorf("Fatal error loading config file: %s", err))
+	}
+	if v.GetString("cluster") == "" {
+		log.Fatal("cluster missing in the configuration")
+	}
+
+	a := agent.NewAgent(agent.Config{
+		ManagerUrl: v.GetString("manager_url"),
+		ClusterId:  v.GetString("cluster"),
+		Node:       v.GetString("node"),
+		SwiftDir:   v.GetString("swift_dir"),
+		Interval:   v.GetDuration("interval"),
+	})
+	a.Run(nil)
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func build(c *cli, args []string) error {
+	fs := flag.NewFlagSet("build", flag.ContinueOnError)
+	author := fs.String("author", "", "Who asked for the build")
+	dryRun := fs.Bool("dry-run", false, "Only estimate what the rebalance would move")
+	partitionSize := fs.Uint64("partition-size", 0, "Average size of a partition in bytes for --dry-run")
+	ids, err := parseArgs(fs, args, "CLUSTER")
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		job, err := c.client.BuildRingDryRun(ids[0], *partitionSize)
+		if err != nil {
+			return err
+		}
+		return c.print(job, func(w io.Writer) {
+			fmt.Fprintln(w, "RING\tPARTS MOVED\tREPLICAS MOVED\tBYTES MOVED\tBALANCE\tDISPERSION")
+			for _, ring := range job.Result.Rings {
+				e := ring.Estimate
+				fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%.2f -> %.2f\t%.2f -> %.2f\n", ring.Name,
+					e.PartsMoved, e.ReplicasMoved, e.BytesMoved, e.BalanceBefore, e.BalanceAfter,
+					e.DispersionBefore, e.DispersionAfter)
+			}
+		})
+	}
+
+	job, err := c.client.BuildRing(ids[0], *author)
+	if err != nil {
+		return err
+	}
+
+	return c.print(job, func(w io.Writer) {
+		fmt.Fprintln(w, "RING\tVERSION\tADDED\tREMOVED\tREWEIGHTED\tCHANGED PARTS\tBALANCE")
+		for _, ring := range job.Result.Rings {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%.2f\n", ring.Name, ring.Version,
+				len(ring.DevicesAdded), len(ring.DevicesRemoved), len(ring.DevicesReweighted),
+				ring.ChangedParts, ring.Balance)
+		}
+	})
+}
+
+// download saves the ring file, once checked against the etag the
+// manager sent
+func download(c *cli, args []string) error {
+	fs := flag.NewFlagSet("download", flag.ContinueOnError)
+	output := fs.String("output", "", "File to save the ring to, defaults to RING.ring.gz")
+	names, err := parseArgs(fs, args, "CLUSTER", "RING")
+	if err != nil {
+		return err
+	}
+	path := *output
+	if path == "" {
+		path = names[1] + ".ring.gz"
+	}
+
+	ring, err := c.client.DownloadRing(names[0], names[1], nil)
+	if err != nil {
+		return err
+	}
+	defer ring.Body.Close()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), ring.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != ring.Etag {
+		return fmt.Errorf("Checksum %v of the download does not match %v", sum, ring.Etag)
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return err
+	}
+
+	result := map[string]interface{}{
+		"path":    path,
+		"etag":    ring.Etag,
+		"version": ring.Version,
+	}
+	return c.print(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Saved version %v of ring %v to %v\n", ring.Version, names[1], path)
+	})
+}
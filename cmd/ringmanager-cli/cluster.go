@@ -0,0 +1,265 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/thiagodasilva/swift-ring-manager/pkg/client"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringmanager"
+	"gopkg.in/yaml.v2"
+)
+
+func clusterCreate(c *cli, args []string) error {
+	fs := flag.NewFlagSet("cluster create", flag.ContinueOnError)
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	cluster, err := c.client.ClusterCreate()
+	if err != nil {
+		return err
+	}
+
+	return c.print(cluster, func(w io.Writer) {
+		fmt.Fprintf(w, "Id:\t%v\n", cluster.Id)
+	})
+}
+
+func clusterList(c *cli, args []string) error {
+	fs := flag.NewFlagSet("cluster list", flag.ContinueOnError)
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	list, err := c.client.ClusterList()
+	if err != nil {
+		return err
+	}
+
+	return c.print(list, func(w io.Writer) {
+		fmt.Fprintln(w, "ID")
+		for _, id := range list.Clusters {
+			fmt.Fprintln(w, id)
+		}
+	})
+}
+
+func clusterInfo(c *cli, args []string) error {
+	fs := flag.NewFlagSet("cluster info", flag.ContinueOnError)
+	ids, err := parseArgs(fs, args, "CLUSTER")
+	if err != nil {
+		return err
+	}
+
+	cluster, err := c.client.ClusterInfo(ids[0])
+	if err != nil {
+		return err
+	}
+
+	return c.print(cluster, func(w io.Writer) {
+		fmt.Fprintf(w, "Id:\t%v\n", cluster.Id)
+		fmt.Fprintf(w, "Rings:\t%v\n", strings.Join(cluster.Rings, ", "))
+	})
+}
+
+func clusterDelete(c *cli, args []string) error {
+	fs := flag.NewFlagSet("cluster delete", flag.ContinueOnError)
+	cascade := fs.Bool("cascade", false, "Delete the rings, nodes and devices too")
+	dryRun := fs.Bool("dry-run", false, "Only show what would be deleted")
+	ids, err := parseArgs(fs, args, "CLUSTER")
+	if err != nil {
+		return err
+	}
+
+	deleted, err := c.client.ClusterDelete(ids[0], *cascade, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	return c.print(deleted, func(w io.Writer) {
+		if deleted.DryRun {
+			fmt.Fprintf(w, "Would delete cluster %v\n", deleted.Id)
+		} else {
+			fmt.Fprintf(w, "Deleted cluster %v\n", deleted.Id)
+		}
+		fmt.Fprintf(w, "Rings:\t%v\n", strings.Join(deleted.Rings, ", "))
+		fmt.Fprintf(w, "Nodes:\t%v\n", strings.Join(deleted.Nodes, ", "))
+		fmt.Fprintf(w, "Devices:\t%v\n", strings.Join(deleted.Devices, ", "))
+	})
+}
+
+// readTopology reads a topology document, in YAML when the file name says
+// so and in JSON otherwise
+func readTopology(path string) (*ringmanager.Topology, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var topology ringmanager.Topology
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &topology)
+	default:
+		err = json.Unmarshal(data, &topology)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse %v: %v", path, err)
+	}
+	return &topology, nil
+}
+
+func clusterApply(c *cli, args []string) error {
+	fs := flag.NewFlagSet("cluster apply", flag.ContinueOnError)
+	file := fs.String("file", "", "Topology document in JSON or YAML")
+	plan := fs.Bool("plan", false, "Only show the changes")
+	force := fs.Bool("force", false, "Remove devices that still hold partitions")
+	ids, err := parseArgs(fs, args, "CLUSTER")
+	if err != nil {
+		return err
+	}
+	if *file == "" {
+		return usagef("cluster apply needs --file")
+	}
+
+	topology, err := readTopology(*file)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.client.ClusterApply(ids[0], topology, *plan, *force)
+	if err != nil {
+		return err
+	}
+
+	return c.print(response, func(w io.Writer) {
+		if len(response.Changes) == 0 {
+			fmt.Fprintln(w, "No changes")
+			return
+		}
+		fmt.Fprintln(w, "ACTION\tKIND\tNAME\tCHANGES")
+		for _, change := range response.Changes {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", change.Action, change.Kind, change.Name,
+				strings.Join(change.Changes, ", "))
+		}
+		if response.Plan {
+			fmt.Fprintln(w, "Plan only, nothing was changed")
+		}
+	})
+}
+
+// clusterExport prints the topology of the cluster, ready to be applied
+func clusterExport(c *cli, args []string) error {
+	fs := flag.NewFlagSet("cluster export", flag.ContinueOnError)
+	format := fs.String("format", "yaml", "Format of the document, json or yaml")
+	ids, err := parseArgs(fs, args, "CLUSTER")
+	if err != nil {
+		return err
+	}
+	if *format != "json" && *format != "yaml" {
+		return usagef("Unknown format %v", *format)
+	}
+
+	topology, err := c.client.ClusterExport(ids[0])
+	if err != nil {
+		return err
+	}
+
+	if *format == "json" || c.json {
+		return c.print(topology, nil)
+	}
+	data, err := yaml.Marshal(topology)
+	if err != nil {
+		return err
+	}
+	_, err = c.stdout.Write(data)
+	return err
+}
+
+// clusterImport adds a ring from a swift builder or ring file, named
+// after the file unless --name is given
+func clusterImport(c *cli, args []string) error {
+	fs := flag.NewFlagSet("cluster import", flag.ContinueOnError)
+	file := fs.String("file", "", "Swift .builder or .ring.gz file")
+	name := fs.String("name", "", "Name of the ring")
+	minPartHours := fs.Int("min-part-hours", -1, "Min part hours of a ring file")
+	author := fs.String("author", "", "Author of the first version of the ring")
+	ids, err := parseArgs(fs, args, "CLUSTER")
+	if err != nil {
+		return err
+	}
+	if *file == "" {
+		return usagef("cluster import needs --file")
+	}
+
+	opts := &client.ImportOptions{Name: *name, Author: *author}
+	if opts.Name == "" {
+		opts.Name = filepath.Base(*file)
+		for _, ext := range []string{".gz", ".ring", ".builder"} {
+			opts.Name = strings.TrimSuffix(opts.Name, ext)
+		}
+	}
+	if *minPartHours >= 0 {
+		opts.MinPartHours = minPartHours
+	}
+
+	fp, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	ring, err := c.client.ClusterImport(ids[0], fp, opts)
+	if err != nil {
+		return err
+	}
+
+	return c.print(ring, func(w io.Writer) {
+		fmt.Fprintf(w, "Id:\t%v\n", ring.Id)
+		fmt.Fprintf(w, "Name:\t%v\n", ring.Name)
+		fmt.Fprintf(w, "Part power:\t%v\n", ring.PartPower)
+		fmt.Fprintf(w, "Replicas:\t%v\n", ring.Replicas)
+		fmt.Fprintf(w, "Min part hours:\t%v\n", ring.MinPartHours)
+		fmt.Fprintf(w, "Version:\t%v\n", ring.Version)
+		fmt.Fprintf(w, "Nodes:\t%v\n", len(ring.Nodes))
+		fmt.Fprintf(w, "Devices:\t%v\n", len(ring.Devices))
+	})
+}
+
+// clusterSwiftConf prints the storage policies of the cluster, ready to
+// be pasted in swift.conf
+func clusterSwiftConf(c *cli, args []string) error {
+	fs := flag.NewFlagSet("cluster swift-conf", flag.ContinueOnError)
+	ids, err := parseArgs(fs, args, "CLUSTER")
+	if err != nil {
+		return err
+	}
+
+	conf, err := c.client.SwiftConf(ids[0])
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(c.stdout, conf)
+	return err
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringmanager"
+)
+
+func deviceAdd(c *cli, args []string) error {
+	var req ringmanager.DeviceAddRequest
+	fs := flag.NewFlagSet("device add", flag.ContinueOnError)
+	fs.StringVar(&req.NodeId, "node", "", "Node of the device")
+	fs.StringVar(&req.Name, "name", "", "Name of the device, like sdb1")
+	fs.StringVar(&req.Meta, "meta", "", "Free form information on the device")
+	fs.Uint64Var(&req.Weight, "weight", 0, "Weight of the device")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if req.NodeId == "" || req.Name == "" {
+		return usagef("device add needs --node and --name")
+	}
+
+	device, err := c.client.DeviceAdd(&req)
+	if err != nil {
+		return err
+	}
+
+	return c.print(device, func(w io.Writer) {
+		printDevice(w, device)
+	})
+}
+
+func deviceInfo(c *cli, args []string) error {
+	fs := flag.NewFlagSet("device info", flag.ContinueOnError)
+	ids, err := parseArgs(fs, args, "DEVICE")
+	if err != nil {
+		return err
+	}
+
+	device, err := c.client.DeviceInfo(ids[0])
+	if err != nil {
+		return err
+	}
+
+	return c.print(device, func(w io.Writer) {
+		printDevice(w, device)
+	})
+}
+
+func printDevice(w io.Writer, device *ringmanager.DeviceInfoResponse) {
+	fmt.Fprintf(w, "Id:\t%v\n", device.Id)
+	fmt.Fprintf(w, "Name:\t%v\n", device.Name)
+	fmt.Fprintf(w, "Meta:\t%v\n", device.Meta)
+	fmt.Fprintf(w, "Weight:\t%v\n", device.Weight.Current)
+	if device.Weight.Target != device.Weight.Current {
+		fmt.Fprintf(w, "Target weight:\t%v\n", device.Weight.Target)
+	}
+}
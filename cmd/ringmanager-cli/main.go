@@ -0,0 +1,230 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/thiagodasilva/swift-ring-manager/pkg/client"
+)
+
+const (
+	SERVER_ENV     = "RINGMANAGER_SERVER"
+	DEFAULT_SERVER = "http://127.0.0.1:8090"
+)
+
+const usage = `Usage: ringmanager-cli [--server URL] [--json] COMMAND
+
+Commands:
+  cluster create
+  cluster list
+  cluster info CLUSTER
+  cluster delete CLUSTER [--cascade] [--dry-run]
+  cluster apply CLUSTER --file FILE [--plan] [--force]
+  cluster export CLUSTER [--format yaml|json]
+  cluster import CLUSTER --file FILE [--name NAME] [--min-part-hours N] [--author AUTHOR]
+  cluster swift-conf CLUSTER
+  ring add --cluster CLUSTER --name NAME [--part-power N] [--replicas N] [--min-part-hours N]
+           [--type TYPE] [--components RING,RING] [--policy-name NAME] [--policy-aliases A,B]
+           [--policy-type TYPE] [--default] [--deprecated] [--ec-type TYPE --ec-data-fragments N
+           --ec-parity-fragments N [--ec-segment-size BYTES]]
+  ring info RING
+  ring report RING
+  ring delete RING
+  node add --ring RING --ip IP --port PORT [--region N] [--zone N]
+           [--replication-ip IP] [--replication-port PORT]
+  node info NODE
+  device add --node NODE --name NAME --weight N [--meta META]
+  device info DEVICE
+  build CLUSTER [--author AUTHOR] [--dry-run [--partition-size BYTES]]
+  download CLUSTER RING [--output FILE]
+
+The server is also read from the ` + SERVER_ENV + ` environment variable.
+`
+
+// usageError is a command line that does not make sense
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func usagef(format string, a ...interface{}) error {
+	return &usageError{msg: fmt.Sprintf(format, a...)}
+}
+
+type cli struct {
+	client *client.Client
+	json   bool
+	stdout io.Writer
+}
+
+// commands by name, then by subcommand.  Commands without subcommands
+// use the empty name.
+var commands = map[string]map[string]func(c *cli, args []string) error{
+	"cluster": {
+		"create":     clusterCreate,
+		"list":       clusterList,
+		"info":       clusterInfo,
+		"delete":     clusterDelete,
+		"apply":      clusterApply,
+		"export":     clusterExport,
+		"import":     clusterImport,
+		"swift-conf": clusterSwiftConf,
+	},
+	"ring": {
+		"add":    ringAdd,
+		"info":   ringInfo,
+		"report": ringReport,
+		"delete": ringDelete,
+	},
+	"node": {
+		"add":  nodeAdd,
+		"info": nodeInfo,
+	},
+	"device": {
+		"add":  deviceAdd,
+		"info": deviceInfo,
+	},
+	"build": {
+		"": build,
+	},
+	"download": {
+		"": download,
+	},
+}
+
+// run executes the command line and returns the exit code
+func run(args []string, stdout, stderr io.Writer) int {
+	server := os.Getenv(SERVER_ENV)
+	if server == "" {
+		server = DEFAULT_SERVER
+	}
+
+	fs := flag.NewFlagSet("ringmanager-cli", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, usage) }
+	fs.StringVar(&server, "server", server, "Url of the ring manager")
+	jsonOutput := fs.Bool("json", false, "Print the responses in json")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	c := &cli{
+		client: client.NewClient(server),
+		json:   *jsonOutput,
+		stdout: stdout,
+	}
+
+	err := c.execute(fs.Args())
+	if _, ok := err.(*usageError); ok {
+		fmt.Fprintf(stderr, "Error: %v\n\n%v", err, usage)
+		return 2
+	} else if e, ok := err.(*client.Error); ok {
+		fmt.Fprintf(stderr, "Error: %v (%v)\n", e.Message, e.StatusCode)
+		return 1
+	} else if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+func (c *cli) execute(args []string) error {
+	if len(args) == 0 {
+		return usagef("Command missing")
+	}
+
+	subcommands, ok := commands[args[0]]
+	if !ok {
+		return usagef("Unknown command %v", args[0])
+	}
+	if cmd, ok := subcommands[""]; ok {
+		return cmd(c, args[1:])
+	}
+
+	if len(args) < 2 {
+		return usagef("%v needs one of: %v", args[0], strings.Join(subcommandNames(subcommands), ", "))
+	}
+	cmd, ok := subcommands[args[1]]
+	if !ok {
+		return usagef("Unknown command %v %v", args[0], args[1])
+	}
+	return cmd(c, args[2:])
+}
+
+func subcommandNames(subcommands map[string]func(c *cli, args []string) error) []string {
+	names := make([]string, 0, len(subcommands))
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseArgs parses the flags of a command, which may come before or after
+// its arguments, and checks the number of arguments
+func parseArgs(fs *flag.FlagSet, args []string, names ...string) ([]string, error) {
+	fs.SetOutput(ioutil.Discard)
+
+	positional := make([]string, 0)
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, usagef("%v: %v", fs.Name(), err)
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if len(positional) != len(names) {
+		if len(names) == 0 {
+			return nil, usagef("%v takes no arguments", fs.Name())
+		}
+		return nil, usagef("%v needs %v", fs.Name(), strings.Join(names, " "))
+	}
+	return positional, nil
+}
+
+// print writes the response in json, or as a table when there is one
+func (c *cli) print(v interface{}, table func(w io.Writer)) error {
+	if c.json || table == nil {
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 8, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
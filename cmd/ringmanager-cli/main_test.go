@@ -0,0 +1,328 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringbuilder"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringmanager"
+)
+
+func setupServer(t *testing.T) (*httptest.Server, string, func()) {
+	dir, err := ioutil.TempDir("", "ringmanager")
+	assert.Nil(t, err)
+
+	v := viper.New()
+	v.Set("dbfilename", "swift_clusters.db")
+	v.Set("ringmanager_dir", dir)
+	router, err := ringmanager.NewRouter(v)
+	assert.Nil(t, err)
+	ts := httptest.NewServer(router)
+
+	return ts, dir, func() {
+		ts.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// runCli runs the command line and returns the exit code, stdout and stderr
+func runCli(ts *httptest.Server, args ...string) (int, string, string) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	code := run(append([]string{"--server", ts.URL}, args...), stdout, stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// runCliJson runs the command line with json output and decodes it
+func runCliJson(t *testing.T, ts *httptest.Server, v interface{}, args ...string) {
+	code, stdout, stderr := runCli(ts, append([]string{"--json"}, args...)...)
+	assert.Equal(t, 0, code, stderr)
+	err := json.Unmarshal([]byte(stdout), v)
+	assert.Nil(t, err)
+}
+
+func TestCli(t *testing.T) {
+	ts, dir, tearDown := setupServer(t)
+	defer tearDown()
+
+	var cluster ringmanager.ClusterInfoResponse
+	runCliJson(t, ts, &cluster, "cluster", "create")
+	assert.NotEmpty(t, cluster.Id)
+
+	var ring ringmanager.RingInfoResponse
+	runCliJson(t, ts, &ring, "ring", "add", "--cluster", cluster.Id, "--name", "object",
+		"--part-power", "8", "--min-part-hours", "0")
+	assert.Equal(t, 8, ring.PartPower)
+	assert.Equal(t, 0, ring.MinPartHours)
+	assert.Equal(t, float64(3), ring.Replicas)
+
+	for _, ip := range []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"} {
+		var node ringmanager.NodeInfoResponse
+		runCliJson(t, ts, &node, "node", "add", "--ring", ring.Id, "--ip", ip, "--port", "6010")
+		assert.Equal(t, ip, node.Ip)
+
+		var device ringmanager.DeviceInfoResponse
+		runCliJson(t, ts, &device, "device", "add", "--node", node.Id, "--name", "sdb1",
+			"--weight", "100")
+		assert.Equal(t, uint64(100), device.Weight.Target)
+	}
+
+	// Tables
+	code, stdout, _ := runCli(ts, "cluster", "list")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "ID\n"+cluster.Id+"\n", stdout)
+
+	code, stdout, _ = runCli(ts, "ring", "info", ring.Id)
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stdout, "Name:            object\n")
+	assert.Contains(t, stdout, "Part power:      8\n")
+
+	code, stdout, _ = runCli(ts, "build", cluster.Id, "--author", "admin")
+	assert.Equal(t, 0, code)
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.True(t, strings.HasPrefix(lines[1], "object  1 "), lines[1])
+
+	code, stdout, _ = runCli(ts, "build", cluster.Id, "--dry-run", "--partition-size", "1000")
+	assert.Equal(t, 0, code)
+	lines = strings.Split(strings.TrimSpace(stdout), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.True(t, strings.HasPrefix(lines[0], "RING    PARTS MOVED  REPLICAS MOVED  BYTES MOVED "), lines[0])
+	assert.Regexp(t, `^object +0 +0 +0 +`, lines[1])
+
+	output := filepath.Join(dir, "object.ring.gz")
+	code, stdout, _ = runCli(ts, "download", cluster.Id, "object", "--output", output)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "Saved version 1 of ring object to "+output+"\n", stdout)
+	_, err := ringbuilder.LoadRingData(output)
+	assert.Nil(t, err)
+
+	code, stdout, _ = runCli(ts, "ring", "report", ring.Id)
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stdout, "Ring object version 1: 256 partitions, 3 replicas\n")
+	assert.Regexp(t, `\nip +3 +0 +0.00 +0\n`, stdout)
+	var report ringmanager.RingReportResponse
+	runCliJson(t, ts, &report, "ring", "report", ring.Id)
+	assert.Equal(t, 3, len(report.Devices))
+
+	var deleted ringmanager.ClusterDeleteResponse
+	runCliJson(t, ts, &deleted, "cluster", "delete", "--dry-run", cluster.Id, "--cascade")
+	assert.True(t, deleted.DryRun)
+	assert.Equal(t, 3, len(deleted.Nodes))
+}
+
+func TestCliErrors(t *testing.T) {
+	ts, _, tearDown := setupServer(t)
+	defer tearDown()
+
+	// Api errors
+	code, stdout, stderr := runCli(ts, "ring", "info", "123")
+	assert.Equal(t, 1, code)
+	assert.Empty(t, stdout)
+	assert.Equal(t, "Error: Id not found (404)\n", stderr)
+
+	code, _, stderr = runCli(ts, "ring", "add", "--cluster", "123", "--name", "object")
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "Error: Cluster id does not exist (404)\n", stderr)
+
+	// Bad command lines
+	for _, args := range [][]string{
+		{},
+		{"foo"},
+		{"cluster"},
+		{"cluster", "foo"},
+		{"cluster", "info"},
+		{"cluster", "list", "123"},
+		{"ring", "add", "--name", "object"},
+		{"node", "add", "--zone", "abc"},
+	} {
+		code, _, stderr = runCli(ts, args...)
+		assert.Equal(t, 2, code, args)
+		assert.Contains(t, stderr, "Usage:", args)
+	}
+
+	// Nobody listening
+	stderr2 := &bytes.Buffer{}
+	code = run([]string{"--server", "http://127.0.0.1:1", "cluster", "list"}, &bytes.Buffer{}, stderr2)
+	assert.Equal(t, 1, code)
+	assert.True(t, strings.HasPrefix(stderr2.String(), "Error: "))
+}
+
+func TestCliServerFromEnv(t *testing.T) {
+	ts, _, tearDown := setupServer(t)
+	defer tearDown()
+
+	os.Setenv(SERVER_ENV, ts.URL)
+	defer os.Unsetenv(SERVER_ENV)
+
+	stdout := &bytes.Buffer{}
+	code := run([]string{"cluster", "list"}, stdout, &bytes.Buffer{})
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "ID\n", stdout.String())
+}
+
+func TestCliClusterApply(t *testing.T) {
+	ts, dir, tearDown := setupServer(t)
+	defer tearDown()
+
+	var cluster ringmanager.ClusterInfoResponse
+	runCliJson(t, ts, &cluster, "cluster", "create")
+
+	file := filepath.Join(dir, "topology.yaml")
+	err := ioutil.WriteFile(file, []byte(`
+rings:
+- name: object
+  nodes:
+  - ip: 127.0.0.1
+    port: "6010"
+    devices:
+    - name: sdb1
+      weight: 100
+`), 0644)
+	assert.Nil(t, err)
+
+	code, stdout, stderr := runCli(ts, "cluster", "apply", cluster.Id, "--file", file, "--plan")
+	assert.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "add     node    object/127.0.0.1:6010")
+	assert.Contains(t, stdout, "Plan only")
+
+	var applied ringmanager.TopologyApplyResponse
+	runCliJson(t, ts, &applied, "cluster", "apply", cluster.Id, "--file", file)
+	assert.False(t, applied.Plan)
+	assert.Equal(t, 3, len(applied.Changes))
+
+	code, stdout, _ = runCli(ts, "cluster", "apply", cluster.Id, "--file", file)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "No changes\n", stdout)
+
+	code, _, stderr = runCli(ts, "cluster", "apply", cluster.Id, "--file", filepath.Join(dir, "missing.yaml"))
+	assert.Equal(t, 1, code)
+	assert.True(t, strings.HasPrefix(stderr, "Error: "))
+}
+
+func TestCliClusterExport(t *testing.T) {
+	ts, dir, tearDown := setupServer(t)
+	defer tearDown()
+
+	var cluster ringmanager.ClusterInfoResponse
+	runCliJson(t, ts, &cluster, "cluster", "create")
+	var ring ringmanager.RingInfoResponse
+	runCliJson(t, ts, &ring, "ring", "add", "--cluster", cluster.Id, "--name", "object")
+
+	code, stdout, stderr := runCli(ts, "cluster", "export", cluster.Id)
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, "rings:\n- name: object\n  part_power: 10\n  replicas: 3\n  min_part_hours: 1\n"+
+		"  type: object\n  policy:\n    index: 0\n    name: Policy-0\n    policy_type: replication\n  nodes: []\n", stdout)
+
+	var topology ringmanager.Topology
+	runCliJson(t, ts, &topology, "cluster", "export", cluster.Id, "--format", "json")
+	assert.Equal(t, "object", topology.Rings[0].Name)
+
+	// The export applies back to another cluster
+	file := filepath.Join(dir, "topology.yaml")
+	err := ioutil.WriteFile(file, []byte(stdout), 0644)
+	assert.Nil(t, err)
+	var other ringmanager.ClusterInfoResponse
+	runCliJson(t, ts, &other, "cluster", "create")
+	var applied ringmanager.TopologyApplyResponse
+	runCliJson(t, ts, &applied, "cluster", "apply", other.Id, "--file", file)
+	assert.Equal(t, 1, len(applied.Changes))
+
+	code, _, _ = runCli(ts, "cluster", "export", cluster.Id, "--format", "xml")
+	assert.Equal(t, 2, code)
+}
+
+func TestCliClusterImport(t *testing.T) {
+	ts, _, tearDown := setupServer(t)
+	defer tearDown()
+
+	var cluster ringmanager.ClusterInfoResponse
+	runCliJson(t, ts, &cluster, "cluster", "create")
+
+	// The ring is named after the file
+	var ring ringmanager.RingImportResponse
+	runCliJson(t, ts, &ring, "cluster", "import", cluster.Id,
+		"--file", "../../pkg/ringbuilder/testdata/object.builder")
+	assert.Equal(t, "object", ring.Name)
+	assert.Equal(t, 3, len(ring.Devices))
+
+	code, stdout, stderr := runCli(ts, "cluster", "import", cluster.Id,
+		"--file", "../../pkg/ringbuilder/testdata/fractional.ring.gz", "--name", "object-1", "--min-part-hours", "0")
+	assert.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "object-1\n")
+	assert.Regexp(t, "Min part hours: +0\n", stdout)
+
+	code, _, _ = runCli(ts, "cluster", "import", cluster.Id)
+	assert.Equal(t, 2, code)
+}
+
+func TestCliStoragePolicies(t *testing.T) {
+	ts, _, tearDown := setupServer(t)
+	defer tearDown()
+
+	var cluster ringmanager.ClusterInfoResponse
+	runCliJson(t, ts, &cluster, "cluster", "create")
+	var ring ringmanager.RingInfoResponse
+	runCliJson(t, ts, &ring, "ring", "add", "--cluster", cluster.Id, "--name", "object")
+	runCliJson(t, ts, &ring, "ring", "add", "--cluster", cluster.Id, "--name", "object-1",
+		"--policy-name", "gold", "--policy-aliases", "yellow, orange", "--default")
+	assert.Equal(t, "object", ring.Type)
+	assert.Equal(t, 1, ring.Policy.Index)
+	assert.Equal(t, []string{"yellow", "orange"}, ring.Policy.Aliases)
+
+	code, stdout, stderr := runCli(ts, "cluster", "swift-conf", cluster.Id)
+	assert.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "[storage-policy:1]\nname = gold\naliases = yellow, orange\n"+
+		"policy_type = replication\ndefault = yes\n")
+
+	// Erasure coded rings have one replica per fragment
+	runCliJson(t, ts, &ring, "ring", "add", "--cluster", cluster.Id, "--name", "object-3",
+		"--ec-type", "liberasurecode_rs_vand", "--ec-data-fragments", "10", "--ec-parity-fragments", "4")
+	assert.Equal(t, 14.0, ring.Replicas)
+	assert.Equal(t, ringmanager.POLICY_TYPE_ERASURE_CODING, ring.Policy.PolicyType)
+
+	code, _, stderr = runCli(ts, "ring", "add", "--cluster", cluster.Id, "--name", "object-2",
+		"--policy-name", "Gold")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "(409)")
+}
+
+func TestCliCompositeRing(t *testing.T) {
+	ts, _, tearDown := setupServer(t)
+	defer tearDown()
+
+	var cluster ringmanager.ClusterInfoResponse
+	runCliJson(t, ts, &cluster, "cluster", "create")
+	var east, west ringmanager.RingInfoResponse
+	runCliJson(t, ts, &east, "ring", "add", "--cluster", cluster.Id, "--name", "east", "--type", "component")
+	runCliJson(t, ts, &west, "ring", "add", "--cluster", cluster.Id, "--name", "west", "--type", "component")
+
+	code, stdout, stderr := runCli(ts, "ring", "add", "--cluster", cluster.Id, "--name", "object-1",
+		"--components", east.Id+", "+west.Id)
+	assert.Equal(t, 0, code, stderr)
+	assert.Regexp(t, `Replicas: +6\n`, stdout)
+	assert.Regexp(t, `Components: +`+east.Id+", "+west.Id+"\n", stdout)
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringmanager"
+)
+
+func nodeAdd(c *cli, args []string) error {
+	var req ringmanager.NodeAddRequest
+	fs := flag.NewFlagSet("node add", flag.ContinueOnError)
+	fs.StringVar(&req.RingId, "ring", "", "Ring of the node")
+	fs.StringVar(&req.Ip, "ip", "", "Address of the node")
+	fs.StringVar(&req.Port, "port", "", "Port of the storage server")
+	fs.IntVar(&req.Region, "region", 1, "Region of the node")
+	fs.IntVar(&req.Zone, "zone", 1, "Zone of the node")
+	fs.StringVar(&req.ReplicationIP, "replication-ip", "", "Replication address, defaults to --ip")
+	fs.StringVar(&req.ReplicationPort, "replication-port", "", "Replication port, defaults to --port")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if req.RingId == "" || req.Ip == "" || req.Port == "" {
+		return usagef("node add needs --ring, --ip and --port")
+	}
+
+	node, err := c.client.NodeAdd(&req)
+	if err != nil {
+		return err
+	}
+
+	return c.print(node, func(w io.Writer) {
+		printNode(w, node)
+	})
+}
+
+func nodeInfo(c *cli, args []string) error {
+	fs := flag.NewFlagSet("node info", flag.ContinueOnError)
+	ids, err := parseArgs(fs, args, "NODE")
+	if err != nil {
+		return err
+	}
+
+	node, err := c.client.NodeInfo(ids[0])
+	if err != nil {
+		return err
+	}
+
+	return c.print(node, func(w io.Writer) {
+		printNode(w, node)
+	})
+}
+
+func printNode(w io.Writer, node *ringmanager.NodeInfoResponse) {
+	fmt.Fprintf(w, "Id:\t%v\n", node.Id)
+	fmt.Fprintf(w, "Ring:\t%v\n", node.RingId)
+	fmt.Fprintf(w, "Region:\t%v\n", node.Region)
+	fmt.Fprintf(w, "Zone:\t%v\n", node.Zone)
+	fmt.Fprintf(w, "Address:\t%v:%v\n", node.Ip, node.Port)
+	fmt.Fprintf(w, "Replication address:\t%v:%v\n", node.ReplicationIP, node.ReplicationPort)
+	fmt.Fprintf(w, "Devices:\t%v\n", strings.Join(node.Devices, ", "))
+	if node.Drain != nil {
+		fmt.Fprintf(w, "Draining:\t%v devices, weight %v, %v partitions left\n",
+			node.Drain.Devices, node.Drain.Weight, node.Drain.Parts)
+	}
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringmanager"
+)
+
+func ringAdd(c *cli, args []string) error {
+	var req ringmanager.RingAddRequest
+	fs := flag.NewFlagSet("ring add", flag.ContinueOnError)
+	fs.StringVar(&req.ClusterId, "cluster", "", "Cluster of the ring")
+	fs.StringVar(&req.Name, "name", "", "Name of the ring, like object")
+	fs.IntVar(&req.PartPower, "part-power", ringmanager.RING_DEFAULT_PART_POWER, "Partition power")
+	fs.Float64Var(&req.Replicas, "replicas", 0,
+		"Number of replicas, by default 3 or one per erasure coded fragment")
+	fs.IntVar(&req.MinPartHours, "min-part-hours", ringmanager.RING_DEFAULT_MIN_PART_HOURS,
+		"Hours before a partition can move again")
+	fs.StringVar(&req.Type, "type", "", "account, container, object or component, by default given by the name")
+	components := fs.String("components", "", "Comma separated component rings of a composite ring")
+	var policy ringmanager.StoragePolicy
+	fs.StringVar(&policy.Name, "policy-name", "", "Name of the storage policy of an object ring")
+	aliases := fs.String("policy-aliases", "", "Comma separated aliases of the storage policy")
+	fs.StringVar(&policy.PolicyType, "policy-type", "", "Type of the storage policy")
+	fs.BoolVar(&policy.Default, "default", false, "Make the storage policy the default")
+	fs.BoolVar(&policy.Deprecated, "deprecated", false, "Deprecate the storage policy")
+	fs.StringVar(&policy.EcType, "ec-type", "", "Erasure coding backend of an erasure_coding policy")
+	fs.IntVar(&policy.EcNumDataFragments, "ec-data-fragments", 0, "Number of data fragments")
+	fs.IntVar(&policy.EcNumParityFragments, "ec-parity-fragments", 0, "Number of parity fragments")
+	fs.IntVar(&policy.EcObjectSegmentSize, "ec-segment-size", 0, "Object segment size in bytes")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if req.ClusterId == "" || req.Name == "" {
+		return usagef("ring add needs --cluster and --name")
+	}
+
+	req.Components = splitList(*components)
+
+	// The index of the policy is given by the name of the ring
+	policy.Aliases = splitList(*aliases)
+	if policy.EcType != "" && policy.PolicyType == "" {
+		policy.PolicyType = ringmanager.POLICY_TYPE_ERASURE_CODING
+	}
+	if policy.Name != "" || len(policy.Aliases) > 0 || policy.PolicyType != "" ||
+		policy.Default || policy.Deprecated {
+		policy.Index = policyIndex(req.Name)
+		req.Policy = &policy
+	}
+
+	ring, err := c.client.RingAdd(&req)
+	if err != nil {
+		return err
+	}
+
+	return c.print(ring, func(w io.Writer) {
+		printRing(w, ring)
+	})
+}
+
+// splitList returns the items of a comma separated list, if any
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	items := strings.Split(list, ",")
+	for i := range items {
+		items[i] = strings.TrimSpace(items[i])
+	}
+	return items
+}
+
+// policyIndex returns the index of the storage policy of the object ring,
+// 0 for object and N for object-N.  The manager checks the name.
+func policyIndex(name string) int {
+	index, err := strconv.Atoi(strings.TrimPrefix(name, "object-"))
+	if err != nil {
+		return 0
+	}
+	return index
+}
+
+func ringInfo(c *cli, args []string) error {
+	fs := flag.NewFlagSet("ring info", flag.ContinueOnError)
+	ids, err := parseArgs(fs, args, "RING")
+	if err != nil {
+		return err
+	}
+
+	ring, err := c.client.RingInfo(ids[0])
+	if err != nil {
+		return err
+	}
+
+	return c.print(ring, func(w io.Writer) {
+		printRing(w, ring)
+	})
+}
+
+func printRing(w io.Writer, ring *ringmanager.RingInfoResponse) {
+	fmt.Fprintf(w, "Id:\t%v\n", ring.Id)
+	fmt.Fprintf(w, "Name:\t%v\n", ring.Name)
+	fmt.Fprintf(w, "Cluster:\t%v\n", ring.ClusterId)
+	fmt.Fprintf(w, "Part power:\t%v\n", ring.PartPower)
+	fmt.Fprintf(w, "Replicas:\t%v\n", ring.Replicas)
+	fmt.Fprintf(w, "Min part hours:\t%v\n", ring.MinPartHours)
+	fmt.Fprintf(w, "Type:\t%v\n", ring.Type)
+	if ring.Policy != nil {
+		fmt.Fprintf(w, "Policy:\t%v %v\n", ring.Policy.Index, ring.Policy.Name)
+	}
+	if len(ring.Components) > 0 {
+		fmt.Fprintf(w, "Components:\t%v\n", strings.Join(ring.Components, ", "))
+	}
+	fmt.Fprintf(w, "Version:\t%v\n", ring.Version)
+	fmt.Fprintf(w, "Nodes:\t%v\n", strings.Join(ring.Nodes, ", "))
+}
+
+// ringReport prints the placement of the partitions the way
+// swift-ring-builder does with its default and dispersion commands
+func ringReport(c *cli, args []string) error {
+	fs := flag.NewFlagSet("ring report", flag.ContinueOnError)
+	ids, err := parseArgs(fs, args, "RING")
+	if err != nil {
+		return err
+	}
+
+	report, err := c.client.RingReport(ids[0])
+	if err != nil {
+		return err
+	}
+
+	return c.print(report, func(w io.Writer) {
+		fmt.Fprintf(w, "Ring %v version %v: %v partitions, %v replicas\n",
+			report.Name, report.Version, report.Parts, report.Replicas)
+		fmt.Fprintf(w, "Balance %.2f, dispersion %.2f\n", report.Balance, report.Dispersion)
+		if report.LastMoved != nil {
+			fmt.Fprintf(w, "Last moved %v\n", report.LastMoved.Format(time.RFC3339))
+		}
+		fmt.Fprintln(w)
+
+		fmt.Fprintln(w, "TIER\tDOMAINS\tPARTS AT RISK\tDISPERSION\tPARTS SAME DOMAIN")
+		for _, tier := range report.Tiers {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%.2f\t%v\n", tier.Tier, tier.Domains,
+				tier.PartsAtRisk, tier.Dispersion, tier.PartsSameDomain)
+		}
+		fmt.Fprintln(w)
+
+		fmt.Fprintln(w, "ID\tREGION\tZONE\tADDRESS\tDEVICE\tWEIGHT\tPARTS\tBALANCE\tLAST MOVED")
+		for _, d := range report.Devices {
+			lastMoved := "-"
+			if d.LastMoved != nil {
+				lastMoved = d.LastMoved.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v:%v\t%v\t%v\t%v\t%.2f\t%v\n", d.Id, d.Region, d.Zone,
+				d.Ip, d.Port, d.Device, d.Weight, d.Parts, d.Balance, lastMoved)
+		}
+	})
+}
+
+func ringDelete(c *cli, args []string) error {
+	fs := flag.NewFlagSet("ring delete", flag.ContinueOnError)
+	ids, err := parseArgs(fs, args, "RING")
+	if err != nil {
+		return err
+	}
+
+	err = c.client.RingDelete(ids[0])
+	if err != nil {
+		return err
+	}
+
+	return c.print(map[string]string{"id": ids[0]}, func(w io.Writer) {
+		fmt.Fprintf(w, "Deleted ring %v\n", ids[0])
+	})
+}
@@ -43,6 +43,11 @@ func loadDefaultConfigOptions(v *viper.Viper) {
 	v.SetDefault("ringmanager_dir", "/var/lib/ringmanager")
 	v.SetDefault("bind_ip", "127.0.0.1")
 	v.SetDefault("bind_port", "8090")
+	v.SetDefault("ring_builder_backend", "native")
+	v.SetDefault("swift_ring_builder_path", "/usr/bin/swift-ring-builder")
+	v.SetDefault("weight_step", "")
+	v.SetDefault("ring_versions", 100)
+	v.SetDefault("partition_size", 1073741824)
 
 }
 
@@ -52,6 +57,9 @@ func main() {
 		panic(fmt.Errorf("Fatal error loading config file: %s", err))
 	}
 	addr := v.GetString("bind_ip") + ":" + v.GetString("bind_port")
-	router := ringmanager.NewRouter(v)
+	router, err := ringmanager.NewRouter(v)
+	if err != nil {
+		panic(fmt.Errorf("Fatal error in config file: %s", err))
+	}
 	log.Fatal(http.ListenAndServe(addr, router))
 }
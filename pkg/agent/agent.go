@@ -0,0 +1,252 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/thiagodasilva/swift-ring-manager/pkg/client"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringbuilder"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringmanager"
+)
+
+// Config of the ring distribution agent of a storage node
+type Config struct {
+	// Url of the ring manager, like http://ringmanager:8090
+	ManagerUrl string
+
+	// Cluster the node belongs to
+	ClusterId string
+
+	// Ip or id of the node, as known by the manager
+	Node string
+
+	// Directory the rings are installed to, usually /etc/swift
+	SwiftDir string
+
+	// Time between two syncs
+	Interval time.Duration
+}
+
+// Agent installs the rings of its cluster on a storage node
+type Agent struct {
+	config Config
+	client *client.Client
+
+	// Rings installed by name
+	installed map[string]*ringmanager.AgentRingStatus
+}
+
+func NewAgent(config Config) *Agent {
+	return &Agent{
+		config:    config,
+		client:    client.NewClient(config.ManagerUrl),
+		installed: make(map[string]*ringmanager.AgentRingStatus),
+	}
+}
+
+// Run syncs the rings every interval until stop is closed
+func (a *Agent) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(a.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		err := a.Sync()
+		if err != nil {
+			log.Printf("Unable to sync rings: %v", err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync installs the rings of the cluster that changed since the last
+// sync, and reports the rings installed to the manager
+func (a *Agent) Sync() error {
+	names, err := a.ringNames()
+	if err != nil {
+		return err
+	}
+
+	var syncErr error
+	for _, name := range names {
+		err := a.syncRing(name)
+		if err != nil {
+			log.Printf("Unable to sync ring %v: %v", name, err)
+			if syncErr == nil {
+				syncErr = err
+			}
+		}
+	}
+
+	err = a.report()
+	if err != nil {
+		return err
+	}
+
+	return syncErr
+}
+
+// Installed returns the rings installed on the node
+func (a *Agent) Installed() []*ringmanager.AgentRingStatus {
+	rings := make([]*ringmanager.AgentRingStatus, 0, len(a.installed))
+	for _, status := range a.installed {
+		ring := *status
+		rings = append(rings, &ring)
+	}
+	sort.Slice(rings, func(i, j int) bool {
+		return rings[i].Name < rings[j].Name
+	})
+
+	return rings
+}
+
+// ringNames returns the names of the rings of the cluster swift loads.
+// Component rings are only used to build their composite ring.
+func (a *Agent) ringNames() ([]string, error) {
+	cluster, err := a.client.ClusterInfo(a.config.ClusterId)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(cluster.Rings))
+	for _, id := range cluster.Rings {
+		ring, err := a.client.RingInfo(id)
+		if err != nil {
+			return nil, err
+		}
+		if ring.Type == ringmanager.RING_TYPE_COMPONENT {
+			continue
+		}
+		names = append(names, ring.Name)
+	}
+
+	return names, nil
+}
+
+// syncRing downloads the ring if it differs from the installed one, and
+// swaps it into the swift directory once verified
+func (a *Agent) syncRing(name string) error {
+	path := filepath.Join(a.config.SwiftDir, name+".ring.gz")
+
+	// The ring may already be there from a previous run
+	status, ok := a.installed[name]
+	if !ok {
+		if hash, err := fileHash(path); err == nil {
+			status = &ringmanager.AgentRingStatus{Name: name, Etag: hash}
+		}
+	}
+
+	opts := &client.DownloadRingOptions{Node: a.config.Node}
+	if status != nil {
+		opts.IfNoneMatch = status.Etag
+	}
+	download, err := a.client.DownloadRing(a.config.ClusterId, name, opts)
+	if client.IsNotFound(err) {
+		// Not built yet
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if download.NotModified {
+		status.Version = download.Version
+		a.installed[name] = status
+		return nil
+	}
+	defer download.Body.Close()
+
+	err = installRing(path, download.Body, download.Etag)
+	if err != nil {
+		return err
+	}
+
+	a.installed[name] = &ringmanager.AgentRingStatus{
+		Name:    name,
+		Etag:    download.Etag,
+		Version: download.Version,
+	}
+	log.Printf("Installed version %v of ring %v", download.Version, name)
+
+	return nil
+}
+
+// installRing writes the ring next to path, checks it has the expected
+// sha256 and is a valid ring, and atomically replaces path with it
+func installRing(path string, body io.Reader, etag string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), body)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != etag {
+		return fmt.Errorf("Checksum %v of the download does not match %v", sum, etag)
+	}
+	_, err = ringbuilder.LoadRingData(tmp.Name())
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// report tells the manager which rings are installed
+func (a *Agent) report() error {
+	msg := ringmanager.AgentReportRequest{
+		Node:  a.config.Node,
+		Rings: a.Installed(),
+	}
+	return a.client.DeploymentReport(a.config.ClusterId, &msg)
+}
+
+func fileHash(path string) (string, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fp.Close()
+
+	return ringmanager.FileHash(fp)
+}
@@ -0,0 +1,283 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringbuilder"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringmanager"
+)
+
+func setupManager(t *testing.T) (*httptest.Server, func()) {
+	dir, err := ioutil.TempDir("", "ringmanager")
+	assert.Nil(t, err)
+
+	v := viper.New()
+	v.Set("dbfilename", "swift_clusters.db")
+	v.Set("ringmanager_dir", dir)
+	router, err := ringmanager.NewRouter(v)
+	assert.Nil(t, err)
+	ts := httptest.NewServer(router)
+
+	return ts, func() {
+		ts.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func postJson(t *testing.T, url, body string, v interface{}) {
+	r, err := http.Post(url, "application/json", bytes.NewBufferString(body))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, r.StatusCode)
+	err = ringmanager.GetJsonFromResponse(r, v)
+	assert.Nil(t, err)
+}
+
+// setupCluster creates a cluster with an object ring of three zones
+func setupCluster(t *testing.T, ts *httptest.Server) string {
+	var cluster ringmanager.ClusterInfoResponse
+	postJson(t, ts.URL+"/clusters", "{}", &cluster)
+
+	var ring ringmanager.RingInfo
+	postJson(t, ts.URL+"/rings",
+		`{"name":"object", "cluster":"`+cluster.Id+`", "min_part_hours":0}`, &ring)
+
+	for z := 1; z <= 3; z++ {
+		var node ringmanager.NodeInfo
+		postJson(t, ts.URL+"/nodes", fmt.Sprintf(
+			`{"ring":"%v", "ip":"127.0.0.%d", "port":"6010", "zone":%d}`, ring.Id, z, z), &node)
+		var device ringmanager.DeviceInfo
+		postJson(t, ts.URL+"/devices",
+			`{"node":"`+node.Id+`", "name":"sdb1", "weight":100}`, &device)
+	}
+
+	return cluster.Id
+}
+
+func buildRings(t *testing.T, ts *httptest.Server, clusterId string) {
+	r, err := http.Post(ts.URL+"/buildring/"+clusterId, "application/json", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusAccepted, r.StatusCode)
+	location, err := r.Location()
+	assert.Nil(t, err)
+
+	for {
+		r, err = http.Get(location.String())
+		assert.Nil(t, err)
+		if r.Header.Get("X-Pending") != "true" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var job ringmanager.BuildJobResponse
+	err = ringmanager.GetJsonFromResponse(r, &job)
+	assert.Nil(t, err)
+	assert.Equal(t, ringmanager.BUILD_JOB_COMPLETED, job.Status, job.Error)
+}
+
+func newTestAgent(t *testing.T, url, clusterId string) (*Agent, string) {
+	swiftDir, err := ioutil.TempDir("", "swift")
+	assert.Nil(t, err)
+
+	return NewAgent(Config{
+		ManagerUrl: url,
+		ClusterId:  clusterId,
+		Node:       "127.0.0.1",
+		SwiftDir:   swiftDir,
+		Interval:   time.Minute,
+	}), swiftDir
+}
+
+func TestAgentSync(t *testing.T) {
+	ts, tearDown := setupManager(t)
+	defer tearDown()
+
+	clusterId := setupCluster(t, ts)
+	a, swiftDir := newTestAgent(t, ts.URL, clusterId)
+	defer os.RemoveAll(swiftDir)
+
+	// Nothing to install before the first build
+	err := a.Sync()
+	assert.Nil(t, err)
+	assert.Empty(t, a.Installed())
+
+	buildRings(t, ts, clusterId)
+	err = a.Sync()
+	assert.Nil(t, err)
+
+	installed := a.Installed()
+	assert.Equal(t, 1, len(installed))
+	assert.Equal(t, "object", installed[0].Name)
+	assert.Equal(t, 1, installed[0].Version)
+
+	path := filepath.Join(swiftDir, "object.ring.gz")
+	_, err = ringbuilder.LoadRingData(path)
+	assert.Nil(t, err)
+	hash, err := fileHash(path)
+	assert.Nil(t, err)
+	assert.Equal(t, hash, installed[0].Etag)
+
+	// No temporary files are left behind
+	files, err := ioutil.ReadDir(swiftDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(files))
+
+	// The manager knows what the node has installed
+	r, err := http.Get(ts.URL + "/clusters/" + clusterId + "/deployment")
+	assert.Nil(t, err)
+	var deployment ringmanager.DeploymentResponse
+	err = ringmanager.GetJsonFromResponse(r, &deployment)
+	assert.Nil(t, err)
+	for _, node := range deployment.Rings[0].Nodes {
+		if node.Ip == "127.0.0.1" {
+			assert.Equal(t, ringmanager.DEPLOYMENT_CURRENT, node.Status)
+			assert.Equal(t, hash, node.ReportedEtag)
+		} else {
+			assert.Equal(t, ringmanager.DEPLOYMENT_NEVER_SYNCED, node.Status)
+		}
+	}
+
+	// An agent restarting picks up the installed ring
+	a, _ = newTestAgent(t, ts.URL, clusterId)
+	a.config.SwiftDir = swiftDir
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	err = a.Sync()
+	assert.Nil(t, err)
+	assert.Equal(t, installed, a.Installed())
+	after, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.True(t, os.SameFile(info, after))
+}
+
+func TestAgentSyncBadChecksum(t *testing.T) {
+	builder, err := ringbuilder.NewRingBuilder(8, 3, 0)
+	assert.Nil(t, err)
+	ringFile, err := ioutil.TempFile("", "object.ring.gz")
+	assert.Nil(t, err)
+	ringFile.Close()
+	defer os.Remove(ringFile.Name())
+	err = builder.GetRing().Save(ringFile.Name())
+	assert.Nil(t, err)
+	data, err := ioutil.ReadFile(ringFile.Name())
+	assert.Nil(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/clusters/abc", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&ringmanager.ClusterInfoResponse{Id: "abc", Rings: []string{"123"}})
+	})
+	mux.HandleFunc("/rings/123", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&ringmanager.RingInfo{
+			Id:             "123",
+			RingAddRequest: ringmanager.RingAddRequest{Name: "object"},
+		})
+	})
+	mux.HandleFunc("/downloadring/abc/object", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Etag", "0123456789abcdef")
+		w.Write(data)
+	})
+	reported := false
+	mux.HandleFunc("/clusters/abc/deployment", func(w http.ResponseWriter, r *http.Request) {
+		reported = true
+		w.WriteHeader(http.StatusNoContent)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	a, swiftDir := newTestAgent(t, ts.URL, "abc")
+	defer os.RemoveAll(swiftDir)
+
+	err = a.Sync()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "does not match")
+	assert.True(t, reported)
+	assert.Empty(t, a.Installed())
+
+	files, err := ioutil.ReadDir(swiftDir)
+	assert.Nil(t, err)
+	assert.Empty(t, files)
+}
+
+func TestAgentSyncComposite(t *testing.T) {
+	builder, err := ringbuilder.NewRingBuilder(8, 3, 0)
+	assert.Nil(t, err)
+	for z := 1; z <= 3; z++ {
+		_, err = builder.AddDev(&ringbuilder.Device{Region: 1, Zone: z, Ip: fmt.Sprintf("10.0.1.%d", z),
+			Port: 6010, Device: "sdb1", Weight: 100})
+		assert.Nil(t, err)
+	}
+	_, err = builder.Rebalance(1)
+	assert.Nil(t, err)
+	ringFile, err := ioutil.TempFile("", "object-1.ring.gz")
+	assert.Nil(t, err)
+	ringFile.Close()
+	defer os.Remove(ringFile.Name())
+	err = builder.GetRing().Save(ringFile.Name())
+	assert.Nil(t, err)
+	data, err := ioutil.ReadFile(ringFile.Name())
+	assert.Nil(t, err)
+	hash, err := fileHash(ringFile.Name())
+	assert.Nil(t, err)
+
+	// Swift loads the composite ring, never its components
+	mux := http.NewServeMux()
+	mux.HandleFunc("/clusters/abc", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&ringmanager.ClusterInfoResponse{Id: "abc", Rings: []string{"123", "456"}})
+	})
+	mux.HandleFunc("/rings/123", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&ringmanager.RingInfo{
+			Id:             "123",
+			RingAddRequest: ringmanager.RingAddRequest{Name: "east", Type: ringmanager.RING_TYPE_COMPONENT},
+		})
+	})
+	mux.HandleFunc("/rings/456", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&ringmanager.RingInfo{
+			Id:             "456",
+			RingAddRequest: ringmanager.RingAddRequest{Name: "object-1", Components: []string{"123"}},
+		})
+	})
+	mux.HandleFunc("/downloadring/abc/object-1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Etag", hash)
+		w.Write(data)
+	})
+	mux.HandleFunc("/clusters/abc/deployment", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	a, swiftDir := newTestAgent(t, ts.URL, "abc")
+	defer os.RemoveAll(swiftDir)
+
+	err = a.Sync()
+	assert.Nil(t, err)
+	installed := a.Installed()
+	assert.Equal(t, 1, len(installed))
+	assert.Equal(t, "object-1", installed[0].Name)
+}
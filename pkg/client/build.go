@@ -0,0 +1,162 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringmanager"
+)
+
+// BuildRing builds the rings of the cluster and waits for the build job
+// to finish.  A failed build returns the job along with the error.
+func (c *Client) BuildRing(clusterId, author string) (*ringmanager.BuildJobResponse, error) {
+	query := url.Values{}
+	if author != "" {
+		query.Set("author", author)
+	}
+	return c.runBuildJob(clusterId, query)
+}
+
+// BuildRingDryRun estimates what a build of the cluster would move,
+// without changing its rings.  A partitionSize of 0 uses the average
+// partition size configured in the manager.
+func (c *Client) BuildRingDryRun(clusterId string, partitionSize uint64) (*ringmanager.BuildJobResponse, error) {
+	query := url.Values{}
+	query.Set("dry_run", "true")
+	if partitionSize != 0 {
+		query.Set("partition_size", strconv.FormatUint(partitionSize, 10))
+	}
+	return c.runBuildJob(clusterId, query)
+}
+
+// runBuildJob starts the build job and waits for it to finish
+func (c *Client) runBuildJob(clusterId string, query url.Values) (*ringmanager.BuildJobResponse, error) {
+	r, err := c.do("POST", "/buildring/"+clusterId+"?"+query.Encode(), nil, http.StatusAccepted)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	location, err := r.Location()
+	if err != nil {
+		return nil, err
+	}
+	jobId := path.Base(location.Path)
+
+	// The queue redirects to the job once done
+	for {
+		r, err := c.do("GET", location.Path, nil, http.StatusOK)
+		if IsServerError(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		r.Body.Close()
+		if r.Header.Get("X-Pending") != "true" {
+			break
+		}
+		time.Sleep(c.pollDelay)
+	}
+
+	job, err := c.BuildJob(jobId)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status == ringmanager.BUILD_JOB_FAILED {
+		return job, &Error{
+			StatusCode: http.StatusInternalServerError,
+			Message:    job.Error,
+			Err:        ErrServer,
+		}
+	}
+	return job, nil
+}
+
+func (c *Client) BuildJob(id string) (*ringmanager.BuildJobResponse, error) {
+	var job ringmanager.BuildJobResponse
+	err := c.doJson("GET", "/buildjobs/"+id, nil, http.StatusOK, &job)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+type DownloadRingOptions struct {
+	// Only download the ring if it does not have this etag
+	IfNoneMatch string
+
+	// Address of the storage node downloading the ring, to track which
+	// ring it has
+	Node string
+}
+
+// RingDownload is a ring file served by the manager.  The caller closes
+// the body.
+type RingDownload struct {
+	Etag    string
+	Version int
+
+	// The ring still has the etag asked for, and there is no body
+	NotModified bool
+	Body        io.ReadCloser
+}
+
+// DownloadRing downloads the ring file of the cluster
+func (c *Client) DownloadRing(clusterId, ring string, opts *DownloadRingOptions) (*RingDownload, error) {
+	if opts == nil {
+		opts = &DownloadRingOptions{}
+	}
+
+	query := url.Values{}
+	if opts.Node != "" {
+		query.Set("node", opts.Node)
+	}
+	req, err := http.NewRequest("GET",
+		c.host+"/downloadring/"+clusterId+"/"+ring+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if opts.IfNoneMatch != "" {
+		req.Header.Set("If-None-Match", opts.IfNoneMatch)
+	}
+
+	r, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK && r.StatusCode != http.StatusNotModified {
+		defer r.Body.Close()
+		return nil, newError(r)
+	}
+
+	download := &RingDownload{
+		Etag:        r.Header.Get("Etag"),
+		NotModified: r.StatusCode == http.StatusNotModified,
+		Body:        r.Body,
+	}
+	download.Version, _ = strconv.Atoi(r.Header.Get("X-Ring-Version"))
+	if download.NotModified {
+		r.Body.Close()
+		download.Body = nil
+	}
+
+	return download, nil
+}
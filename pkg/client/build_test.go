@@ -0,0 +1,140 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringmanager"
+)
+
+func TestClientBuildRing(t *testing.T) {
+	c, tearDown := setupClient(t)
+	defer tearDown()
+
+	cluster, err := c.ClusterCreate()
+	assert.Nil(t, err)
+	ring := setupTopology(t, c, cluster.Id, 3)
+
+	// Nothing to download or report on before a build
+	_, err = c.DownloadRing(cluster.Id, "object", nil)
+	assert.True(t, IsNotFound(err))
+	_, err = c.RingReport(ring.Id)
+	assert.True(t, IsNotFound(err))
+
+	job, err := c.BuildRing(cluster.Id, "admin")
+	assert.Nil(t, err)
+	assert.Equal(t, ringmanager.BUILD_JOB_COMPLETED, job.Status)
+	assert.Equal(t, "admin", job.Author)
+	assert.Equal(t, 1, len(job.Result.Rings))
+	assert.Equal(t, 1, job.Result.Rings[0].Version)
+
+	same, err := c.BuildJob(job.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, job, same)
+
+	report, err := c.RingReport(ring.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.Version)
+	assert.Equal(t, 3, len(report.Devices))
+	assert.Equal(t, 4, len(report.Tiers))
+
+	download, err := c.DownloadRing(cluster.Id, "object", &DownloadRingOptions{Node: "127.0.0.1"})
+	assert.Nil(t, err)
+	assert.False(t, download.NotModified)
+	assert.Equal(t, 1, download.Version)
+	assert.NotEmpty(t, download.Etag)
+	data, err := ioutil.ReadAll(download.Body)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, data)
+	download.Body.Close()
+
+	notModified, err := c.DownloadRing(cluster.Id, "object", &DownloadRingOptions{
+		IfNoneMatch: download.Etag,
+	})
+	assert.Nil(t, err)
+	assert.True(t, notModified.NotModified)
+	assert.Nil(t, notModified.Body)
+	assert.Equal(t, download.Etag, notModified.Etag)
+
+	deployment, err := c.Deployment(cluster.Id)
+	assert.Nil(t, err)
+	assert.False(t, deployment.Current)
+
+	err = c.DeploymentReport(cluster.Id, &ringmanager.AgentReportRequest{
+		Node: "127.0.0.2",
+		Rings: []*ringmanager.AgentRingStatus{
+			&ringmanager.AgentRingStatus{Name: "object", Etag: download.Etag, Version: 1},
+		},
+	})
+	assert.Nil(t, err)
+
+	// A dry run keeps the version
+	dryRun, err := c.BuildRingDryRun(cluster.Id, 1000)
+	assert.Nil(t, err)
+	assert.True(t, dryRun.Result.DryRun)
+	assert.Equal(t, uint64(1000), dryRun.Result.PartitionSize)
+	assert.Equal(t, 0, dryRun.Result.Rings[0].Estimate.ReplicasMoved)
+	report, err = c.RingReport(ring.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.Version)
+
+	// Second version with a new device, then back to the first
+	node, err := c.NodeAdd(&ringmanager.NodeAddRequest{RingId: ring.Id, Ip: "127.0.0.4", Port: "6010", Zone: 4})
+	assert.Nil(t, err)
+	req := &ringmanager.DeviceAddRequest{NodeId: node.Id, Weight: 100}
+	req.Name = "sdb1"
+	_, err = c.DeviceAdd(req)
+	assert.Nil(t, err)
+	_, err = c.BuildRing(cluster.Id, "")
+	assert.Nil(t, err)
+	versions, err := c.RingVersionList(ring.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(versions.Versions))
+
+	version, err := c.RingVersionInfo(ring.Id, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "admin", version.Author)
+
+	ring, err = c.RingRollback(ring.Id, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, ring.Version)
+
+	_, err = c.RingVersionInfo(ring.Id, 3)
+	assert.True(t, IsNotFound(err))
+}
+
+func TestClientBuildRingFailed(t *testing.T) {
+	c, tearDown := setupClient(t)
+	defer tearDown()
+
+	cluster, err := c.ClusterCreate()
+	assert.Nil(t, err)
+
+	// No devices to build the ring with
+	_, err = c.RingAdd(&ringmanager.RingAddRequest{ClusterId: cluster.Id, Name: "object"})
+	assert.Nil(t, err)
+
+	job, err := c.BuildRing(cluster.Id, "")
+	assert.True(t, IsServerError(err))
+	assert.Equal(t, ringmanager.BUILD_JOB_FAILED, job.Status)
+	assert.Equal(t, job.Error, err.Error())
+
+	_, err = c.BuildRing("123", "")
+	assert.True(t, IsNotFound(err))
+}
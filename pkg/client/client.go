@@ -0,0 +1,174 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringmanager"
+)
+
+var (
+	ErrBadRequest = errors.New("Bad request")
+	ErrNotFound   = errors.New("Id not found")
+	ErrConflict   = errors.New("Conflict")
+	ErrServer     = errors.New("Server error")
+)
+
+// Error is a request refused by the ring manager.  Err is one of the
+// errors above, depending on the status code of the response.
+type Error struct {
+	StatusCode int
+	Message    string
+	Err        error
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// IsNotFound tells if the request failed because an id does not exist
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Err == ErrNotFound
+}
+
+// IsConflict tells if the request failed because the target is in use or
+// already exists
+func IsConflict(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Err == ErrConflict
+}
+
+// IsBadRequest tells if the server refused the content of the request
+func IsBadRequest(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Err == ErrBadRequest
+}
+
+// IsServerError tells if the server failed to handle the request
+func IsServerError(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Err == ErrServer
+}
+
+func newError(r *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(r.Body, 4096))
+	e := &Error{
+		StatusCode: r.StatusCode,
+		Message:    strings.TrimSpace(string(body)),
+	}
+
+	switch r.StatusCode {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		e.Err = ErrBadRequest
+	case http.StatusNotFound:
+		e.Err = ErrNotFound
+	case http.StatusConflict:
+		e.Err = ErrConflict
+	default:
+		e.Err = ErrServer
+		if e.Message == "" {
+			e.Message = r.Status
+		}
+	}
+
+	return e
+}
+
+// Client of the ring manager REST API
+type Client struct {
+	host   string
+	client *http.Client
+
+	// Time between two checks of a build job
+	pollDelay time.Duration
+}
+
+// NewClient returns a client of the ring manager at host, like
+// http://localhost:8090
+func NewClient(host string) *Client {
+	return &Client{
+		host:      strings.TrimRight(host, "/"),
+		client:    &http.Client{Timeout: time.Minute},
+		pollDelay: time.Second,
+	}
+}
+
+// do sends the request with the body encoded in json, and fails unless
+// the response has the expected status
+func (c *Client) do(method, path string, body interface{}, expected int) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(buf)
+	}
+
+	req, err := http.NewRequest(method, c.host+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	r, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != expected {
+		defer r.Body.Close()
+		return nil, newError(r)
+	}
+
+	return r, nil
+}
+
+// doJson sends the request and decodes the json response into result
+func (c *Client) doJson(method, path string, body interface{}, expected int, result interface{}) error {
+	r, err := c.do(method, path, body, expected)
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		r.Body.Close()
+		return nil
+	}
+
+	err = ringmanager.GetJsonFromResponse(r, result)
+	if err != nil {
+		return fmt.Errorf("Unable to decode the response: %v", err)
+	}
+	return nil
+}
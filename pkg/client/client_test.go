@@ -0,0 +1,227 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringmanager"
+)
+
+func setupClient(t *testing.T) (*Client, func()) {
+	dir, err := ioutil.TempDir("", "ringmanager")
+	assert.Nil(t, err)
+
+	v := viper.New()
+	v.Set("dbfilename", "swift_clusters.db")
+	v.Set("ringmanager_dir", dir)
+	router, err := ringmanager.NewRouter(v)
+	assert.Nil(t, err)
+	ts := httptest.NewServer(router)
+
+	c := NewClient(ts.URL)
+	c.pollDelay = 10 * time.Millisecond
+
+	return c, func() {
+		ts.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// setupTopology adds a ring with one node per zone and one device per node
+func setupTopology(t *testing.T, c *Client, clusterId string, zones int) *ringmanager.RingInfoResponse {
+	ring, err := c.RingAdd(&ringmanager.RingAddRequest{
+		ClusterId: clusterId,
+		Name:      "object",
+		PartPower: 8,
+	})
+	assert.Nil(t, err)
+
+	for z := 1; z <= zones; z++ {
+		node, err := c.NodeAdd(&ringmanager.NodeAddRequest{
+			RingId: ring.Id,
+			Ip:     fmt.Sprintf("127.0.0.%d", z),
+			Port:   "6010",
+			Zone:   z,
+		})
+		assert.Nil(t, err)
+
+		req := &ringmanager.DeviceAddRequest{NodeId: node.Id, Weight: 100}
+		req.Name = "sdb1"
+		_, err = c.DeviceAdd(req)
+		assert.Nil(t, err)
+	}
+
+	return ring
+}
+
+func TestClientCluster(t *testing.T) {
+	c, tearDown := setupClient(t)
+	defer tearDown()
+
+	cluster, err := c.ClusterCreate()
+	assert.Nil(t, err)
+	assert.NotEmpty(t, cluster.Id)
+
+	list, err := c.ClusterList()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{cluster.Id}, list.Clusters)
+
+	ring := setupTopology(t, c, cluster.Id, 3)
+	assert.Equal(t, "object", ring.Name)
+	assert.Equal(t, 8, ring.PartPower)
+
+	info, err := c.ClusterInfo(cluster.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{ring.Id}, []string(info.Rings))
+
+	conf, err := c.SwiftConf(cluster.Id)
+	assert.Nil(t, err)
+	assert.Contains(t, conf, "[storage-policy:0]\nname = Policy-0\n")
+
+	ring, err = c.RingInfo(ring.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(ring.Nodes))
+
+	node, err := c.NodeInfo(ring.Nodes[0])
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(node.Devices))
+
+	// Cluster still has rings
+	_, err = c.ClusterDelete(cluster.Id, false, false)
+	assert.True(t, IsConflict(err))
+
+	deleted, err := c.ClusterDelete(cluster.Id, true, true)
+	assert.Nil(t, err)
+	assert.True(t, deleted.DryRun)
+	assert.Equal(t, 3, len(deleted.Devices))
+
+	deleted, err = c.ClusterDelete(cluster.Id, true, false)
+	assert.Nil(t, err)
+	assert.False(t, deleted.DryRun)
+
+	_, err = c.ClusterInfo(cluster.Id)
+	assert.True(t, IsNotFound(err))
+}
+
+func TestClientClusterImport(t *testing.T) {
+	c, tearDown := setupClient(t)
+	defer tearDown()
+
+	cluster, err := c.ClusterCreate()
+	assert.Nil(t, err)
+
+	fp, err := os.Open("../ringbuilder/testdata/object.ring.gz")
+	assert.Nil(t, err)
+	defer fp.Close()
+
+	minPartHours := 2
+	ring, err := c.ClusterImport(cluster.Id, fp, &ImportOptions{
+		Name:         "object",
+		MinPartHours: &minPartHours,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, ring.PartPower)
+	assert.Equal(t, 2, ring.MinPartHours)
+	assert.Equal(t, 1, ring.Version)
+	assert.Equal(t, 3, len(ring.Devices))
+
+	_, err = c.ClusterImport(cluster.Id, strings.NewReader("garbage"), &ImportOptions{Name: "object-1"})
+	assert.True(t, IsBadRequest(err))
+}
+
+func TestClientNodeDevice(t *testing.T) {
+	c, tearDown := setupClient(t)
+	defer tearDown()
+
+	cluster, err := c.ClusterCreate()
+	assert.Nil(t, err)
+	ring := setupTopology(t, c, cluster.Id, 1)
+	ring, err = c.RingInfo(ring.Id)
+	assert.Nil(t, err)
+
+	ip := "10.0.0.1"
+	node, err := c.NodeUpdate(ring.Nodes[0], &ringmanager.NodeUpdateRequest{Ip: &ip})
+	assert.Nil(t, err)
+	assert.Equal(t, ip, node.Ip)
+
+	name := "sdc1"
+	device, err := c.DeviceUpdate(node.Devices[0], &ringmanager.DeviceUpdateRequest{Name: &name})
+	assert.Nil(t, err)
+	assert.Equal(t, name, device.Name)
+
+	device, err = c.DeviceSetWeight(device.Id, 200)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(200), device.Weight.Target)
+
+	device, err = c.DeviceInfo(device.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(200), device.Weight.Target)
+
+	node, err = c.NodeDrain(node.Id)
+	assert.Nil(t, err)
+	assert.NotNil(t, node.Drain)
+
+	err = c.DeviceDelete(device.Id, false)
+	assert.Nil(t, err)
+	_, err = c.DeviceInfo(device.Id)
+	assert.True(t, IsNotFound(err))
+
+	err = c.NodeDelete(node.Id, false)
+	assert.Nil(t, err)
+	err = c.RingDelete(ring.Id)
+	assert.Nil(t, err)
+}
+
+func TestClientErrors(t *testing.T) {
+	c, tearDown := setupClient(t)
+	defer tearDown()
+
+	_, err := c.RingInfo("123")
+	assert.True(t, IsNotFound(err))
+	e, ok := err.(*Error)
+	assert.True(t, ok)
+	assert.Equal(t, 404, e.StatusCode)
+	assert.Equal(t, ringmanager.ErrNotFound.Error(), e.Message)
+
+	// Ring name missing
+	cluster, err := c.ClusterCreate()
+	assert.Nil(t, err)
+	_, err = c.RingAdd(&ringmanager.RingAddRequest{ClusterId: cluster.Id})
+	assert.True(t, IsBadRequest(err))
+	assert.Equal(t, "Ring name missing", err.Error())
+
+	// Same ring twice
+	_, err = c.RingAdd(&ringmanager.RingAddRequest{ClusterId: cluster.Id, Name: "object"})
+	assert.Nil(t, err)
+	_, err = c.RingAdd(&ringmanager.RingAddRequest{ClusterId: cluster.Id, Name: "object"})
+	assert.True(t, IsConflict(err))
+
+	// Nobody listening
+	c = NewClient("http://127.0.0.1:1")
+	_, err = c.ClusterList()
+	assert.NotNil(t, err)
+	_, ok = err.(*Error)
+	assert.False(t, ok)
+}
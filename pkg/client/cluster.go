@@ -0,0 +1,180 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringmanager"
+)
+
+func (c *Client) ClusterCreate() (*ringmanager.ClusterInfoResponse, error) {
+	var cluster ringmanager.ClusterInfoResponse
+	err := c.doJson("POST", "/clusters", struct{}{}, http.StatusCreated, &cluster)
+	if err != nil {
+		return nil, err
+	}
+	return &cluster, nil
+}
+
+func (c *Client) ClusterList() (*ringmanager.ClusterListResponse, error) {
+	var list ringmanager.ClusterListResponse
+	err := c.doJson("GET", "/clusters", nil, http.StatusOK, &list)
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func (c *Client) ClusterInfo(id string) (*ringmanager.ClusterInfoResponse, error) {
+	var cluster ringmanager.ClusterInfoResponse
+	err := c.doJson("GET", "/clusters/"+id, nil, http.StatusOK, &cluster)
+	if err != nil {
+		return nil, err
+	}
+	return &cluster, nil
+}
+
+// ClusterDelete deletes the cluster.  With cascade the rings, nodes and
+// devices of the cluster go too, and with dryRun nothing is deleted but
+// the response lists what would be.
+func (c *Client) ClusterDelete(id string, cascade, dryRun bool) (*ringmanager.ClusterDeleteResponse, error) {
+	query := url.Values{}
+	query.Set("cascade", strconv.FormatBool(cascade))
+	query.Set("dry_run", strconv.FormatBool(dryRun))
+
+	var response ringmanager.ClusterDeleteResponse
+	err := c.doJson("DELETE", "/clusters/"+id+"?"+query.Encode(), nil, http.StatusOK, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// ClusterExport returns the topology of the cluster
+func (c *Client) ClusterExport(id string) (*ringmanager.Topology, error) {
+	var topology ringmanager.Topology
+	err := c.doJson("GET", "/clusters/"+id+"/export", nil, http.StatusOK, &topology)
+	if err != nil {
+		return nil, err
+	}
+	return &topology, nil
+}
+
+// SwiftConf returns the storage policy sections of swift.conf for the
+// object rings of the cluster
+func (c *Client) SwiftConf(id string) (string, error) {
+	r, err := c.do("GET", "/clusters/"+id+"/swift.conf", nil, http.StatusOK)
+	if err != nil {
+		return "", err
+	}
+	defer r.Body.Close()
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// ClusterApply brings the cluster to the topology.  With plan nothing
+// changes but the response lists what would, and with force devices that
+// still hold partitions can be removed.
+func (c *Client) ClusterApply(id string, topology *ringmanager.Topology, plan, force bool) (*ringmanager.TopologyApplyResponse, error) {
+	query := url.Values{}
+	query.Set("plan", strconv.FormatBool(plan))
+	query.Set("force", strconv.FormatBool(force))
+
+	var response ringmanager.TopologyApplyResponse
+	err := c.doJson("POST", "/clusters/"+id+"/apply?"+query.Encode(), topology, http.StatusOK, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// ImportOptions are the parameters of ClusterImport
+type ImportOptions struct {
+	// Name of the ring, by default the one of the file
+	Name string
+
+	// Min part hours of an imported ring file, which does not keep it.
+	// The default of the manager is used when nil.
+	MinPartHours *int
+
+	// Author of the first version of the ring
+	Author string
+}
+
+// ClusterImport adds a ring to the cluster from the content of a swift
+// builder or ring file
+func (c *Client) ClusterImport(id string, data io.Reader, opts *ImportOptions) (*ringmanager.RingImportResponse, error) {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+
+	query := url.Values{}
+	if opts.Name != "" {
+		query.Set("name", opts.Name)
+	}
+	if opts.MinPartHours != nil {
+		query.Set("min_part_hours", strconv.Itoa(*opts.MinPartHours))
+	}
+	if opts.Author != "" {
+		query.Set("author", opts.Author)
+	}
+	req, err := http.NewRequest("POST", c.host+"/clusters/"+id+"/import?"+query.Encode(), data)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	r, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusCreated {
+		return nil, newError(r)
+	}
+
+	var ring ringmanager.RingImportResponse
+	err = json.NewDecoder(r.Body).Decode(&ring)
+	if err != nil {
+		return nil, err
+	}
+	return &ring, nil
+}
+
+// Deployment returns which nodes of the cluster have the current rings
+func (c *Client) Deployment(clusterId string) (*ringmanager.DeploymentResponse, error) {
+	var deployment ringmanager.DeploymentResponse
+	err := c.doJson("GET", "/clusters/"+clusterId+"/deployment", nil, http.StatusOK, &deployment)
+	if err != nil {
+		return nil, err
+	}
+	return &deployment, nil
+}
+
+// DeploymentReport tells the manager which rings a node has installed
+func (c *Client) DeploymentReport(clusterId string, report *ringmanager.AgentReportRequest) error {
+	return c.doJson("POST", "/clusters/"+clusterId+"/deployment", report, http.StatusNoContent, nil)
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringmanager"
+)
+
+func (c *Client) DeviceAdd(req *ringmanager.DeviceAddRequest) (*ringmanager.DeviceInfoResponse, error) {
+	var device ringmanager.DeviceInfoResponse
+	err := c.doJson("POST", "/devices", req, http.StatusCreated, &device)
+	if err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+func (c *Client) DeviceInfo(id string) (*ringmanager.DeviceInfoResponse, error) {
+	var device ringmanager.DeviceInfoResponse
+	err := c.doJson("GET", "/devices/"+id, nil, http.StatusOK, &device)
+	if err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+func (c *Client) DeviceUpdate(id string, req *ringmanager.DeviceUpdateRequest) (*ringmanager.DeviceInfoResponse, error) {
+	var device ringmanager.DeviceInfoResponse
+	err := c.doJson("PATCH", "/devices/"+id, req, http.StatusOK, &device)
+	if err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// DeviceSetWeight sets the weight the device reaches over the next builds
+func (c *Client) DeviceSetWeight(id string, weight uint64) (*ringmanager.DeviceInfoResponse, error) {
+	req := &ringmanager.DeviceWeightRequest{Weight: &weight}
+
+	var device ringmanager.DeviceInfoResponse
+	err := c.doJson("PUT", "/devices/"+id+"/weight", req, http.StatusOK, &device)
+	if err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// DeviceDelete deletes the device.  Unless forced, it fails when the
+// device still holds partitions.
+func (c *Client) DeviceDelete(id string, force bool) error {
+	query := url.Values{}
+	query.Set("force", strconv.FormatBool(force))
+	return c.doJson("DELETE", "/devices/"+id+"?"+query.Encode(), nil, http.StatusOK, nil)
+}
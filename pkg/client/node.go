@@ -0,0 +1,70 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringmanager"
+)
+
+func (c *Client) NodeAdd(req *ringmanager.NodeAddRequest) (*ringmanager.NodeInfoResponse, error) {
+	var node ringmanager.NodeInfoResponse
+	err := c.doJson("POST", "/nodes", req, http.StatusCreated, &node)
+	if err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+func (c *Client) NodeInfo(id string) (*ringmanager.NodeInfoResponse, error) {
+	var node ringmanager.NodeInfoResponse
+	err := c.doJson("GET", "/nodes/"+id, nil, http.StatusOK, &node)
+	if err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+func (c *Client) NodeUpdate(id string, req *ringmanager.NodeUpdateRequest) (*ringmanager.NodeInfoResponse, error) {
+	var node ringmanager.NodeInfoResponse
+	err := c.doJson("PATCH", "/nodes/"+id, req, http.StatusOK, &node)
+	if err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// NodeDrain brings the weight of the devices of the node down to 0 over
+// the next builds
+func (c *Client) NodeDrain(id string) (*ringmanager.NodeInfoResponse, error) {
+	var node ringmanager.NodeInfoResponse
+	err := c.doJson("POST", "/nodes/"+id+"/drain", nil, http.StatusOK, &node)
+	if err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// NodeDelete deletes the node and its devices.  Unless forced, it fails
+// when the devices still hold partitions.
+func (c *Client) NodeDelete(id string, force bool) error {
+	query := url.Values{}
+	query.Set("force", strconv.FormatBool(force))
+	return c.doJson("DELETE", "/nodes/"+id+"?"+query.Encode(), nil, http.StatusOK, nil)
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringmanager"
+)
+
+// RingAdd adds a ring to a cluster.  A request without part power gets
+// the default of the manager, but min part hours is sent as is.  Without
+// replicas the manager uses its default, or the number of fragments of an
+// erasure coded policy.
+func (c *Client) RingAdd(req *ringmanager.RingAddRequest) (*ringmanager.RingInfoResponse, error) {
+	msg := *req
+	if msg.PartPower == 0 {
+		msg.PartPower = ringmanager.RING_DEFAULT_PART_POWER
+	}
+
+	var ring ringmanager.RingInfoResponse
+	err := c.doJson("POST", "/rings", &msg, http.StatusCreated, &ring)
+	if err != nil {
+		return nil, err
+	}
+	return &ring, nil
+}
+
+func (c *Client) RingInfo(id string) (*ringmanager.RingInfoResponse, error) {
+	var ring ringmanager.RingInfoResponse
+	err := c.doJson("GET", "/rings/"+id, nil, http.StatusOK, &ring)
+	if err != nil {
+		return nil, err
+	}
+	return &ring, nil
+}
+
+func (c *Client) RingDelete(id string) error {
+	return c.doJson("DELETE", "/rings/"+id, nil, http.StatusOK, nil)
+}
+
+func (c *Client) RingReport(id string) (*ringmanager.RingReportResponse, error) {
+	var report ringmanager.RingReportResponse
+	err := c.doJson("GET", "/rings/"+id+"/report", nil, http.StatusOK, &report)
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func (c *Client) RingVersionList(id string) (*ringmanager.RingVersionListResponse, error) {
+	var list ringmanager.RingVersionListResponse
+	err := c.doJson("GET", "/rings/"+id+"/versions", nil, http.StatusOK, &list)
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func (c *Client) RingVersionInfo(id string, version int) (*ringmanager.RingVersionInfo, error) {
+	var info ringmanager.RingVersionInfo
+	err := c.doJson("GET", "/rings/"+id+"/versions/"+strconv.Itoa(version), nil, http.StatusOK, &info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// RingRollback makes an older version of the ring current again
+func (c *Client) RingRollback(id string, version int) (*ringmanager.RingInfoResponse, error) {
+	var ring ringmanager.RingInfoResponse
+	err := c.doJson("POST", "/rings/"+id+"/rollback/"+strconv.Itoa(version), nil, http.StatusOK, &ring)
+	if err != nil {
+		return nil, err
+	}
+	return &ring, nil
+}
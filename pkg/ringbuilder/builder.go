@@ -0,0 +1,683 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringbuilder
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"math"
+	mrand "math/rand"
+	"time"
+)
+
+const (
+	// NoneDev marks a partition replica which is not assigned to a device
+	NoneDev = 0xffff
+
+	MaxPartPower = 32
+	MaxReplicas  = 64
+	MaxBalance   = 999.99
+
+	maxLastPartMoves = 0xff
+)
+
+// RingBuilder assigns the partitions of a swift ring to its devices.
+//
+// Replicas of a partition are spread as widely as possible across
+// regions, zones, ips and devices while keeping every device as close
+// as possible to its share of the partitions given by its weight.
+type RingBuilder struct {
+	Id           string
+	PartPower    int
+	Replicas     float64
+	MinPartHours int
+	Parts        int
+	Version      int
+
+	// Devices indexed by id.  Removed devices leave a nil hole
+	Devs []*Device
+
+	// For every replica, the device id assigned to each partition
+	Replica2Part2Dev [][]uint16
+
+	// Hours since each partition was last moved, and when it was computed
+	LastPartMoves      []uint8
+	LastPartMovesEpoch int64
+
+	// Ids of the devices removed by the next rebalance
+	RemoveDevs []int
+
+	DevsChanged bool
+}
+
+// RebalanceResult summarizes the changes made by a rebalance
+type RebalanceResult struct {
+	ChangedParts   int     `json:"changed_parts"`
+	Balance        float64 `json:"balance"`
+	RemovedDevices int     `json:"removed_devices"`
+}
+
+type partReplica struct {
+	part    int
+	replica int
+	from    uint16
+}
+
+func NewRingBuilder(partPower int, replicas float64, minPartHours int) (*RingBuilder, error) {
+	if partPower < 1 || partPower > MaxPartPower {
+		return nil, ErrPartPower
+	}
+	if replicas < 1 || replicas > MaxReplicas {
+		return nil, ErrReplicas
+	}
+	if minPartHours < 0 {
+		return nil, ErrMinPartHours
+	}
+
+	b := &RingBuilder{
+		Id:           genId(),
+		PartPower:    partPower,
+		Replicas:     replicas,
+		MinPartHours: minPartHours,
+		Parts:        1 << uint(partPower),
+		Devs:         make([]*Device, 0),
+	}
+
+	return b, nil
+}
+
+func genId() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// AddDev adds a copy of dev to the builder and returns the id assigned
+// to it.  Partitions are only assigned to it by the next rebalance.
+func (b *RingBuilder) AddDev(dev *Device) (int, error) {
+	if dev.Ip == "" || dev.Port <= 0 || dev.Device == "" {
+		return 0, ErrInvalidDevice
+	}
+	if dev.Weight < 0 {
+		return 0, ErrInvalidWeight
+	}
+	if b.duplicateDev(-1, dev) {
+		return 0, ErrDeviceDuplicate
+	}
+
+	// Reuse the first hole left by a removed device
+	id := len(b.Devs)
+	for i, d := range b.Devs {
+		if d == nil {
+			id = i
+			break
+		}
+	}
+	if id >= NoneDev {
+		return 0, ErrTooManyDevices
+	}
+
+	d := *dev
+	d.Id = id
+	d.Parts = 0
+	if d.ReplicationIp == "" {
+		d.ReplicationIp = d.Ip
+	}
+	if d.ReplicationPort == 0 {
+		d.ReplicationPort = d.Port
+	}
+
+	if id == len(b.Devs) {
+		b.Devs = append(b.Devs, &d)
+	} else {
+		b.Devs[id] = &d
+	}
+	b.DevsChanged = true
+
+	return id, nil
+}
+
+// Devices returns a copy of the devices in the builder, leaving out the
+// ones removed by the next rebalance
+func (b *RingBuilder) Devices() []*Device {
+	devs := make([]*Device, 0, len(b.Devs))
+	for _, d := range b.Devs {
+		if d != nil && !b.removing(d.Id) {
+			dev := *d
+			devs = append(devs, &dev)
+		}
+	}
+	return devs
+}
+
+// RemoveDev marks the device for removal.  Its partitions are reassigned
+// by the next rebalance, regardless of min_part_hours.
+func (b *RingBuilder) RemoveDev(id int) error {
+	d, err := b.dev(id)
+	if err != nil {
+		return err
+	}
+
+	d.Weight = 0
+	b.RemoveDevs = append(b.RemoveDevs, id)
+	b.DevsChanged = true
+
+	return nil
+}
+
+// SetDevWeight changes the weight of the device
+func (b *RingBuilder) SetDevWeight(id int, weight float64) error {
+	d, err := b.dev(id)
+	if err != nil {
+		return err
+	}
+	if weight < 0 {
+		return ErrInvalidWeight
+	}
+
+	d.Weight = weight
+	b.DevsChanged = true
+
+	return nil
+}
+
+// SetDevInfo changes the location, address, name and meta of the device
+// from info, keeping the partitions assigned to it
+func (b *RingBuilder) SetDevInfo(id int, info *Device) error {
+	d, err := b.dev(id)
+	if err != nil {
+		return err
+	}
+	if info.Ip == "" || info.Port <= 0 || info.Device == "" {
+		return ErrInvalidDevice
+	}
+	if b.duplicateDev(id, info) {
+		return ErrDeviceDuplicate
+	}
+
+	d.Region = info.Region
+	d.Zone = info.Zone
+	d.Ip = info.Ip
+	d.Port = info.Port
+	d.ReplicationIp = info.ReplicationIp
+	if d.ReplicationIp == "" {
+		d.ReplicationIp = d.Ip
+	}
+	d.ReplicationPort = info.ReplicationPort
+	if d.ReplicationPort == 0 {
+		d.ReplicationPort = d.Port
+	}
+	d.Device = info.Device
+	d.Meta = info.Meta
+	b.DevsChanged = true
+
+	return nil
+}
+
+func (b *RingBuilder) dev(id int) (*Device, error) {
+	if id < 0 || id >= len(b.Devs) || b.Devs[id] == nil || b.removing(id) {
+		return nil, ErrDeviceNotFound
+	}
+	return b.Devs[id], nil
+}
+
+func (b *RingBuilder) removing(id int) bool {
+	for _, removeId := range b.RemoveDevs {
+		if removeId == id {
+			return true
+		}
+	}
+	return false
+}
+
+// duplicateDev checks if a device other than id, and not being removed,
+// has the same ip, port and name as dev
+func (b *RingBuilder) duplicateDev(id int, dev *Device) bool {
+	for _, d := range b.Devs {
+		if d != nil && d.Id != id && !b.removing(d.Id) &&
+			d.Ip == dev.Ip && d.Port == dev.Port && d.Device == dev.Device {
+			return true
+		}
+	}
+	return false
+}
+
+// PretendMinPartHoursPassed allows every partition to be moved by the
+// next rebalance regardless of min_part_hours
+func (b *RingBuilder) PretendMinPartHoursPassed() {
+	for i := range b.LastPartMoves {
+		b.LastPartMoves[i] = maxLastPartMoves
+	}
+}
+
+// Rebalance assigns unassigned partition replicas and moves replicas
+// between devices to improve dispersion and balance.  A partition is
+// only moved if it has not been moved in the last MinPartHours and at
+// most one replica of a partition is moved per rebalance.
+func (b *RingBuilder) Rebalance(seed int64) (*RebalanceResult, error) {
+	if len(b.weightedDevs()) == 0 {
+		return nil, ErrNoDevices
+	}
+
+	r := mrand.New(mrand.NewSource(seed))
+
+	// Removed devices leave their partitions unassigned
+	removed := len(b.RemoveDevs)
+	for _, id := range b.RemoveDevs {
+		b.Devs[id] = nil
+	}
+	b.RemoveDevs = nil
+
+	b.updateLastPartMoves(time.Now())
+	b.adjustReplica2Part2DevSize()
+	b.recountParts()
+
+	wanted := b.partsWanted()
+	gathered := b.gatherParts(wanted, r)
+	b.reassignParts(gathered, wanted, r)
+
+	changed := make(map[int]bool)
+	for _, pr := range gathered {
+		if b.Replica2Part2Dev[pr.replica][pr.part] != pr.from {
+			changed[pr.part] = true
+			b.LastPartMoves[pr.part] = 0
+		}
+	}
+
+	b.Version++
+	b.DevsChanged = false
+
+	return &RebalanceResult{
+		ChangedParts:   len(changed),
+		Balance:        b.Balance(),
+		RemovedDevices: removed,
+	}, nil
+}
+
+// Balance returns the highest percentage by which a device is over or
+// under its wanted number of partitions
+func (b *RingBuilder) Balance() float64 {
+	if b.Replica2Part2Dev == nil {
+		return 0
+	}
+
+	wanted := b.partsWanted()
+	balance := 0.0
+	for _, d := range b.Devs {
+		if d == nil {
+			continue
+		}
+		if d.Weight == 0 {
+			if d.Parts > 0 {
+				return MaxBalance
+			}
+			continue
+		}
+		devBalance := math.Abs(100*float64(d.Parts)/wanted[d.Id] - 100)
+		if devBalance > balance {
+			balance = devBalance
+		}
+	}
+
+	return math.Min(balance, MaxBalance)
+}
+
+// ReplicaCountForPart returns how many replicas the partition has, which
+// is less than the ceiling of Replicas for some partitions when the
+// replica count is fractional
+func (b *RingBuilder) ReplicaCountForPart(part int) int {
+	count := 0
+	for _, part2dev := range b.Replica2Part2Dev {
+		if part < len(part2dev) {
+			count++
+		}
+	}
+	return count
+}
+
+func (b *RingBuilder) weightedDevs() []*Device {
+	devs := make([]*Device, 0, len(b.Devs))
+	for _, d := range b.Devs {
+		if d != nil && d.Weight > 0 {
+			devs = append(devs, d)
+		}
+	}
+	return devs
+}
+
+func (b *RingBuilder) validDev(id uint16) bool {
+	return id != NoneDev && int(id) < len(b.Devs) && b.Devs[id] != nil
+}
+
+func (b *RingBuilder) updateLastPartMoves(now time.Time) {
+	if b.LastPartMoves == nil {
+		b.LastPartMoves = make([]uint8, b.Parts)
+		b.LastPartMovesEpoch = now.Unix()
+		return
+	}
+
+	elapsed := (now.Unix() - b.LastPartMovesEpoch) / 3600
+	if elapsed <= 0 {
+		return
+	}
+	for i, hours := range b.LastPartMoves {
+		b.LastPartMoves[i] = uint8(math.Min(float64(hours)+float64(elapsed), maxLastPartMoves))
+	}
+	b.LastPartMovesEpoch += elapsed * 3600
+}
+
+// Make sure there is one part2dev table per replica, the last one only
+// covering a fraction of the partitions if Replicas is fractional
+func (b *RingBuilder) adjustReplica2Part2DevSize() {
+	whole := int(b.Replicas)
+	sizes := make([]int, whole, whole+1)
+	for i := range sizes {
+		sizes[i] = b.Parts
+	}
+	if fraction := int(float64(b.Parts) * (b.Replicas - float64(whole))); fraction > 0 {
+		sizes = append(sizes, fraction)
+	}
+
+	table := make([][]uint16, len(sizes))
+	for replica, size := range sizes {
+		part2dev := make([]uint16, size)
+		for part := range part2dev {
+			part2dev[part] = NoneDev
+			if replica < len(b.Replica2Part2Dev) && part < len(b.Replica2Part2Dev[replica]) {
+				part2dev[part] = b.Replica2Part2Dev[replica][part]
+			}
+		}
+		table[replica] = part2dev
+	}
+	b.Replica2Part2Dev = table
+}
+
+func (b *RingBuilder) recountParts() {
+	for _, d := range b.Devs {
+		if d != nil {
+			d.Parts = 0
+		}
+	}
+	for _, part2dev := range b.Replica2Part2Dev {
+		for _, id := range part2dev {
+			if b.validDev(id) {
+				b.Devs[id].Parts++
+			}
+		}
+	}
+}
+
+// partsWanted returns, indexed by device id, the number of partition
+// replicas each device should hold according to its weight
+func (b *RingBuilder) partsWanted() []float64 {
+	wanted := make([]float64, len(b.Devs))
+
+	assignments := 0
+	for _, part2dev := range b.Replica2Part2Dev {
+		assignments += len(part2dev)
+	}
+
+	totalWeight := 0.0
+	for _, d := range b.weightedDevs() {
+		totalWeight += d.Weight
+	}
+	if totalWeight == 0 {
+		return wanted
+	}
+
+	for _, d := range b.weightedDevs() {
+		wanted[d.Id] = d.Weight / totalWeight * float64(assignments)
+	}
+	return wanted
+}
+
+// tierShares returns for every tier the fraction of the ring weight it
+// holds.  A tier should hold about that fraction of the replicas of each
+// partition.
+func (b *RingBuilder) tierShares() map[string]float64 {
+	shares := make(map[string]float64)
+
+	totalWeight := 0.0
+	for _, d := range b.weightedDevs() {
+		totalWeight += d.Weight
+	}
+	for _, d := range b.weightedDevs() {
+		for _, tier := range d.Tiers() {
+			shares[tier] += d.Weight / totalWeight
+		}
+	}
+	return shares
+}
+
+// maxReplicas returns how many replicas of a partition with the given
+// replica count a tier holding share of the weight may hold
+func maxReplicas(share float64, replicas int) int {
+	return int(math.Ceil(share*float64(replicas) - 1e-9))
+}
+
+func (b *RingBuilder) partTiers(part int) [][tierCount]string {
+	tiers := make([][tierCount]string, 0, len(b.Replica2Part2Dev))
+	for _, part2dev := range b.Replica2Part2Dev {
+		if part < len(part2dev) && b.validDev(part2dev[part]) {
+			tiers = append(tiers, b.Devs[part2dev[part]].Tiers())
+		}
+	}
+	return tiers
+}
+
+// gatherParts unassigns the partition replicas that need to be placed
+// by reassignParts: those not on a device, those crowding a failure
+// domain and those above the share of overloaded devices.
+func (b *RingBuilder) gatherParts(wanted []float64, r *mrand.Rand) []partReplica {
+	gathered := make([]partReplica, 0)
+	moved := make([]bool, b.Parts)
+
+	take := func(part, replica int) {
+		id := b.Replica2Part2Dev[replica][part]
+		if b.validDev(id) {
+			b.Devs[id].Parts--
+		}
+		gathered = append(gathered, partReplica{part: part, replica: replica, from: id})
+		b.Replica2Part2Dev[replica][part] = NoneDev
+		moved[part] = true
+	}
+	movable := func(part int) bool {
+		return !moved[part] && int(b.LastPartMoves[part]) >= b.MinPartHours
+	}
+
+	// Replicas which are unassigned or on removed devices
+	for replica, part2dev := range b.Replica2Part2Dev {
+		for part, id := range part2dev {
+			if !b.validDev(id) {
+				take(part, replica)
+			}
+		}
+	}
+
+	// Replicas crowding a failure domain
+	shares := b.tierShares()
+	enoughDevs := len(b.weightedDevs()) >= int(math.Ceil(b.Replicas))
+	for part := 0; part < b.Parts; part++ {
+		for level := TierDevice; level >= TierRegion; level-- {
+			// Two replicas on the same device are never wanted, other
+			// tiers have to honor min_part_hours
+			if level == TierDevice {
+				if !enoughDevs || moved[part] {
+					continue
+				}
+			} else if !movable(part) {
+				continue
+			}
+
+			if replica := b.crowdedReplica(part, level, shares, wanted); replica >= 0 {
+				take(part, replica)
+				break
+			}
+		}
+	}
+
+	// Replicas above the share of overloaded devices
+	overloaded := make(map[uint16][]partReplica)
+	for _, d := range b.Devs {
+		if d != nil && d.Parts > int(math.Ceil(wanted[d.Id])) {
+			overloaded[uint16(d.Id)] = make([]partReplica, 0, d.Parts)
+		}
+	}
+	if len(overloaded) == 0 {
+		return gathered
+	}
+	for replica, part2dev := range b.Replica2Part2Dev {
+		for part, id := range part2dev {
+			if list, ok := overloaded[id]; ok {
+				overloaded[id] = append(list, partReplica{part: part, replica: replica})
+			}
+		}
+	}
+	for _, d := range b.Devs {
+		if d == nil {
+			continue
+		}
+		list, ok := overloaded[uint16(d.Id)]
+		if !ok {
+			continue
+		}
+		limit := int(math.Ceil(wanted[d.Id]))
+		for _, i := range r.Perm(len(list)) {
+			if d.Parts <= limit {
+				break
+			}
+			if movable(list[i].part) {
+				take(list[i].part, list[i].replica)
+			}
+		}
+	}
+
+	return gathered
+}
+
+// crowdedReplica returns a replica of the partition placed in a tier at
+// the given level which holds more replicas than its share, or -1.  The
+// replica on the device most over its wanted partitions is chosen.
+func (b *RingBuilder) crowdedReplica(part, level int, shares map[string]float64, wanted []float64) int {
+	replicas := b.ReplicaCountForPart(part)
+	counts := make(map[string]int)
+	for _, tiers := range b.partTiers(part) {
+		counts[tiers[level]]++
+	}
+
+	chosen := -1
+	load := 0.0
+	for replica, part2dev := range b.Replica2Part2Dev {
+		if part >= len(part2dev) || !b.validDev(part2dev[part]) {
+			continue
+		}
+		d := b.Devs[part2dev[part]]
+		tier := d.Tiers()[level]
+		if level == TierDevice {
+			if counts[tier] <= 1 {
+				continue
+			}
+		} else if counts[tier] <= maxReplicas(shares[tier], replicas) {
+			continue
+		}
+		devLoad := math.Inf(1)
+		if wanted[d.Id] > 0 {
+			devLoad = float64(d.Parts) / wanted[d.Id]
+		}
+		if chosen < 0 || devLoad > load {
+			chosen = replica
+			load = devLoad
+		}
+	}
+	return chosen
+}
+
+// reassignParts places each gathered partition replica on the device
+// which keeps the partition within the share of each failure domain,
+// still wants partitions and shares the fewest failure domains with the
+// other replicas of the partition
+func (b *RingBuilder) reassignParts(gathered []partReplica, wanted []float64, r *mrand.Rand) {
+	devs := b.weightedDevs()
+	candidates := make([]*Device, len(devs))
+	for i, j := range r.Perm(len(devs)) {
+		candidates[i] = devs[j]
+	}
+	shares := b.tierShares()
+
+	for _, pr := range gathered {
+		replicas := b.ReplicaCountForPart(pr.part)
+		others := b.partTiers(pr.part)
+
+		var best *Device
+		var bestKey [tierCount + 3]float64
+		for _, d := range candidates {
+			var key [tierCount + 3]float64
+
+			tiers := d.Tiers()
+			var counts [tierCount]int
+			for _, other := range others {
+				for level := range tiers {
+					if other[level] == tiers[level] {
+						counts[level]++
+					}
+				}
+			}
+
+			// A device never gets two replicas of a partition unless
+			// there are not enough devices
+			key[0] = float64(counts[TierDevice])
+
+			// Tiers which would go over their share of replicas
+			for level := TierRegion; level < TierDevice; level++ {
+				if counts[level]+1 > maxReplicas(shares[tiers[level]], replicas) {
+					key[1]++
+				}
+			}
+
+			// Devices under their share of partitions
+			if float64(d.Parts) >= wanted[d.Id] {
+				key[2] = 1
+			}
+
+			// The fewest replicas sharing each failure domain
+			for level := TierRegion; level < TierDevice; level++ {
+				key[level+3] = float64(counts[level])
+			}
+
+			// And finally the device wanting the most partitions
+			key[tierCount+2] = float64(d.Parts) - wanted[d.Id]
+
+			if best == nil || keyLess(key[:], bestKey[:]) {
+				best = d
+				bestKey = key
+			}
+		}
+
+		b.Replica2Part2Dev[pr.replica][pr.part] = uint16(best.Id)
+		best.Parts++
+	}
+}
+
+func keyLess(a, b []float64) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringbuilder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupBuilder(t *testing.T, zones, devsPerZone int) *RingBuilder {
+	b, err := NewRingBuilder(8, 3, 1)
+	assert.Nil(t, err)
+
+	for z := 1; z <= zones; z++ {
+		for i := 0; i < devsPerZone; i++ {
+			_, err := b.AddDev(&Device{
+				Region: 1,
+				Zone:   z,
+				Ip:     fmt.Sprintf("127.0.0.%d", z),
+				Port:   6010,
+				Device: fmt.Sprintf("sdb%d", i),
+				Weight: 100,
+			})
+			assert.Nil(t, err)
+		}
+	}
+
+	return b
+}
+
+// Check every partition has its replicas on distinct zones
+func assertDispersed(t *testing.T, b *RingBuilder) {
+	for part := 0; part < b.Parts; part++ {
+		zones := make(map[string]bool)
+		for _, tiers := range b.partTiers(part) {
+			zones[tiers[TierZone]] = true
+		}
+		assert.Equal(t, b.ReplicaCountForPart(part), len(zones), "part %v", part)
+	}
+}
+
+func TestNewRingBuilderInvalid(t *testing.T) {
+	_, err := NewRingBuilder(0, 3, 1)
+	assert.Equal(t, ErrPartPower, err)
+
+	_, err = NewRingBuilder(10, 0.5, 1)
+	assert.Equal(t, ErrReplicas, err)
+
+	_, err = NewRingBuilder(10, MaxReplicas+1, 1)
+	assert.Equal(t, ErrReplicas, err)
+
+	_, err = NewRingBuilder(10, 3, -1)
+	assert.Equal(t, ErrMinPartHours, err)
+}
+
+func TestAddDev(t *testing.T) {
+	b := setupBuilder(t, 1, 2)
+	assert.Equal(t, 2, len(b.Devs))
+	assert.Equal(t, "127.0.0.1", b.Devs[1].ReplicationIp)
+	assert.Equal(t, 6010, b.Devs[1].ReplicationPort)
+
+	_, err := b.AddDev(&Device{Ip: "127.0.0.1", Port: 6010, Device: "sdb0", Weight: 1})
+	assert.Equal(t, ErrDeviceDuplicate, err)
+
+	_, err = b.AddDev(&Device{Ip: "127.0.0.1", Device: "sdc"})
+	assert.Equal(t, ErrInvalidDevice, err)
+}
+
+func TestRebalanceNoDevices(t *testing.T) {
+	b, err := NewRingBuilder(8, 3, 1)
+	assert.Nil(t, err)
+
+	_, err = b.Rebalance(1)
+	assert.Equal(t, ErrNoDevices, err)
+}
+
+func TestRebalance(t *testing.T) {
+	b := setupBuilder(t, 4, 2)
+
+	result, err := b.Rebalance(1)
+	assert.Nil(t, err)
+	assert.Equal(t, b.Parts, result.ChangedParts)
+	assert.Equal(t, 1, b.Version)
+	assert.True(t, result.Balance < 2, "balance %v", result.Balance)
+	assertDispersed(t, b)
+
+	parts := 0
+	for _, d := range b.Devs {
+		parts += d.Parts
+	}
+	assert.Equal(t, 3*b.Parts, parts)
+}
+
+func TestRebalanceFractionalReplicas(t *testing.T) {
+	b, err := NewRingBuilder(8, 2.5, 1)
+	assert.Nil(t, err)
+	for z := 1; z <= 3; z++ {
+		_, err := b.AddDev(&Device{Region: 1, Zone: z, Ip: "127.0.0.1", Port: 6010 + z, Device: "sdb", Weight: 1})
+		assert.Nil(t, err)
+	}
+
+	_, err = b.Rebalance(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(b.Replica2Part2Dev))
+	assert.Equal(t, b.Parts/2, len(b.Replica2Part2Dev[2]))
+	assertDispersed(t, b)
+}
+
+func TestRebalanceMinPartHours(t *testing.T) {
+	b := setupBuilder(t, 3, 1)
+	_, err := b.Rebalance(1)
+	assert.Nil(t, err)
+
+	_, err = b.AddDev(&Device{Region: 1, Zone: 4, Ip: "127.0.0.4", Port: 6010, Device: "sdb0", Weight: 100})
+	assert.Nil(t, err)
+
+	// Nothing has been moved for min_part_hours yet
+	result, err := b.Rebalance(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, result.ChangedParts)
+	assert.Equal(t, 0, b.Devs[3].Parts)
+
+	b.PretendMinPartHoursPassed()
+	result, err = b.Rebalance(1)
+	assert.Nil(t, err)
+	assert.True(t, result.ChangedParts > 0)
+	assert.True(t, result.ChangedParts <= b.Parts)
+	assert.True(t, b.Devs[3].Parts > 0)
+	assertDispersed(t, b)
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ringbuilder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	b := setupBuilder(t, 3, 1)
+	_, err = b.Rebalance(1)
+	assert.Nil(t, err)
+
+	path := filepath.Join(dir, "object.builder")
+	err = b.Save(path)
+	assert.Nil(t, err)
+
+	loaded, err := Load(path)
+	assert.Nil(t, err)
+	assert.Equal(t, b, loaded)
+
+	// Devices outside of the builder
+	for _, id := range []int{-1, 3} {
+		b.Devs[0].Id = id
+		err = b.Save(path)
+		assert.Nil(t, err)
+		_, err = Load(path)
+		assert.Equal(t, ErrDeviceNotFound, err, id)
+	}
+}
+
+func TestRemoveDev(t *testing.T) {
+	b := setupBuilder(t, 4, 1)
+	_, err := b.Rebalance(1)
+	assert.Nil(t, err)
+
+	err = b.RemoveDev(3)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(b.Devices()))
+	assert.Equal(t, ErrDeviceNotFound, b.RemoveDev(3))
+
+	// Partitions of removed devices move regardless of min_part_hours
+	result, err := b.Rebalance(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result.RemovedDevices)
+	assert.True(t, result.ChangedParts > 0)
+	assert.Nil(t, b.Devs[3])
+	assert.Nil(t, b.RemoveDevs)
+	assertDispersed(t, b)
+
+	// The hole is reused by the next device
+	id, err := b.AddDev(&Device{Region: 1, Zone: 5, Ip: "127.0.0.5", Port: 6010, Device: "sdb0", Weight: 100})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, id)
+}
+
+func TestSetDevWeight(t *testing.T) {
+	b := setupBuilder(t, 4, 1)
+	_, err := b.Rebalance(1)
+	assert.Nil(t, err)
+
+	err = b.SetDevWeight(0, 50)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrInvalidWeight, b.SetDevWeight(0, -1))
+	assert.Equal(t, ErrDeviceNotFound, b.SetDevWeight(10, 1))
+
+	b.PretendMinPartHoursPassed()
+	_, err = b.Rebalance(1)
+	assert.Nil(t, err)
+	assert.True(t, b.Devs[0].Parts < b.Devs[1].Parts)
+}
+
+func TestSetDevInfo(t *testing.T) {
+	b := setupBuilder(t, 3, 1)
+	_, err := b.Rebalance(1)
+	assert.Nil(t, err)
+	parts := b.Devs[0].Parts
+
+	info := *b.Devs[0]
+	info.Ip = "192.168.0.1"
+	info.ReplicationIp = ""
+	info.Meta = "moved"
+	err = b.SetDevInfo(0, &info)
+	assert.Nil(t, err)
+	assert.Equal(t, "192.168.0.1", b.Devs[0].Ip)
+	assert.Equal(t, "192.168.0.1", b.Devs[0].ReplicationIp)
+	assert.Equal(t, "moved", b.Devs[0].Meta)
+	assert.Equal(t, parts, b.Devs[0].Parts)
+
+	info = *b.Devs[1]
+	info.Ip = "192.168.0.1"
+	assert.Equal(t, ErrDeviceDuplicate, b.SetDevInfo(1, &info))
+}
@@ -0,0 +1,245 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringbuilder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Version of the composite metadata file format written by swift
+const compositeSerializationVersion = 1
+
+var (
+	ErrCompositeTooFewBuilders = errors.New("Two or more component builders are required")
+	ErrCompositeNotRebalanced  = errors.New("Component builders must be rebalanced before they are composed")
+)
+
+// CompositeComponent identifies a component builder and the version of it
+// a composite ring was built from
+type CompositeComponent struct {
+	Id       string  `json:"id"`
+	Version  int     `json:"version"`
+	Replicas float64 `json:"replicas"`
+}
+
+// CompositeMetadata is the state of a composite ring, saved as JSON in the
+// same format as swift's CompositeRingBuilder.  A composite ring holds the
+// devices and the replicas of every component ring side by side, each
+// component keeping its own partition assignment.
+type CompositeMetadata struct {
+	Version               int                   `json:"version"`
+	Components            []*CompositeComponent `json:"components"`
+	ComponentBuilderFiles map[string]string     `json:"component_builder_files"`
+	SerializationVersion  int                   `json:"serialization_version"`
+}
+
+// NewCompositeMetadata returns the metadata of a composite ring never
+// composed
+func NewCompositeMetadata() *CompositeMetadata {
+	return &CompositeMetadata{
+		Components:            make([]*CompositeComponent, 0),
+		ComponentBuilderFiles: make(map[string]string),
+		SerializationVersion:  compositeSerializationVersion,
+	}
+}
+
+// LoadCompositeMetadata reads the metadata saved by Save or by swift's
+// ring-composer
+func LoadCompositeMetadata(path string) (*CompositeMetadata, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := NewCompositeMetadata()
+	err = json.Unmarshal(data, m)
+	if err != nil {
+		return nil, err
+	}
+	if m.SerializationVersion != compositeSerializationVersion {
+		return nil, fmt.Errorf("Unsupported composite metadata serialization version %v",
+			m.SerializationVersion)
+	}
+	return m, nil
+}
+
+// Save atomically writes the metadata to path
+func (m *CompositeMetadata) Save(path string) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, func(w *os.File) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// Compose builds the composite ring of the builders, read from the
+// builder files at paths, and makes them the components of the next
+// version of the metadata.  As with swift's ring-composer, the builders
+// must be the ones composed before, in the same order and at the same or
+// a newer version.  The metadata is left unchanged on error.
+func (m *CompositeMetadata) Compose(builders []*RingBuilder, paths []string) (*RingData, error) {
+	components := make([]*CompositeComponent, len(builders))
+	for i, b := range builders {
+		components[i] = &CompositeComponent{Id: b.Id, Version: b.Version, Replicas: b.Replicas}
+	}
+
+	err := m.checkComponents(components)
+	if err != nil {
+		return nil, err
+	}
+	ring, err := ComposeRings(builders)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Version++
+	m.Components = components
+	m.ComponentBuilderFiles = make(map[string]string)
+	for i, b := range builders {
+		m.ComponentBuilderFiles[b.Id] = paths[i]
+	}
+	ring.Version = m.Version
+
+	return ring, nil
+}
+
+// checkComponents checks the components are the ones already composed,
+// if any, and none of them went back to an older version
+func (m *CompositeMetadata) checkComponents(components []*CompositeComponent) error {
+	if len(m.Components) == 0 {
+		return nil
+	}
+	if len(m.Components) != len(components) {
+		return fmt.Errorf("Number of component builders has changed from %v to %v",
+			len(m.Components), len(components))
+	}
+	for i, c := range components {
+		old := m.Components[i]
+		if c.Id != old.Id {
+			return fmt.Errorf("Invalid builder change at index %v: builder id %v is not %v",
+				i, c.Id, old.Id)
+		}
+		if c.Version < old.Version {
+			return fmt.Errorf("Invalid builder change at index %v: older builder version %v, composed with %v",
+				i, c.Version, old.Version)
+		}
+	}
+	return nil
+}
+
+// ComposeRings returns the ring holding the devices and partition
+// assignments of every builder, in order.  The device ids of a builder
+// are shifted by the number of device slots of the builders before it.
+func ComposeRings(builders []*RingBuilder) (*RingData, error) {
+	err := validateComponents(builders)
+	if err != nil {
+		return nil, err
+	}
+
+	ring := &RingData{
+		Devs:             make([]*Device, 0),
+		Replica2Part2Dev: make([][]uint16, 0),
+		PartShift:        32 - builders[0].PartPower,
+	}
+	for _, b := range builders {
+		offset := len(ring.Devs)
+		for _, d := range b.Devs {
+			if d == nil {
+				ring.Devs = append(ring.Devs, nil)
+				continue
+			}
+			dev := *d
+			dev.Id += offset
+			ring.Devs = append(ring.Devs, &dev)
+		}
+		for _, part2dev := range b.Replica2Part2Dev {
+			row := make([]uint16, len(part2dev))
+			for part, id := range part2dev {
+				row[part] = id + uint16(offset)
+			}
+			ring.Replica2Part2Dev = append(ring.Replica2Part2Dev, row)
+		}
+	}
+	if len(ring.Devs) >= NoneDev {
+		return nil, ErrTooManyDevices
+	}
+
+	return ring, nil
+}
+
+// validateComponents checks the builders can be composed the way swift
+// does before composing them
+func validateComponents(builders []*RingBuilder) error {
+	if len(builders) < 2 {
+		return ErrCompositeTooFewBuilders
+	}
+
+	ids := make(map[string]int)
+	regions := make(map[int]int)
+	devices := make(map[string]int)
+	for i, b := range builders {
+		if b.PartPower != builders[0].PartPower {
+			return fmt.Errorf("All builders must have the same part power, builder %v has %v instead of %v",
+				i, b.PartPower, builders[0].PartPower)
+		}
+		if b.Replicas != float64(int(b.Replicas)) {
+			return fmt.Errorf("Builder %v has a non integer replica count %v", i, b.Replicas)
+		}
+		if b.Replica2Part2Dev == nil || b.DevsChanged || len(b.RemoveDevs) > 0 {
+			return ErrCompositeNotRebalanced
+		}
+
+		if b.Id == "" {
+			return fmt.Errorf("Builder %v has no id", i)
+		}
+		if j, ok := ids[b.Id]; ok {
+			return fmt.Errorf("Builder id %v used at indexes %v and %v", b.Id, j, i)
+		}
+		ids[b.Id] = i
+
+		// Regions and devices of a builder only appear in its replicas
+		builderRegions := make(map[int]bool)
+		for _, d := range b.Devs {
+			if d == nil {
+				continue
+			}
+			if j, ok := regions[d.Region]; ok && j != i {
+				return fmt.Errorf("Same region %v found in builders at indexes %v and %v", d.Region, j, i)
+			}
+			builderRegions[d.Region] = true
+
+			key := fmt.Sprintf("%v:%v/%v", d.Ip, d.Port, d.Device)
+			if j, ok := devices[key]; ok {
+				return fmt.Errorf("Duplicate ip/port/device combination %v found in builders at indexes %v and %v",
+					key, j, i)
+			}
+			devices[key] = i
+		}
+		for region := range builderRegions {
+			regions[region] = i
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringbuilder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setupComponent returns a rebalanced builder with one device in each of
+// 3 zones of the region
+func setupComponent(t *testing.T, region int) *RingBuilder {
+	b := setupBuilder(t, 3, 1)
+	for _, d := range b.Devs {
+		d.Region = region
+		d.Ip = fmt.Sprintf("10.0.%d.%d", region, d.Zone)
+		d.ReplicationIp = d.Ip
+	}
+	_, err := b.Rebalance(1)
+	assert.Nil(t, err)
+
+	return b
+}
+
+func TestComposeRings(t *testing.T) {
+	east := setupComponent(t, 1)
+	west := setupComponent(t, 2)
+	west.RemoveDev(1)
+	_, err := west.Rebalance(1)
+	assert.Nil(t, err)
+
+	ring, err := ComposeRings([]*RingBuilder{east, west})
+	assert.Nil(t, err)
+	assert.Equal(t, 32-8, ring.PartShift)
+	assert.Equal(t, 6, len(ring.Replica2Part2Dev))
+	assert.Equal(t, 6, len(ring.Devs))
+
+	// The removed device of west leaves a hole
+	assert.Equal(t, 3, ring.Devs[3].Id)
+	assert.Equal(t, 2, ring.Devs[3].Region)
+	assert.Nil(t, ring.Devs[4])
+	assert.Equal(t, 5, ring.Devs[5].Id)
+
+	for replica := 0; replica < 3; replica++ {
+		for part := 0; part < ring.PartCount(); part++ {
+			assert.Equal(t, east.Replica2Part2Dev[replica][part],
+				ring.Replica2Part2Dev[replica][part])
+			assert.Equal(t, west.Replica2Part2Dev[replica][part]+3,
+				ring.Replica2Part2Dev[replica+3][part])
+		}
+	}
+
+	// The composite ring is a regular ring
+	dir, err := ioutil.TempDir("", "ringbuilder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "object-1.ring.gz")
+	err = ring.Save(path)
+	assert.Nil(t, err)
+	loaded, err := LoadRingData(path)
+	assert.Nil(t, err)
+	assert.Equal(t, ring.Replica2Part2Dev, loaded.Replica2Part2Dev)
+}
+
+func TestComposeRingsInvalid(t *testing.T) {
+	east := setupComponent(t, 1)
+
+	_, err := ComposeRings([]*RingBuilder{east})
+	assert.Equal(t, ErrCompositeTooFewBuilders, err)
+
+	// Each region belongs to a single component
+	_, err = ComposeRings([]*RingBuilder{east, setupComponent(t, 1)})
+	assert.Contains(t, err.Error(), "Same region 1")
+
+	west := setupComponent(t, 2)
+	west.Devs[0].Ip = east.Devs[0].Ip
+	_, err = ComposeRings([]*RingBuilder{east, west})
+	assert.Contains(t, err.Error(), "Duplicate ip/port/device")
+
+	west = setupComponent(t, 2)
+	west.Id = east.Id
+	_, err = ComposeRings([]*RingBuilder{east, west})
+	assert.Contains(t, err.Error(), "used at indexes 0 and 1")
+
+	west = setupComponent(t, 2)
+	west.SetDevWeight(0, 50)
+	_, err = ComposeRings([]*RingBuilder{east, west})
+	assert.Equal(t, ErrCompositeNotRebalanced, err)
+
+	west, err = NewRingBuilder(9, 3, 1)
+	assert.Nil(t, err)
+	_, err = ComposeRings([]*RingBuilder{east, west})
+	assert.Contains(t, err.Error(), "same part power")
+
+	west, err = NewRingBuilder(8, 1.5, 1)
+	assert.Nil(t, err)
+	_, err = ComposeRings([]*RingBuilder{east, west})
+	assert.Contains(t, err.Error(), "non integer replica count")
+}
+
+func TestCompositeMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ringbuilder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	east := setupComponent(t, 1)
+	west := setupComponent(t, 2)
+	paths := []string{"/etc/swift/east.builder", "/etc/swift/west.builder"}
+
+	m := NewCompositeMetadata()
+	ring, err := m.Compose([]*RingBuilder{east, west}, paths)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, m.Version)
+	assert.Equal(t, 1, ring.Version)
+	assert.Equal(t, &CompositeComponent{Id: west.Id, Version: 1, Replicas: 3}, m.Components[1])
+	assert.Equal(t, paths[0], m.ComponentBuilderFiles[east.Id])
+
+	path := filepath.Join(dir, "object-1.composite.json")
+	err = m.Save(path)
+	assert.Nil(t, err)
+	m, err = LoadCompositeMetadata(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, m.Version)
+	assert.Equal(t, 2, len(m.Components))
+
+	// Components stay in the same order
+	_, err = m.Compose([]*RingBuilder{west, east}, paths)
+	assert.Contains(t, err.Error(), "Invalid builder change at index 0")
+	_, err = m.Compose([]*RingBuilder{east}, paths[:1])
+	assert.Contains(t, err.Error(), "Number of component builders has changed")
+	assert.Equal(t, 1, m.Version)
+
+	west.SetDevWeight(0, 50)
+	_, err = west.Rebalance(1)
+	assert.Nil(t, err)
+	ring, err = m.Compose([]*RingBuilder{east, west}, paths)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, ring.Version)
+	assert.Equal(t, 2, m.Components[1].Version)
+
+	// A component can not go back to an older version
+	m.Components[0].Version = 5
+	_, err = m.Compose([]*RingBuilder{east, west}, paths)
+	assert.Contains(t, err.Error(), "older builder version 1")
+}
+
+func TestLoadCompositeMetadataSwift(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ringbuilder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// As written by swift's ring-composer
+	path := filepath.Join(dir, "composite.json")
+	err = ioutil.WriteFile(path, []byte(`{"component_builder_files": `+
+		`{"3e9a0d1c": "/etc/swift/east.builder", "b7c2f4a8": "/etc/swift/west.builder"}, `+
+		`"components": [{"id": "3e9a0d1c", "replicas": 3, "version": 4}, `+
+		`{"id": "b7c2f4a8", "replicas": 3, "version": 2}], `+
+		`"serialization_version": 1, "version": 7}`), 0644)
+	assert.Nil(t, err)
+
+	m, err := LoadCompositeMetadata(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 7, m.Version)
+	assert.Equal(t, &CompositeComponent{Id: "b7c2f4a8", Version: 2, Replicas: 3}, m.Components[1])
+	assert.Equal(t, "/etc/swift/east.builder", m.ComponentBuilderFiles["3e9a0d1c"])
+
+	err = ioutil.WriteFile(path, []byte(`{"serialization_version": 2}`), 0644)
+	assert.Nil(t, err)
+	_, err = LoadCompositeMetadata(path)
+	assert.Contains(t, err.Error(), "serialization version 2")
+}
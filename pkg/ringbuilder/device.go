@@ -0,0 +1,61 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringbuilder
+
+import "fmt"
+
+// Device is a swift storage device as known by the ring builder.
+type Device struct {
+	Id              int     `json:"id"`
+	Region          int     `json:"region"`
+	Zone            int     `json:"zone"`
+	Ip              string  `json:"ip"`
+	Port            int     `json:"port"`
+	ReplicationIp   string  `json:"replication_ip"`
+	ReplicationPort int     `json:"replication_port"`
+	Device          string  `json:"device"`
+	Weight          float64 `json:"weight"`
+	Meta            string  `json:"meta"`
+
+	// Number of partition replicas assigned to the device
+	Parts int `json:"parts"`
+}
+
+// Tier levels used to spread the replicas of a partition
+const (
+	TierRegion = iota
+	TierZone
+	TierIp
+	TierDevice
+	tierCount
+)
+
+var TierNames = []string{"region", "zone", "ip", "device"}
+
+// Tiers returns the failure domains of the device from the widest
+// (region) to the narrowest (device)
+func (d *Device) Tiers() [tierCount]string {
+	region := fmt.Sprintf("r%d", d.Region)
+	zone := fmt.Sprintf("%sz%d", region, d.Zone)
+	ip := fmt.Sprintf("%s-%s", zone, d.Ip)
+	dev := fmt.Sprintf("%s/%d", ip, d.Id)
+
+	return [tierCount]string{region, zone, ip, dev}
+}
+
+func (d *Device) String() string {
+	return fmt.Sprintf("d%dr%dz%d-%s:%d/%s", d.Id, d.Region, d.Zone, d.Ip, d.Port, d.Device)
+}
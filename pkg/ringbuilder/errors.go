@@ -0,0 +1,33 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringbuilder
+
+import (
+	"errors"
+)
+
+var (
+	ErrPartPower       = errors.New("Part power must be between 1 and 32")
+	ErrReplicas        = errors.New("Replica count must be between 1 and 64")
+	ErrMinPartHours    = errors.New("Min part hours must not be negative")
+	ErrNoDevices       = errors.New("There are no devices with weight in the ring")
+	ErrTooManyDevices  = errors.New("Maximum number of devices reached")
+	ErrDeviceExists    = errors.New("Device id already in use")
+	ErrDeviceNotFound  = errors.New("Device id not found")
+	ErrDeviceDuplicate = errors.New("A device with the same ip, port and name already exists")
+	ErrInvalidDevice   = errors.New("Device must have an ip, a port and a name")
+	ErrInvalidWeight   = errors.New("Device weight must not be negative")
+)
@@ -0,0 +1,145 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringbuilder
+
+import (
+	"encoding/gob"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ErrNotSaved is a file that is not a builder written by Save, like the
+// builders pickled by swift-ring-builder
+var ErrNotSaved = errors.New("Not a builder file of the native ring builder")
+
+// builderFile is the on-disk representation of a RingBuilder.  gob is
+// unable to encode the nil holes left in Devs, so only the devices in
+// use are saved.
+type builderFile struct {
+	Id                 string
+	PartPower          int
+	Replicas           float64
+	MinPartHours       int
+	Parts              int
+	Version            int
+	Devs               []Device
+	DevsLen            int
+	Replica2Part2Dev   [][]uint16
+	LastPartMoves      []uint8
+	LastPartMovesEpoch int64
+	RemoveDevs         []int
+	DevsChanged        bool
+}
+
+// Save atomically writes the builder to path
+func (b *RingBuilder) Save(path string) error {
+	f := builderFile{
+		Id:                 b.Id,
+		PartPower:          b.PartPower,
+		Replicas:           b.Replicas,
+		MinPartHours:       b.MinPartHours,
+		Parts:              b.Parts,
+		Version:            b.Version,
+		Devs:               make([]Device, 0, len(b.Devs)),
+		DevsLen:            len(b.Devs),
+		Replica2Part2Dev:   b.Replica2Part2Dev,
+		LastPartMoves:      b.LastPartMoves,
+		LastPartMovesEpoch: b.LastPartMovesEpoch,
+		RemoveDevs:         b.RemoveDevs,
+		DevsChanged:        b.DevsChanged,
+	}
+	for _, d := range b.Devs {
+		if d != nil {
+			f.Devs = append(f.Devs, *d)
+		}
+	}
+
+	return writeFileAtomic(path, func(w *os.File) error {
+		return gob.NewEncoder(w).Encode(&f)
+	})
+}
+
+// Load reads a builder saved with Save
+func Load(path string) (*RingBuilder, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	var f builderFile
+	err = gob.NewDecoder(fp).Decode(&f)
+	if err != nil {
+		return nil, ErrNotSaved
+	}
+	if f.DevsLen < 0 {
+		return nil, ErrDeviceNotFound
+	}
+
+	b := &RingBuilder{
+		Id:                 f.Id,
+		PartPower:          f.PartPower,
+		Replicas:           f.Replicas,
+		MinPartHours:       f.MinPartHours,
+		Parts:              f.Parts,
+		Version:            f.Version,
+		Devs:               make([]*Device, f.DevsLen),
+		Replica2Part2Dev:   f.Replica2Part2Dev,
+		LastPartMoves:      f.LastPartMoves,
+		LastPartMovesEpoch: f.LastPartMovesEpoch,
+		RemoveDevs:         f.RemoveDevs,
+		DevsChanged:        f.DevsChanged,
+	}
+	for i := range f.Devs {
+		d := f.Devs[i]
+		if d.Id < 0 || d.Id >= len(b.Devs) {
+			return nil, ErrDeviceNotFound
+		}
+		b.Devs[d.Id] = &d
+	}
+
+	return b, nil
+}
+
+// writeFileAtomic writes to a temporary file in the same directory and
+// renames it over path once write succeeded
+func writeFileAtomic(path string, write func(w *os.File) error) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = tmp.Chmod(0644)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = write(tmp)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,640 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringbuilder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var ErrPickle = errors.New("Unable to unpickle the builder file")
+
+// pickleGlobal is a class or function named in a pickle
+type pickleGlobal struct {
+	module string
+	name   string
+}
+
+// pickleObject is the result of calling a global the unpickler does not
+// know, kept as is
+type pickleObject struct {
+	class pickleGlobal
+	args  []interface{}
+	state interface{}
+}
+
+// pickleTuple is an immutable list
+type pickleTuple []interface{}
+
+// pickleList is a list, which is shared through the memo while it is
+// being filled
+type pickleList struct {
+	items []interface{}
+}
+
+// pickleDict is a dict.  Keys that are not strings or numbers are turned
+// into strings.
+type pickleDict map[interface{}]interface{}
+
+type pickleMark struct{}
+
+// unpickler runs the subset of the pickle machine needed to read the
+// builder files swift writes, with pickle protocols 0 to 4.  Nothing is
+// executed: globals are only recorded, except the few used to pickle
+// arrays and bytes.
+type unpickler struct {
+	src   *bytes.Reader
+	r     *bufio.Reader
+	stack []interface{}
+	memo  map[int]interface{}
+}
+
+func unpickle(r io.Reader) (interface{}, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	src := bytes.NewReader(data)
+	u := &unpickler{
+		src:  src,
+		r:    bufio.NewReader(src),
+		memo: make(map[int]interface{}),
+	}
+	return u.run()
+}
+
+// remaining returns the number of bytes left to read
+func (u *unpickler) remaining() int {
+	return u.src.Len() + u.r.Buffered()
+}
+
+func (u *unpickler) push(v interface{}) {
+	u.stack = append(u.stack, v)
+}
+
+func (u *unpickler) pop() (interface{}, error) {
+	if len(u.stack) == 0 {
+		return nil, ErrPickle
+	}
+	v := u.stack[len(u.stack)-1]
+	u.stack = u.stack[:len(u.stack)-1]
+	return v, nil
+}
+
+func (u *unpickler) top() (interface{}, error) {
+	if len(u.stack) == 0 {
+		return nil, ErrPickle
+	}
+	return u.stack[len(u.stack)-1], nil
+}
+
+// popMark returns the items pushed since the last mark
+func (u *unpickler) popMark() ([]interface{}, error) {
+	for i := len(u.stack) - 1; i >= 0; i-- {
+		if _, ok := u.stack[i].(pickleMark); ok {
+			items := append([]interface{}(nil), u.stack[i+1:]...)
+			u.stack = u.stack[:i]
+			return items, nil
+		}
+	}
+	return nil, ErrPickle
+}
+
+// read returns the next n bytes.  Lengths are read from the file, so
+// they are checked before anything is allocated.
+func (u *unpickler) read(n int) ([]byte, error) {
+	if n < 0 || n > u.remaining() {
+		return nil, ErrPickle
+	}
+	buf := make([]byte, n)
+	_, err := io.ReadFull(u.r, buf)
+	if err != nil {
+		return nil, ErrPickle
+	}
+	return buf, nil
+}
+
+func (u *unpickler) readUint(n int) (uint64, error) {
+	buf, err := u.read(n)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for i := n - 1; i >= 0; i-- {
+		v = v<<8 | uint64(buf[i])
+	}
+	return v, nil
+}
+
+func (u *unpickler) readLine() (string, error) {
+	line, err := u.r.ReadString('\n')
+	if err != nil {
+		return "", ErrPickle
+	}
+	return strings.TrimSuffix(line, "\n"), nil
+}
+
+// decodeLong reads a little endian two's complement integer
+func decodeLong(buf []byte) interface{} {
+	if len(buf) == 0 {
+		return int64(0)
+	}
+	if len(buf) <= 8 {
+		var v uint64
+		for i := len(buf) - 1; i >= 0; i-- {
+			v = v<<8 | uint64(buf[i])
+		}
+		shift := uint(64 - 8*len(buf))
+		return int64(v<<shift) >> shift
+	}
+
+	be := make([]byte, len(buf))
+	for i, b := range buf {
+		be[len(buf)-1-i] = b
+	}
+	v := new(big.Int).SetBytes(be)
+	if buf[len(buf)-1]&0x80 != 0 {
+		v.Sub(v, new(big.Int).Lsh(big.NewInt(1), uint(8*len(buf))))
+	}
+	if v.IsInt64() {
+		return v.Int64()
+	}
+	return v
+}
+
+func pickleKey(k interface{}) interface{} {
+	switch k.(type) {
+	case nil, bool, int64, float64, string:
+		return k
+	default:
+		return fmt.Sprint(k)
+	}
+}
+
+func (u *unpickler) setItems(dict interface{}, items []interface{}) error {
+	d, ok := dict.(pickleDict)
+	if !ok || len(items)%2 != 0 {
+		return ErrPickle
+	}
+	for i := 0; i < len(items); i += 2 {
+		d[pickleKey(items[i])] = items[i+1]
+	}
+	return nil
+}
+
+func (u *unpickler) appendItems(list interface{}, items []interface{}) error {
+	l, ok := list.(*pickleList)
+	if !ok {
+		return ErrPickle
+	}
+	l.items = append(l.items, items...)
+	return nil
+}
+
+// call applies a global to its arguments.  Arrays and bytes are rebuilt,
+// anything else is kept as a pickleObject.
+func call(class interface{}, args []interface{}) interface{} {
+	global, ok := class.(pickleGlobal)
+	if !ok {
+		return &pickleObject{args: args}
+	}
+
+	switch global.module + "." + global.name {
+	case "array.array":
+		if len(args) == 2 {
+			if v, err := newPickleArray(args[0], args[1]); err == nil {
+				return v
+			}
+		}
+	case "array._array_reconstructor":
+		// Python 3 pickles arrays with protocol 3 and up as the class,
+		// typecode, machine format and bytes
+		if len(args) == 4 {
+			if v, err := newPickleArray(args[1], args[3]); err == nil {
+				return v
+			}
+		}
+	case "_codecs.encode":
+		// Python 3 pickles bytes with protocol 2 as a latin-1 string
+		if len(args) == 2 {
+			if s, ok := args[0].(string); ok {
+				buf := make([]byte, 0, len(s))
+				for _, r := range s {
+					buf = append(buf, byte(r))
+				}
+				return buf
+			}
+		}
+	case "__builtin__.bytearray", "builtins.bytearray", "__builtin__.bytes", "builtins.bytes":
+		if len(args) == 0 {
+			return []byte{}
+		}
+	}
+
+	return &pickleObject{class: global, args: args}
+}
+
+// newPickleArray returns the integers of an array.array, pickled either
+// as a list by Python 3 or as the raw machine bytes by Python 2
+func newPickleArray(typecode, data interface{}) ([]int64, error) {
+	code, ok := typecode.(string)
+	if !ok {
+		return nil, ErrPickle
+	}
+
+	switch v := data.(type) {
+	case *pickleList:
+		values := make([]int64, len(v.items))
+		for i, item := range v.items {
+			n, ok := item.(int64)
+			if !ok {
+				return nil, ErrPickle
+			}
+			values[i] = n
+		}
+		return values, nil
+	case []byte:
+		return decodeArrayBytes(code, v)
+	case string:
+		buf := make([]byte, 0, len(v))
+		for _, r := range v {
+			buf = append(buf, byte(r))
+		}
+		return decodeArrayBytes(code, buf)
+	}
+	return nil, ErrPickle
+}
+
+func decodeArrayBytes(code string, buf []byte) ([]int64, error) {
+	var size int
+	switch code {
+	case "B", "b":
+		size = 1
+	case "H", "h":
+		size = 2
+	case "I", "i":
+		size = 4
+	default:
+		return nil, ErrPickle
+	}
+	if len(buf)%size != 0 {
+		return nil, ErrPickle
+	}
+
+	values := make([]int64, len(buf)/size)
+	for i := range values {
+		switch size {
+		case 1:
+			values[i] = int64(buf[i])
+		case 2:
+			values[i] = int64(binary.LittleEndian.Uint16(buf[2*i:]))
+		case 4:
+			values[i] = int64(binary.LittleEndian.Uint32(buf[4*i:]))
+		}
+	}
+	return values, nil
+}
+
+func (u *unpickler) run() (interface{}, error) {
+	for {
+		op, err := u.r.ReadByte()
+		if err != nil {
+			return nil, ErrPickle
+		}
+
+		switch op {
+		case 0x80: // PROTO
+			_, err = u.read(1)
+		case 0x95: // FRAME
+			_, err = u.read(8)
+		case '.': // STOP
+			return u.pop()
+		case '(': // MARK
+			u.push(pickleMark{})
+		case '0': // POP
+			_, err = u.pop()
+		case '1': // POP_MARK
+			_, err = u.popMark()
+		case '2': // DUP
+			var v interface{}
+			if v, err = u.top(); err == nil {
+				u.push(v)
+			}
+
+		case 'N': // NONE
+			u.push(nil)
+		case 0x88: // NEWTRUE
+			u.push(true)
+		case 0x89: // NEWFALSE
+			u.push(false)
+		case 'J': // BININT
+			var v uint64
+			if v, err = u.readUint(4); err == nil {
+				u.push(int64(int32(uint32(v))))
+			}
+		case 'K': // BININT1
+			var v uint64
+			if v, err = u.readUint(1); err == nil {
+				u.push(int64(v))
+			}
+		case 'M': // BININT2
+			var v uint64
+			if v, err = u.readUint(2); err == nil {
+				u.push(int64(v))
+			}
+		case 'I': // INT
+			var line string
+			if line, err = u.readLine(); err == nil {
+				switch line {
+				case "01":
+					u.push(true)
+				case "00":
+					u.push(false)
+				default:
+					var v int64
+					if v, err = strconv.ParseInt(line, 10, 64); err == nil {
+						u.push(v)
+					}
+				}
+			}
+		case 'L': // LONG
+			var line string
+			if line, err = u.readLine(); err == nil {
+				v, ok := new(big.Int).SetString(strings.TrimSuffix(line, "L"), 10)
+				if !ok {
+					return nil, ErrPickle
+				}
+				if v.IsInt64() {
+					u.push(v.Int64())
+				} else {
+					u.push(v)
+				}
+			}
+		case 0x8a, 0x8b: // LONG1, LONG4
+			size := 1
+			if op == 0x8b {
+				size = 4
+			}
+			var n uint64
+			var buf []byte
+			if n, err = u.readUint(size); err == nil {
+				if buf, err = u.read(int(n)); err == nil {
+					u.push(decodeLong(buf))
+				}
+			}
+		case 'G': // BINFLOAT
+			var buf []byte
+			if buf, err = u.read(8); err == nil {
+				u.push(math.Float64frombits(binary.BigEndian.Uint64(buf)))
+			}
+		case 'F': // FLOAT
+			var line string
+			if line, err = u.readLine(); err == nil {
+				var v float64
+				if v, err = strconv.ParseFloat(line, 64); err == nil {
+					u.push(v)
+				}
+			}
+
+		case 'T', 'U', 'X', 0x8c, 0x8d, 'B', 'C', 0x8e:
+			// BINSTRING, SHORT_BINSTRING, BINUNICODE, SHORT_BINUNICODE,
+			// BINUNICODE8, BINBYTES, SHORT_BINBYTES, BINBYTES8
+			size := map[byte]int{'T': 4, 'U': 1, 'X': 4, 0x8c: 1, 0x8d: 8, 'B': 4, 'C': 1, 0x8e: 8}[op]
+			var n uint64
+			var buf []byte
+			if n, err = u.readUint(size); err == nil {
+				if buf, err = u.read(int(n)); err == nil {
+					switch op {
+					case 'B', 'C', 0x8e:
+						u.push(buf)
+					default:
+						u.push(string(buf))
+					}
+				}
+			}
+		case 'S': // STRING
+			var line string
+			if line, err = u.readLine(); err == nil {
+				var v string
+				if v, err = strconv.Unquote(pythonQuoted(line)); err == nil {
+					u.push(v)
+				}
+			}
+		case 'V': // UNICODE
+			var line string
+			if line, err = u.readLine(); err == nil {
+				u.push(line)
+			}
+
+		case ']': // EMPTY_LIST
+			u.push(&pickleList{})
+		case 'l': // LIST
+			var items []interface{}
+			if items, err = u.popMark(); err == nil {
+				u.push(&pickleList{items: items})
+			}
+		case 'a': // APPEND
+			var v, list interface{}
+			if v, err = u.pop(); err == nil {
+				if list, err = u.top(); err == nil {
+					err = u.appendItems(list, []interface{}{v})
+				}
+			}
+		case 'e': // APPENDS
+			var items []interface{}
+			var list interface{}
+			if items, err = u.popMark(); err == nil {
+				if list, err = u.top(); err == nil {
+					err = u.appendItems(list, items)
+				}
+			}
+
+		case ')': // EMPTY_TUPLE
+			u.push(pickleTuple{})
+		case 't': // TUPLE
+			var items []interface{}
+			if items, err = u.popMark(); err == nil {
+				u.push(pickleTuple(items))
+			}
+		case 0x85, 0x86, 0x87: // TUPLE1, TUPLE2, TUPLE3
+			n := int(op-0x85) + 1
+			if len(u.stack) < n {
+				return nil, ErrPickle
+			}
+			items := append(pickleTuple(nil), u.stack[len(u.stack)-n:]...)
+			u.stack = u.stack[:len(u.stack)-n]
+			u.push(items)
+
+		case '}': // EMPTY_DICT
+			u.push(pickleDict{})
+		case 'd': // DICT
+			var items []interface{}
+			if items, err = u.popMark(); err == nil {
+				dict := pickleDict{}
+				if err = u.setItems(dict, items); err == nil {
+					u.push(dict)
+				}
+			}
+		case 's': // SETITEM
+			if len(u.stack) < 3 {
+				return nil, ErrPickle
+			}
+			items := u.stack[len(u.stack)-2:]
+			err = u.setItems(u.stack[len(u.stack)-3], items)
+			u.stack = u.stack[:len(u.stack)-2]
+		case 'u': // SETITEMS
+			var items []interface{}
+			var dict interface{}
+			if items, err = u.popMark(); err == nil {
+				if dict, err = u.top(); err == nil {
+					err = u.setItems(dict, items)
+				}
+			}
+		case 0x8f: // EMPTY_SET
+			u.push(&pickleList{})
+		case 0x90: // ADDITEMS
+			var items []interface{}
+			var set interface{}
+			if items, err = u.popMark(); err == nil {
+				if set, err = u.top(); err == nil {
+					err = u.appendItems(set, items)
+				}
+			}
+
+		case 'p': // PUT
+			var line string
+			if line, err = u.readLine(); err == nil {
+				var n int
+				if n, err = strconv.Atoi(line); err == nil {
+					err = u.put(n)
+				}
+			}
+		case 'q', 'r': // BINPUT, LONG_BINPUT
+			size := 1
+			if op == 'r' {
+				size = 4
+			}
+			var n uint64
+			if n, err = u.readUint(size); err == nil {
+				err = u.put(int(n))
+			}
+		case 0x94: // MEMOIZE
+			err = u.put(len(u.memo))
+		case 'g': // GET
+			var line string
+			if line, err = u.readLine(); err == nil {
+				var n int
+				if n, err = strconv.Atoi(line); err == nil {
+					err = u.get(n)
+				}
+			}
+		case 'h', 'j': // BINGET, LONG_BINGET
+			size := 1
+			if op == 'j' {
+				size = 4
+			}
+			var n uint64
+			if n, err = u.readUint(size); err == nil {
+				err = u.get(int(n))
+			}
+
+		case 'c': // GLOBAL
+			var module, name string
+			if module, err = u.readLine(); err == nil {
+				if name, err = u.readLine(); err == nil {
+					u.push(pickleGlobal{module: module, name: name})
+				}
+			}
+		case 0x93: // STACK_GLOBAL
+			var name, module interface{}
+			if name, err = u.pop(); err == nil {
+				if module, err = u.pop(); err == nil {
+					m, ok1 := module.(string)
+					n, ok2 := name.(string)
+					if !ok1 || !ok2 {
+						return nil, ErrPickle
+					}
+					u.push(pickleGlobal{module: m, name: n})
+				}
+			}
+		case 'R', 0x81: // REDUCE, NEWOBJ
+			var args, class interface{}
+			if args, err = u.pop(); err == nil {
+				if class, err = u.pop(); err == nil {
+					tuple, ok := args.(pickleTuple)
+					if !ok {
+						return nil, ErrPickle
+					}
+					u.push(call(class, tuple))
+				}
+			}
+		case 'b': // BUILD
+			var state, obj interface{}
+			if state, err = u.pop(); err == nil {
+				if obj, err = u.top(); err == nil {
+					if o, ok := obj.(*pickleObject); ok {
+						o.state = state
+					}
+				}
+			}
+
+		default:
+			return nil, fmt.Errorf("Unsupported pickle opcode 0x%x", op)
+		}
+
+		if err != nil {
+			return nil, ErrPickle
+		}
+	}
+}
+
+func (u *unpickler) put(n int) error {
+	v, err := u.top()
+	if err != nil {
+		return err
+	}
+	u.memo[n] = v
+	return nil
+}
+
+func (u *unpickler) get(n int) error {
+	v, ok := u.memo[n]
+	if !ok {
+		return ErrPickle
+	}
+	u.push(v)
+	return nil
+}
+
+// pythonQuoted turns a python string literal into a go one
+func pythonQuoted(s string) string {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		inner := strings.Replace(s[1:len(s)-1], `"`, `\"`, -1)
+		inner = strings.Replace(inner, `\'`, `'`, -1)
+		return `"` + inner + `"`
+	}
+	return s
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringbuilder
+
+import (
+	"time"
+)
+
+// Report is the placement of the partitions of a rebalanced builder, as
+// swift-ring-builder shows it with its default and dispersion commands
+type Report struct {
+	Parts    int     `json:"parts"`
+	Replicas float64 `json:"replicas"`
+
+	// Highest percentage by which a device is over or under its wanted
+	// number of partitions
+	Balance float64 `json:"balance"`
+
+	// Percentage of the partitions with more replicas in a failure
+	// domain, at any tier, than the weight of the domain calls for
+	Dispersion float64 `json:"dispersion"`
+
+	Devices []*DeviceReport `json:"devices"`
+	Tiers   []*TierReport   `json:"tiers"`
+
+	// The hours since each partition last moved are counted from the
+	// epoch, unset when the builder does not know them.  LastMoved is
+	// the last time a partition is known to have moved.
+	LastPartMovesEpoch *time.Time `json:"last_part_moves_epoch,omitempty"`
+	LastMoved          *time.Time `json:"last_moved,omitempty"`
+}
+
+// DeviceReport is the share of the partitions held by a device
+type DeviceReport struct {
+	Id          int     `json:"id"`
+	Region      int     `json:"region"`
+	Zone        int     `json:"zone"`
+	Ip          string  `json:"ip"`
+	Port        int     `json:"port"`
+	Device      string  `json:"device"`
+	Weight      float64 `json:"weight"`
+	Parts       int     `json:"parts"`
+	PartsWanted float64 `json:"parts_wanted"`
+
+	// Percentage by which the device is over, or under when negative,
+	// its wanted number of partitions
+	Balance float64 `json:"balance"`
+
+	// Last time a replica of a partition held by the device moved
+	LastMoved *time.Time `json:"last_moved,omitempty"`
+}
+
+// TierReport is the dispersion of the partitions over the failure
+// domains of a tier: region, zone, ip or device
+type TierReport struct {
+	Tier    string `json:"tier"`
+	Domains int    `json:"domains"`
+
+	// Partitions with more replicas in a failure domain than the weight
+	// of the domain calls for, and their percentage of all partitions
+	PartsAtRisk int     `json:"parts_at_risk"`
+	Dispersion  float64 `json:"dispersion"`
+
+	// Partitions with two replicas or more in the same failure domain
+	PartsSameDomain int `json:"parts_same_domain"`
+}
+
+// Report returns the placement of the partitions of the builder
+func (b *RingBuilder) Report() *Report {
+	report := &Report{
+		Parts:    b.Parts,
+		Replicas: b.Replicas,
+		Balance:  b.Balance(),
+		Devices:  make([]*DeviceReport, 0, len(b.Devs)),
+		Tiers:    make([]*TierReport, tierCount),
+	}
+
+	lastMoved := b.lastMoved()
+	wanted := b.partsWanted()
+	devices := make(map[int]*DeviceReport)
+	for _, d := range b.Devs {
+		if d == nil {
+			continue
+		}
+		dev := &DeviceReport{
+			Id:          d.Id,
+			Region:      d.Region,
+			Zone:        d.Zone,
+			Ip:          d.Ip,
+			Port:        d.Port,
+			Device:      d.Device,
+			Weight:      d.Weight,
+			Parts:       d.Parts,
+			PartsWanted: wanted[d.Id],
+		}
+		if d.Weight > 0 {
+			dev.Balance = 100*float64(d.Parts)/wanted[d.Id] - 100
+		} else if d.Parts > 0 {
+			dev.Balance = MaxBalance
+		}
+		report.Devices = append(report.Devices, dev)
+		devices[d.Id] = dev
+	}
+
+	domains := make([]map[string]bool, tierCount)
+	for level := range report.Tiers {
+		report.Tiers[level] = &TierReport{Tier: TierNames[level]}
+		domains[level] = make(map[string]bool)
+	}
+	for _, d := range b.weightedDevs() {
+		for level, tier := range d.Tiers() {
+			domains[level][tier] = true
+		}
+	}
+
+	shares := b.tierShares()
+	atRisk := 0
+	for part := 0; part < b.Parts; part++ {
+		partTiers := b.partTiers(part)
+		risky := false
+		for level, tr := range report.Tiers {
+			counts := make(map[string]int)
+			for _, tiers := range partTiers {
+				counts[tiers[level]]++
+			}
+
+			partRisky, same := false, false
+			for tier, count := range counts {
+				if count > maxReplicas(shares[tier], len(partTiers)) {
+					partRisky = true
+				}
+				if count > 1 {
+					same = true
+				}
+			}
+			if partRisky {
+				tr.PartsAtRisk++
+				risky = true
+			}
+			if same {
+				tr.PartsSameDomain++
+			}
+		}
+		if risky {
+			atRisk++
+		}
+
+		if lastMoved != nil {
+			moved := lastMoved[part]
+			if moved == nil {
+				continue
+			}
+			for _, part2dev := range b.Replica2Part2Dev {
+				if part >= len(part2dev) || !b.validDev(part2dev[part]) {
+					continue
+				}
+				dev := devices[int(part2dev[part])]
+				if dev.LastMoved == nil || moved.After(*dev.LastMoved) {
+					dev.LastMoved = moved
+				}
+			}
+			if report.LastMoved == nil || moved.After(*report.LastMoved) {
+				report.LastMoved = moved
+			}
+		}
+	}
+
+	for level, tr := range report.Tiers {
+		tr.Domains = len(domains[level])
+		if b.Parts > 0 {
+			tr.Dispersion = 100 * float64(tr.PartsAtRisk) / float64(b.Parts)
+		}
+	}
+	if b.Parts > 0 {
+		report.Dispersion = 100 * float64(atRisk) / float64(b.Parts)
+	}
+	if lastMoved != nil && b.LastPartMovesEpoch > 0 {
+		epoch := time.Unix(b.LastPartMovesEpoch, 0).UTC()
+		report.LastPartMovesEpoch = &epoch
+	}
+
+	return report
+}
+
+// lastMoved returns when each partition last moved, nil for those moved
+// too long ago to be known, or nothing if the builder does not know
+func (b *RingBuilder) lastMoved() []*time.Time {
+	if len(b.LastPartMoves) != b.Parts {
+		return nil
+	}
+
+	moved := make([]*time.Time, b.Parts)
+	for part, hours := range b.LastPartMoves {
+		if hours == maxLastPartMoves {
+			continue
+		}
+		at := time.Unix(b.LastPartMovesEpoch-int64(hours)*3600, 0).UTC()
+		moved[part] = &at
+	}
+	return moved
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringbuilder
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReport(t *testing.T) {
+	b := setupBuilder(t, 3, 1)
+	_, err := b.Rebalance(1)
+	assert.Nil(t, err)
+
+	report := b.Report()
+	assert.Equal(t, 256, report.Parts)
+	assert.Equal(t, 3.0, report.Replicas)
+	assert.Equal(t, 0.0, report.Balance)
+	assert.Equal(t, 0.0, report.Dispersion)
+	assert.Equal(t, 3, len(report.Devices))
+	assert.Equal(t, 256, report.Devices[0].Parts)
+	assert.Equal(t, 256.0, report.Devices[0].PartsWanted)
+
+	// All the replicas are in the only region, one per zone
+	assert.Equal(t, "region", report.Tiers[TierRegion].Tier)
+	assert.Equal(t, 1, report.Tiers[TierRegion].Domains)
+	assert.Equal(t, 256, report.Tiers[TierRegion].PartsSameDomain)
+	assert.Equal(t, 0, report.Tiers[TierRegion].PartsAtRisk)
+	assert.Equal(t, 3, report.Tiers[TierZone].Domains)
+	assert.Equal(t, 0, report.Tiers[TierZone].PartsSameDomain)
+
+	// Every partition moved with the first rebalance
+	assert.NotNil(t, report.LastPartMovesEpoch)
+	assert.Equal(t, time.Unix(b.LastPartMovesEpoch, 0).UTC(), *report.LastMoved)
+	assert.Equal(t, report.LastMoved, report.Devices[2].LastMoved)
+}
+
+func TestReportDispersion(t *testing.T) {
+	b := setupBuilder(t, 3, 2)
+	_, err := b.Rebalance(1)
+	assert.Nil(t, err)
+
+	// Two replicas of partition 0 on the same device
+	b.Replica2Part2Dev[1][0] = b.Replica2Part2Dev[0][0]
+	b.recountParts()
+
+	report := b.Report()
+	for _, tier := range []int{TierZone, TierIp, TierDevice} {
+		assert.Equal(t, 1, report.Tiers[tier].PartsAtRisk, TierNames[tier])
+		assert.Equal(t, 1, report.Tiers[tier].PartsSameDomain, TierNames[tier])
+		assert.Equal(t, 100.0/256, report.Tiers[tier].Dispersion, TierNames[tier])
+	}
+	assert.Equal(t, 100.0/256, report.Dispersion)
+	assert.True(t, report.Balance > 0)
+
+	id := b.Replica2Part2Dev[0][0]
+	for _, d := range report.Devices {
+		if d.Id == int(id) {
+			assert.True(t, d.Balance > 0)
+		}
+	}
+}
+
+func TestReportFromRing(t *testing.T) {
+	ring, err := LoadRingData("testdata/object.ring.gz")
+	assert.Nil(t, err)
+	b, err := NewRingBuilderFromRing(ring, 1)
+	assert.Nil(t, err)
+
+	// A ring does not know when its partitions moved
+	report := b.Report()
+	assert.Equal(t, ring.PartCount(), report.Parts)
+	assert.Nil(t, report.LastPartMovesEpoch)
+	assert.Nil(t, report.LastMoved)
+	for _, d := range report.Devices {
+		assert.Nil(t, d.LastMoved)
+	}
+}
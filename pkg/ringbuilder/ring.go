@@ -0,0 +1,279 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringbuilder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	ringMagic   = "R1NG"
+	ringVersion = 1
+
+	// swift writes its rings with a fixed mtime so that the same ring
+	// always has the same checksum
+	ringMtime = 1300507380
+
+	// Largest json header read from a ring file
+	maxRingHeaderSize = 64 << 20
+)
+
+var ErrRingFormat = errors.New("Not a swift ring file")
+
+// RingData is the content of a swift .ring.gz file, as loaded by the
+// proxy and storage nodes
+type RingData struct {
+	// Devices indexed by id.  Removed devices leave a nil hole
+	Devs             []*Device
+	Replica2Part2Dev [][]uint16
+	PartShift        int
+	Version          int
+}
+
+// ringDev is a device as serialized in the ring.  Fields are in
+// alphabetical order, like swift's sorted json keys.
+type ringDev struct {
+	Device          string  `json:"device"`
+	Id              int     `json:"id"`
+	Ip              string  `json:"ip"`
+	Meta            string  `json:"meta"`
+	Port            int     `json:"port"`
+	Region          int     `json:"region"`
+	ReplicationIp   string  `json:"replication_ip"`
+	ReplicationPort int     `json:"replication_port"`
+	Weight          float64 `json:"weight"`
+	Zone            int     `json:"zone"`
+}
+
+type ringHeader struct {
+	ByteOrder    string     `json:"byteorder"`
+	Devs         []*ringDev `json:"devs"`
+	PartShift    int        `json:"part_shift"`
+	ReplicaCount int        `json:"replica_count"`
+	Version      *int       `json:"version,omitempty"`
+}
+
+// GetRing returns the ring data of the last rebalance
+func (b *RingBuilder) GetRing() *RingData {
+	ring := &RingData{
+		Devs:             make([]*Device, len(b.Devs)),
+		Replica2Part2Dev: make([][]uint16, len(b.Replica2Part2Dev)),
+		PartShift:        32 - b.PartPower,
+		Version:          b.Version,
+	}
+	for i, d := range b.Devs {
+		if d != nil {
+			dev := *d
+			ring.Devs[i] = &dev
+		}
+	}
+	for i, part2dev := range b.Replica2Part2Dev {
+		ring.Replica2Part2Dev[i] = append([]uint16(nil), part2dev...)
+	}
+
+	return ring
+}
+
+// PartCount returns the number of partitions of the ring
+func (r *RingData) PartCount() int {
+	return 1 << uint(32-r.PartShift)
+}
+
+// Serialize writes the ring uncompressed in swift's version 1 format:
+// the magic header, the json metadata and the array packed
+// replica2part2dev table
+func (r *RingData) Serialize(w io.Writer) error {
+	header := ringHeader{
+		ByteOrder:    "little",
+		Devs:         make([]*ringDev, len(r.Devs)),
+		PartShift:    r.PartShift,
+		ReplicaCount: len(r.Replica2Part2Dev),
+		Version:      &r.Version,
+	}
+	for i, d := range r.Devs {
+		if d == nil {
+			continue
+		}
+		header.Devs[i] = &ringDev{
+			Device:          d.Device,
+			Id:              d.Id,
+			Ip:              d.Ip,
+			Meta:            d.Meta,
+			Port:            d.Port,
+			Region:          d.Region,
+			ReplicationIp:   d.ReplicationIp,
+			ReplicationPort: d.ReplicationPort,
+			Weight:          d.Weight,
+			Zone:            d.Zone,
+		}
+	}
+
+	jsonHeader, err := json.Marshal(&header)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(ringMagic)
+	binary.Write(&buf, binary.BigEndian, uint16(ringVersion))
+	binary.Write(&buf, binary.BigEndian, uint32(len(jsonHeader)))
+	buf.Write(jsonHeader)
+	for _, part2dev := range r.Replica2Part2Dev {
+		binary.Write(&buf, binary.LittleEndian, part2dev)
+	}
+
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// Deserialize reads a ring written in swift's version 1 format
+func Deserialize(r io.Reader) (*RingData, error) {
+	magic := make([]byte, len(ringMagic))
+	_, err := io.ReadFull(r, magic)
+	if err != nil || string(magic) != ringMagic {
+		return nil, ErrRingFormat
+	}
+
+	var version uint16
+	err = binary.Read(r, binary.BigEndian, &version)
+	if err != nil || version != ringVersion {
+		return nil, ErrRingFormat
+	}
+
+	// The sizes in the file are checked before anything is allocated
+	var jsonLen uint32
+	err = binary.Read(r, binary.BigEndian, &jsonLen)
+	if err != nil || jsonLen > maxRingHeaderSize {
+		return nil, ErrRingFormat
+	}
+	jsonHeader, err := ioutil.ReadAll(io.LimitReader(r, int64(jsonLen)))
+	if err != nil || len(jsonHeader) != int(jsonLen) {
+		return nil, ErrRingFormat
+	}
+
+	var header ringHeader
+	err = json.Unmarshal(jsonHeader, &header)
+	if err != nil {
+		return nil, err
+	}
+
+	if header.PartShift < 1 || header.PartShift > 32 || header.ReplicaCount < 1 ||
+		header.ReplicaCount > MaxReplicas {
+		return nil, ErrRingFormat
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if header.ByteOrder == "big" {
+		order = binary.BigEndian
+	}
+
+	ring := &RingData{
+		Devs:             make([]*Device, len(header.Devs)),
+		Replica2Part2Dev: make([][]uint16, 0),
+		PartShift:        header.PartShift,
+	}
+	if header.Version != nil {
+		ring.Version = *header.Version
+	}
+	for i, d := range header.Devs {
+		if d == nil {
+			continue
+		}
+		if d.Id != i {
+			return nil, ErrRingFormat
+		}
+		ring.Devs[i] = &Device{
+			Id:              d.Id,
+			Region:          d.Region,
+			Zone:            d.Zone,
+			Ip:              d.Ip,
+			Port:            d.Port,
+			ReplicationIp:   d.ReplicationIp,
+			ReplicationPort: d.ReplicationPort,
+			Device:          d.Device,
+			Weight:          d.Weight,
+			Meta:            d.Meta,
+		}
+	}
+
+	// The last replica only covers part of the partitions when the
+	// replica count is fractional.  The tables are only as large as the
+	// data actually in the file.
+	for replica := 0; replica < header.ReplicaCount; replica++ {
+		buf, err := ioutil.ReadAll(io.LimitReader(r, int64(2*ring.PartCount())))
+		if err != nil || len(buf) < 2 {
+			return nil, ErrRingFormat
+		}
+		part2dev := make([]uint16, len(buf)/2)
+		for i := range part2dev {
+			part2dev[i] = order.Uint16(buf[2*i:])
+		}
+		ring.Replica2Part2Dev = append(ring.Replica2Part2Dev, part2dev)
+	}
+
+	// Count the partitions of each device
+	for _, part2dev := range ring.Replica2Part2Dev {
+		for _, id := range part2dev {
+			if int(id) < len(ring.Devs) && ring.Devs[id] != nil {
+				ring.Devs[id].Parts++
+			}
+		}
+	}
+
+	return ring, nil
+}
+
+// Save atomically writes the gzipped ring to path
+func (r *RingData) Save(path string) error {
+	return writeFileAtomic(path, func(w *os.File) error {
+		gz := gzip.NewWriter(w)
+		gz.Name = strings.TrimSuffix(filepath.Base(path), ".gz")
+		gz.ModTime = time.Unix(ringMtime, 0)
+
+		err := r.Serialize(gz)
+		if err != nil {
+			return err
+		}
+		return gz.Close()
+	})
+}
+
+// LoadRingData reads a gzipped ring file
+func LoadRingData(path string) (*RingData, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	gz, err := gzip.NewReader(fp)
+	if err != nil {
+		return nil, ErrRingFormat
+	}
+	defer gz.Close()
+
+	return Deserialize(gz)
+}
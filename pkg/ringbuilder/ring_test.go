@@ -0,0 +1,181 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringbuilder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The fixtures in testdata are written by make_fixtures.py the same way
+// swift's RingData.save writes them
+
+func readGzip(t *testing.T, path string) []byte {
+	fp, err := os.Open(path)
+	assert.Nil(t, err)
+	defer fp.Close()
+
+	gz, err := gzip.NewReader(fp)
+	assert.Nil(t, err)
+	data, err := ioutil.ReadAll(gz)
+	assert.Nil(t, err)
+
+	return data
+}
+
+func TestLoadRingData(t *testing.T) {
+	ring, err := LoadRingData("testdata/object.ring.gz")
+	assert.Nil(t, err)
+
+	assert.Equal(t, 28, ring.PartShift)
+	assert.Equal(t, 16, ring.PartCount())
+	assert.Equal(t, 3, ring.Version)
+	assert.Equal(t, 4, len(ring.Devs))
+	assert.Nil(t, ring.Devs[2])
+	assert.Equal(t, &Device{
+		Id:              3,
+		Region:          1,
+		Zone:            3,
+		Ip:              "10.0.0.3",
+		Port:            6200,
+		ReplicationIp:   "10.1.0.3",
+		ReplicationPort: 6400,
+		Device:          "sdc",
+		Weight:          100,
+		Meta:            "fixture",
+		Parts:           16,
+	}, ring.Devs[3])
+
+	assert.Equal(t, 3, len(ring.Replica2Part2Dev))
+	for _, part2dev := range ring.Replica2Part2Dev {
+		assert.Equal(t, 16, len(part2dev))
+	}
+	assert.Equal(t, []uint16{0, 1, 3}, []uint16{
+		ring.Replica2Part2Dev[0][0],
+		ring.Replica2Part2Dev[1][0],
+		ring.Replica2Part2Dev[2][0],
+	})
+}
+
+func TestLoadRingDataFractional(t *testing.T) {
+	ring, err := LoadRingData("testdata/fractional.ring.gz")
+	assert.Nil(t, err)
+
+	assert.Equal(t, 7, ring.Version)
+	assert.Equal(t, 3, len(ring.Replica2Part2Dev))
+	assert.Equal(t, 16, len(ring.Replica2Part2Dev[1]))
+	assert.Equal(t, 8, len(ring.Replica2Part2Dev[2]))
+	assert.Equal(t, []uint16{3, 0, 1, 3, 0, 1, 3, 0}, ring.Replica2Part2Dev[2])
+}
+
+func TestLoadRingDataInvalid(t *testing.T) {
+	_, err := LoadRingData("testdata/make_fixtures.py")
+	assert.Equal(t, ErrRingFormat, err)
+}
+
+func TestDeserializeInvalidSizes(t *testing.T) {
+	ring := func(jsonLen uint32, header string) []byte {
+		var buf bytes.Buffer
+		buf.WriteString(ringMagic)
+		binary.Write(&buf, binary.BigEndian, uint16(ringVersion))
+		binary.Write(&buf, binary.BigEndian, jsonLen)
+		buf.WriteString(header)
+		return buf.Bytes()
+	}
+	header := func(partShift, replicas int) string {
+		return fmt.Sprintf(`{"byteorder":"little","devs":[],"part_shift":%v,"replica_count":%v}`,
+			partShift, replicas)
+	}
+
+	for _, data := range [][]byte{
+		// The header is larger than the file
+		ring(0xffffffff, ""),
+		ring(1000, header(30, 1)),
+
+		// The partitions do not fit in 32 bits
+		ring(uint32(len(header(0, 1))), header(0, 1)),
+		ring(uint32(len(header(33, 1))), header(33, 1)),
+
+		// No replica, or more than the file has
+		ring(uint32(len(header(30, 0))), header(30, 0)),
+		ring(uint32(len(header(1, 1000000000))), header(1, 1000000000)),
+	} {
+		_, err := Deserialize(bytes.NewReader(data))
+		assert.Equal(t, ErrRingFormat, err)
+	}
+}
+
+func TestRingDataRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ringbuilder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"object.ring.gz", "fractional.ring.gz"} {
+		fixture := filepath.Join("testdata", name)
+		ring, err := LoadRingData(fixture)
+		assert.Nil(t, err)
+
+		path := filepath.Join(dir, name)
+		err = ring.Save(path)
+		assert.Nil(t, err)
+
+		loaded, err := LoadRingData(path)
+		assert.Nil(t, err)
+		assert.Equal(t, ring, loaded)
+
+		// Only the json spacing may differ from swift, the header
+		// and the packed table must be identical
+		expected := readGzip(t, fixture)
+		actual := readGzip(t, path)
+		assert.Equal(t, expected[:6], actual[:6])
+		tableLen := 0
+		for _, part2dev := range ring.Replica2Part2Dev {
+			tableLen += 2 * len(part2dev)
+		}
+		assert.Equal(t, expected[len(expected)-tableLen:], actual[len(actual)-tableLen:])
+	}
+}
+
+func TestBuilderGetRing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ringbuilder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	b := setupBuilder(t, 3, 2)
+	_, err = b.Rebalance(1)
+	assert.Nil(t, err)
+
+	ring := b.GetRing()
+	assert.Equal(t, 24, ring.PartShift)
+	assert.Equal(t, b.Parts, ring.PartCount())
+	assert.Equal(t, b.Replica2Part2Dev, ring.Replica2Part2Dev)
+
+	path := filepath.Join(dir, "object.ring.gz")
+	err = ring.Save(path)
+	assert.Nil(t, err)
+
+	loaded, err := LoadRingData(path)
+	assert.Nil(t, err)
+	assert.Equal(t, ring, loaded)
+}
@@ -0,0 +1,297 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringbuilder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+var ErrBuilderFormat = errors.New("Not a swift builder or ring file")
+
+// Largest uncompressed file read by ImportBuilder
+const maxImportSize = 1 << 30
+
+// LoadSwiftBuilder reads a builder saved by swift-ring-builder.  Swift
+// pickles the dict returned by RingBuilder.to_dict, older versions the
+// RingBuilder object itself.
+func LoadSwiftBuilder(r io.Reader) (*RingBuilder, error) {
+	v, err := unpickle(r)
+	if err != nil {
+		return nil, err
+	}
+	if o, ok := v.(*pickleObject); ok {
+		v = o.state
+	}
+	d, ok := v.(pickleDict)
+	if !ok {
+		return nil, ErrBuilderFormat
+	}
+
+	b := &RingBuilder{
+		Id:           pickleString(d["id"]),
+		PartPower:    pickleInt(d["part_power"]),
+		Replicas:     pickleFloat(d["replicas"]),
+		MinPartHours: pickleInt(d["min_part_hours"]),
+		Parts:        pickleInt(d["parts"]),
+		Version:      pickleInt(d["version"]),
+		DevsChanged:  pickleBool(d["devs_changed"]),
+	}
+	if b.PartPower < 1 || b.PartPower > MaxPartPower {
+		return nil, ErrPartPower
+	}
+	if b.Replicas < 1 || b.Replicas > MaxReplicas {
+		return nil, ErrReplicas
+	}
+	if b.Id == "" {
+		b.Id = genId()
+	}
+	if b.Parts == 0 {
+		b.Parts = 1 << uint(b.PartPower)
+	}
+
+	devs, ok := d["devs"].(*pickleList)
+	if !ok {
+		return nil, ErrBuilderFormat
+	}
+	b.Devs = make([]*Device, len(devs.items))
+	for i, item := range devs.items {
+		if item == nil {
+			continue
+		}
+		dev, ok := item.(pickleDict)
+		if !ok {
+			return nil, ErrBuilderFormat
+		}
+		b.Devs[i] = newPickleDevice(dev)
+		if b.Devs[i].Id != i {
+			return nil, ErrBuilderFormat
+		}
+	}
+
+	if table, ok := d["_replica2part2dev"].(*pickleList); ok {
+		b.Replica2Part2Dev = make([][]uint16, len(table.items))
+		for replica, item := range table.items {
+			part2dev, ok := item.([]int64)
+			if !ok {
+				return nil, ErrBuilderFormat
+			}
+			b.Replica2Part2Dev[replica] = make([]uint16, len(part2dev))
+			for part, id := range part2dev {
+				b.Replica2Part2Dev[replica][part] = uint16(id)
+			}
+		}
+	}
+
+	if moves, ok := d["_last_part_moves"].([]int64); ok {
+		b.LastPartMoves = make([]uint8, len(moves))
+		for part, hours := range moves {
+			b.LastPartMoves[part] = uint8(hours)
+		}
+	}
+	b.LastPartMovesEpoch = int64(pickleInt(d["_last_part_moves_epoch"]))
+
+	if removed, ok := d["_remove_devs"].(*pickleList); ok {
+		for _, item := range removed.items {
+			if dev, ok := item.(pickleDict); ok {
+				b.RemoveDevs = append(b.RemoveDevs, pickleInt(dev["id"]))
+			}
+		}
+	}
+
+	err = b.validateImport()
+	if err != nil {
+		return nil, err
+	}
+
+	b.recountParts()
+	return b, nil
+}
+
+// NewRingBuilderFromRing returns a builder holding the partition
+// assignment of ring, so that the next rebalance only moves what changed
+// since the ring was built.  Swift does the same with write_builder when
+// the builder file was lost.
+func NewRingBuilderFromRing(ring *RingData, minPartHours int) (*RingBuilder, error) {
+	partPower := 32 - ring.PartShift
+	parts := ring.PartCount()
+	if len(ring.Replica2Part2Dev) == 0 {
+		return nil, ErrReplicas
+	}
+
+	replicas := float64(len(ring.Replica2Part2Dev))
+	if last := len(ring.Replica2Part2Dev[len(ring.Replica2Part2Dev)-1]); last < parts {
+		replicas += float64(last)/float64(parts) - 1
+	}
+
+	b, err := NewRingBuilder(partPower, replicas, minPartHours)
+	if err != nil {
+		return nil, err
+	}
+	b.Version = ring.Version
+
+	b.Devs = make([]*Device, len(ring.Devs))
+	for i, d := range ring.Devs {
+		if d != nil {
+			dev := *d
+			b.Devs[i] = &dev
+		}
+	}
+
+	b.Replica2Part2Dev = make([][]uint16, len(ring.Replica2Part2Dev))
+	for replica, part2dev := range ring.Replica2Part2Dev {
+		b.Replica2Part2Dev[replica] = append([]uint16(nil), part2dev...)
+	}
+
+	// Nothing is known about when partitions last moved, so they are
+	// all free to move again
+	b.LastPartMoves = make([]uint8, b.Parts)
+	for part := range b.LastPartMoves {
+		b.LastPartMoves[part] = 0xff
+	}
+
+	err = b.validateImport()
+	if err != nil {
+		return nil, err
+	}
+
+	b.recountParts()
+	return b, nil
+}
+
+// validateImport checks the tables of an imported builder agree with its
+// part power and devices, the builder indexes them without checking
+func (b *RingBuilder) validateImport() error {
+	if b.Parts != 1<<uint(b.PartPower) {
+		return ErrBuilderFormat
+	}
+	if len(b.Replica2Part2Dev) > MaxReplicas {
+		return ErrBuilderFormat
+	}
+	for _, part2dev := range b.Replica2Part2Dev {
+		if len(part2dev) > b.Parts {
+			return ErrBuilderFormat
+		}
+	}
+	if b.LastPartMoves != nil && len(b.LastPartMoves) != b.Parts {
+		return ErrBuilderFormat
+	}
+	for i, d := range b.Devs {
+		if d != nil && d.Id != i {
+			return ErrBuilderFormat
+		}
+	}
+	for _, id := range b.RemoveDevs {
+		if id < 0 || id >= len(b.Devs) {
+			return ErrBuilderFormat
+		}
+	}
+	return nil
+}
+
+// ImportBuilder reads either a swift .builder file or a .ring.gz file,
+// gzipped or not, and returns its builder.  The ring is true when data
+// was a ring file, in which case minPartHours is used as it is not saved
+// in rings.
+func ImportBuilder(data []byte, minPartHours int) (b *RingBuilder, ring bool, err error) {
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, false, ErrBuilderFormat
+		}
+		data, err = ioutil.ReadAll(io.LimitReader(gz, maxImportSize+1))
+		if err != nil || len(data) > maxImportSize {
+			return nil, false, ErrBuilderFormat
+		}
+	}
+
+	if bytes.HasPrefix(data, []byte(ringMagic)) {
+		r, err := Deserialize(bytes.NewReader(data))
+		if err != nil {
+			return nil, true, err
+		}
+		b, err = NewRingBuilderFromRing(r, minPartHours)
+		return b, true, err
+	}
+
+	b, err = LoadSwiftBuilder(bytes.NewReader(data))
+	if err == ErrPickle {
+		err = ErrBuilderFormat
+	}
+	return b, false, err
+}
+
+func newPickleDevice(d pickleDict) *Device {
+	return &Device{
+		Id:              pickleInt(d["id"]),
+		Region:          pickleInt(d["region"]),
+		Zone:            pickleInt(d["zone"]),
+		Ip:              pickleString(d["ip"]),
+		Port:            pickleInt(d["port"]),
+		ReplicationIp:   pickleString(d["replication_ip"]),
+		ReplicationPort: pickleInt(d["replication_port"]),
+		Device:          pickleString(d["device"]),
+		Weight:          pickleFloat(d["weight"]),
+		Meta:            pickleString(d["meta"]),
+	}
+}
+
+func pickleInt(v interface{}) int {
+	switch n := v.(type) {
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	case bool:
+		if n {
+			return 1
+		}
+	}
+	return 0
+}
+
+func pickleFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
+
+func pickleString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	}
+	return ""
+}
+
+func pickleBool(v interface{}) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case int64:
+		return b != 0
+	}
+	return false
+}
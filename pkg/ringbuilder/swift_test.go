@@ -0,0 +1,242 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringbuilder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// object.builder and protocol0.builder are the builder of object.ring.gz
+// pickled by make_fixtures.py the way swift-ring-builder saves them
+
+func TestLoadSwiftBuilder(t *testing.T) {
+	for _, name := range []string{"testdata/object.builder", "testdata/protocol0.builder"} {
+		data, err := ioutil.ReadFile(name)
+		assert.Nil(t, err)
+
+		b, err := LoadSwiftBuilder(bytes.NewReader(data))
+		assert.Nil(t, err, name)
+		assert.Equal(t, "f1x7ure", b.Id)
+		assert.Equal(t, 4, b.PartPower)
+		assert.Equal(t, 3.0, b.Replicas)
+		assert.Equal(t, 1, b.MinPartHours)
+		assert.Equal(t, 16, b.Parts)
+		assert.Equal(t, 3, b.Version)
+		assert.Equal(t, int64(1500000000), b.LastPartMovesEpoch)
+		assert.Equal(t, uint8(2), b.LastPartMoves[15])
+		assert.Equal(t, 4, len(b.Devs))
+		assert.Nil(t, b.Devs[2])
+		assert.Equal(t, &Device{
+			Id:              3,
+			Region:          1,
+			Zone:            3,
+			Ip:              "10.0.0.3",
+			Port:            6200,
+			ReplicationIp:   "10.1.0.3",
+			ReplicationPort: 6400,
+			Device:          "sdc",
+			Weight:          100,
+			Meta:            "fixture",
+			Parts:           16,
+		}, b.Devs[3])
+
+		// The ring written from the loaded builder is the one swift wrote
+		ring, err := LoadRingData("testdata/object.ring.gz")
+		assert.Nil(t, err)
+		assert.Equal(t, ring, b.GetRing())
+	}
+}
+
+func TestLoadSwiftBuilderInvalid(t *testing.T) {
+	_, err := LoadSwiftBuilder(bytes.NewReader([]byte("not a pickle")))
+	assert.NotNil(t, err)
+
+	// A pickled list is not a builder
+	_, err = LoadSwiftBuilder(bytes.NewReader([]byte("\x80\x02]q\x00.")))
+	assert.Equal(t, ErrBuilderFormat, err)
+
+	// Lengths larger than the file
+	for _, data := range []string{
+		"\x80\x02X\xff\xff\xff\xffabc.",
+		"\x80\x04\x8e\xff\xff\xff\xff\xff\xff\xff\x7fabc.",
+		"\x80\x02\x8b\xff\xff\xff\x7f\x01.",
+	} {
+		_, err = LoadSwiftBuilder(bytes.NewReader([]byte(data)))
+		assert.Equal(t, ErrPickle, err)
+	}
+}
+
+func TestNewRingBuilderFromRing(t *testing.T) {
+	ring, err := LoadRingData("testdata/fractional.ring.gz")
+	assert.Nil(t, err)
+
+	b, err := NewRingBuilderFromRing(ring, 24)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, b.PartPower)
+	assert.Equal(t, 2.5, b.Replicas)
+	assert.Equal(t, 24, b.MinPartHours)
+	assert.Equal(t, 7, b.Version)
+	assert.Equal(t, ring, b.GetRing())
+
+	// Nothing moves when the devices did not change
+	b.Version = 0
+	result, err := b.Rebalance(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, result.ChangedParts)
+}
+
+func TestImportBuilder(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/object.ring.gz")
+	assert.Nil(t, err)
+	b, isRing, err := ImportBuilder(data, 1)
+	assert.Nil(t, err)
+	assert.True(t, isRing)
+	assert.Equal(t, 4, b.PartPower)
+
+	data, err = ioutil.ReadFile("testdata/object.builder")
+	assert.Nil(t, err)
+	b, isRing, err = ImportBuilder(data, 1)
+	assert.Nil(t, err)
+	assert.False(t, isRing)
+	assert.Equal(t, "f1x7ure", b.Id)
+
+	_, _, err = ImportBuilder([]byte("garbage"), 1)
+	assert.Equal(t, ErrBuilderFormat, err)
+}
+
+// writePickle pickles v with protocol 2 opcodes, ints as arrays of
+// unsigned shorts like the tables of swift's builder
+func writePickle(buf *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte('N')
+	case int:
+		buf.WriteByte('J')
+		binary.Write(buf, binary.LittleEndian, int32(v))
+	case float64:
+		buf.WriteByte('G')
+		binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case string:
+		buf.WriteByte('X')
+		binary.Write(buf, binary.LittleEndian, uint32(len(v)))
+		buf.WriteString(v)
+	case []interface{}:
+		buf.WriteString("](")
+		for _, item := range v {
+			writePickle(buf, item)
+		}
+		buf.WriteByte('e')
+	case map[string]interface{}:
+		buf.WriteString("}(")
+		for key, value := range v {
+			writePickle(buf, key)
+			writePickle(buf, value)
+		}
+		buf.WriteByte('u')
+	case []int:
+		buf.WriteString("carray\narray\n(")
+		writePickle(buf, "H")
+		items := make([]interface{}, len(v))
+		for i, n := range v {
+			items[i] = n
+		}
+		writePickle(buf, items)
+		buf.WriteString("tR")
+	}
+}
+
+// swiftBuilderPickle returns a swift builder of part power 2 with one
+// device holding every partition, as changed by update
+func swiftBuilderPickle(update func(d map[string]interface{})) []byte {
+	d := map[string]interface{}{
+		"id":                     "f1x7ure",
+		"part_power":             2,
+		"parts":                  4,
+		"replicas":               1.0,
+		"min_part_hours":         1,
+		"version":                1,
+		"devs":                   []interface{}{map[string]interface{}{"id": 0, "ip": "10.0.0.1", "port": 6200, "device": "sdb", "weight": 100.0}},
+		"_replica2part2dev":      []interface{}{[]int{0, 0, 0, 0}},
+		"_last_part_moves":       []int{0, 0, 0, 0},
+		"_last_part_moves_epoch": 0,
+		"_remove_devs":           []interface{}{},
+	}
+	update(d)
+
+	var buf bytes.Buffer
+	buf.WriteString("\x80\x02")
+	writePickle(&buf, d)
+	buf.WriteByte('.')
+	return buf.Bytes()
+}
+
+func TestImportBuilderInconsistent(t *testing.T) {
+	b, isRing, err := ImportBuilder(swiftBuilderPickle(func(d map[string]interface{}) {}), 1)
+	assert.Nil(t, err)
+	assert.False(t, isRing)
+	assert.Equal(t, 4, b.Parts)
+	assert.Equal(t, 4, b.Devs[0].Parts)
+
+	for _, update := range []func(d map[string]interface{}){
+		// The partitions do not match the part power
+		func(d map[string]interface{}) { d["parts"] = 16 },
+		func(d map[string]interface{}) {
+			d["part_power"] = 4
+			d["parts"] = 16
+		},
+
+		// Tables larger or smaller than the partitions
+		func(d map[string]interface{}) { d["_replica2part2dev"] = []interface{}{[]int{0, 0, 0, 0, 0}} },
+		func(d map[string]interface{}) { d["_last_part_moves"] = []int{0, 0, 0} },
+
+		// Devices not at the index of their id
+		func(d map[string]interface{}) {
+			d["devs"] = []interface{}{nil, map[string]interface{}{"id": 5, "ip": "10.0.0.1"}}
+		},
+		func(d map[string]interface{}) {
+			d["_remove_devs"] = []interface{}{map[string]interface{}{"id": 7}}
+		},
+		func(d map[string]interface{}) {
+			d["_remove_devs"] = []interface{}{map[string]interface{}{"id": -1}}
+		},
+	} {
+		_, _, err = ImportBuilder(swiftBuilderPickle(update), 1)
+		assert.Equal(t, ErrBuilderFormat, err)
+	}
+
+	// A ring whose device is not at the index of its id
+	ring, err := LoadRingData("testdata/object.ring.gz")
+	assert.Nil(t, err)
+	ring.Devs[1].Id = 5
+	var buf bytes.Buffer
+	err = ring.Serialize(&buf)
+	assert.Nil(t, err)
+	_, _, err = ImportBuilder(buf.Bytes(), 1)
+	assert.Equal(t, ErrRingFormat, err)
+
+	// Or whose tables are larger than its partitions
+	ring, err = LoadRingData("testdata/object.ring.gz")
+	assert.Nil(t, err)
+	ring.Replica2Part2Dev[0] = append(ring.Replica2Part2Dev[0], 0)
+	_, err = NewRingBuilderFromRing(ring, 1)
+	assert.Equal(t, ErrBuilderFormat, err)
+}
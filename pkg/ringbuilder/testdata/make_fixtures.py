@@ -0,0 +1,80 @@
+#!/usr/bin/env python3
+#
+# Writes the ring fixtures the same way swift.common.ring.RingData.save
+# does, so the Go reader and writer can be checked without installing
+# swift.  Run from this directory.
+#
+import array
+import gzip
+import json
+import pickle
+import struct
+import sys
+
+
+def save(filename, devs, replica2part2dev_id, part_shift, version):
+    with open(filename, 'wb') as f:
+        gz_file = gzip.GzipFile(filename, mode='wb', fileobj=f,
+                                mtime=1300507380.0)
+        gz_file.write(b'R1NG')
+        gz_file.write(struct.pack('!H', 1))
+        text = {'devs': devs, 'part_shift': part_shift,
+                'replica_count': len(replica2part2dev_id),
+                'byteorder': sys.byteorder, 'version': version}
+        json_text = json.JSONEncoder(sort_keys=True).encode(text)
+        json_text = json_text.encode('ascii')
+        gz_file.write(struct.pack('!I', len(json_text)))
+        gz_file.write(json_text)
+        for part2dev_id in replica2part2dev_id:
+            gz_file.write(part2dev_id.tobytes())
+        gz_file.close()
+
+
+def save_builder(filename, builder, protocol=2):
+    # swift.common.ring.builder.RingBuilder.save
+    with open(filename, 'wb') as f:
+        pickle.dump(builder, f, protocol=protocol)
+
+
+def dev(id, zone, device):
+    return {'id': id, 'region': 1, 'zone': zone, 'ip': '10.0.0.%d' % zone,
+            'port': 6200, 'replication_ip': '10.1.0.%d' % zone,
+            'replication_port': 6400, 'device': device, 'weight': 100.0,
+            'meta': 'fixture'}
+
+
+devs = [dev(0, 1, 'sdb'), dev(1, 2, 'sdb'), None, dev(3, 3, 'sdc')]
+
+# part power 4 with 3 replicas
+save('object.ring.gz', devs, [
+    array.array('H', [0, 1, 3, 0, 1, 3, 0, 1, 3, 0, 1, 3, 0, 1, 3, 0]),
+    array.array('H', [1, 3, 0, 1, 3, 0, 1, 3, 0, 1, 3, 0, 1, 3, 0, 1]),
+    array.array('H', [3, 0, 1, 3, 0, 1, 3, 0, 1, 3, 0, 1, 3, 0, 1, 3]),
+], 28, 3)
+
+# part power 4 with 2.5 replicas
+save('fractional.ring.gz', devs, [
+    array.array('H', [0, 1, 3, 0, 1, 3, 0, 1, 3, 0, 1, 3, 0, 1, 3, 0]),
+    array.array('H', [1, 3, 0, 1, 3, 0, 1, 3, 0, 1, 3, 0, 1, 3, 0, 1]),
+    array.array('H', [3, 0, 1, 3, 0, 1, 3, 0]),
+], 28, 7)
+
+# The builder of object.ring.gz, as RingBuilder.to_dict returns it
+builder_devs = [dict(d, parts=16, parts_wanted=0) if d else None
+                for d in devs]
+builder = {
+    'part_power': 4, 'next_part_power': None, 'replicas': 3.0,
+    'min_part_hours': 1, 'parts': 16, 'devs': builder_devs,
+    'devs_changed': False, 'version': 3, 'overload': 0.0,
+    '_replica2part2dev': [
+        array.array('H', [0, 1, 3, 0, 1, 3, 0, 1, 3, 0, 1, 3, 0, 1, 3, 0]),
+        array.array('H', [1, 3, 0, 1, 3, 0, 1, 3, 0, 1, 3, 0, 1, 3, 0, 1]),
+        array.array('H', [3, 0, 1, 3, 0, 1, 3, 0, 1, 3, 0, 1, 3, 0, 1, 3]),
+    ],
+    '_last_part_moves_epoch': 1500000000,
+    '_last_part_moves': array.array('B', [0xff] * 15 + [2]),
+    '_last_part_gather_start': 0, '_dispersion_graph': {},
+    'dispersion': 0.0, '_remove_devs': [], 'id': 'f1x7ure',
+}
+save_builder('object.builder', builder)
+save_builder('protocol0.builder', builder, protocol=0)
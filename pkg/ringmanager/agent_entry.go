@@ -0,0 +1,120 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"bytes"
+	"encoding/gob"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/lpabon/godbc"
+)
+
+// AgentEntry is the last report of the ring distribution agent of a
+// storage node.  Agents are known by the address of their node.
+type AgentEntry struct {
+	Info AgentInfo
+}
+
+func NewAgentEntry() *AgentEntry {
+	entry := &AgentEntry{}
+	entry.Info.Rings = make([]*AgentRingStatus, 0)
+
+	return entry
+}
+
+func NewAgentEntryFromId(tx *bolt.Tx, clusterId, node string) (*AgentEntry, error) {
+	godbc.Require(tx != nil)
+
+	entry := NewAgentEntry()
+	err := EntryLoad(tx, entry, agentKey(clusterId, node))
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// AgentEntryList returns the agents of the cluster
+func AgentEntryList(tx *bolt.Tx, clusterId string) ([]*AgentEntry, error) {
+	godbc.Require(tx != nil)
+
+	keys := EntryKeys(tx, BOLTDB_BUCKET_AGENT)
+	if keys == nil {
+		return nil, ErrAccessList
+	}
+
+	agents := make([]*AgentEntry, 0)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, clusterId+"/") {
+			continue
+		}
+		entry := NewAgentEntry()
+		err := EntryLoad(tx, entry, key)
+		if err != nil {
+			return nil, err
+		}
+		agents = append(agents, entry)
+	}
+
+	return agents, nil
+}
+
+func agentKey(clusterId, node string) string {
+	return clusterId + "/" + node
+}
+
+func (a *AgentEntry) BucketName() string {
+	return BOLTDB_BUCKET_AGENT
+}
+
+func (a *AgentEntry) Save(tx *bolt.Tx) error {
+	godbc.Require(tx != nil)
+	godbc.Require(len(a.Info.ClusterId) > 0)
+	godbc.Require(len(a.Info.Node) > 0)
+
+	return EntrySave(tx, a, agentKey(a.Info.ClusterId, a.Info.Node))
+}
+
+func (a *AgentEntry) Delete(tx *bolt.Tx) error {
+	godbc.Require(tx != nil)
+
+	return EntryDelete(tx, a, agentKey(a.Info.ClusterId, a.Info.Node))
+}
+
+func (a *AgentEntry) Marshal() ([]byte, error) {
+	var buffer bytes.Buffer
+	enc := gob.NewEncoder(&buffer)
+	err := enc.Encode(*a)
+
+	return buffer.Bytes(), err
+}
+
+func (a *AgentEntry) Unmarshal(buffer []byte) error {
+	dec := gob.NewDecoder(bytes.NewReader(buffer))
+	err := dec.Decode(a)
+	if err != nil {
+		return err
+	}
+
+	// Make sure to setup arrays if nil
+	if a.Info.Rings == nil {
+		a.Info.Rings = make([]*AgentRingStatus, 0)
+	}
+
+	return nil
+}
@@ -0,0 +1,585 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lpabon/godbc"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringbuilder"
+)
+
+// Only one build runs at a time
+var buildLock sync.Mutex
+
+// ringTopology is a ring with all its nodes and devices as saved in the db
+type ringTopology struct {
+	Ring    *RingEntry
+	Devices []*ringTopologyDevice
+}
+
+type ringTopologyDevice struct {
+	Node   *NodeEntry
+	Device *DeviceEntry
+
+	// The builder id or the current weight of the device changed
+	// during the build
+	changed bool
+}
+
+func newRingTopology(tx *bolt.Tx, id string) (*ringTopology, error) {
+	godbc.Require(tx != nil)
+
+	ring, err := NewRingEntryFromId(tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	topology := &ringTopology{
+		Ring:    ring,
+		Devices: make([]*ringTopologyDevice, 0),
+	}
+	for _, nodeId := range ring.Nodes {
+		node, err := NewNodeEntryFromId(tx, nodeId)
+		if err != nil {
+			return nil, err
+		}
+		for _, deviceId := range node.Devices {
+			device, err := NewDeviceEntryFromId(tx, deviceId)
+			if err != nil {
+				return nil, err
+			}
+			topology.Devices = append(topology.Devices,
+				&ringTopologyDevice{Node: node, Device: device})
+		}
+	}
+
+	return topology, nil
+}
+
+// failureDomains returns the number of servers and of devices with weight
+// of the ring.  Draining nodes are already gone.
+func (t *ringTopology) failureDomains() (int, int) {
+	servers := make(map[string]bool)
+	devices := 0
+	for _, td := range t.Devices {
+		if td.Node.Draining || td.Device.Info.Weight.Target == 0 {
+			continue
+		}
+		servers[td.Node.Info.Ip] = true
+		devices++
+	}
+	return len(servers), devices
+}
+
+func clusterTopology(id string) ([]*ringTopology, error) {
+	topologies := make([]*ringTopology, 0)
+	err := db.View(func(tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, id)
+		if err != nil {
+			return err
+		}
+
+		for _, ringId := range cluster.Info.Rings {
+			topology, err := newRingTopology(tx, ringId)
+			if err != nil {
+				return err
+			}
+			topologies = append(topologies, topology)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return topologies, nil
+}
+
+// buildCluster brings the builder of every ring of the cluster up to
+// date with the topology in the db, rebalances it and keeps the result as
+// a new version of the ring.  Progress is written to the output of the
+// job.  The rings are built in a scratch copy of their files, which only
+// replace the current ones once every ring is built and the db updated,
+// so that a failed build leaves both as they were.
+func buildCluster(id, author string, job *buildJob) (*BuildRingResponse, error) {
+	job.Printf("Building the rings of cluster %v", id)
+	buildLock.Lock()
+	defer buildLock.Unlock()
+
+	topologies, err := clusterTopology(id)
+	if err != nil {
+		return nil, err
+	}
+
+	clusterPath := filepath.Join(ringManagerDir, id)
+	err = os.MkdirAll(clusterPath, 0774)
+	if err != nil {
+		return nil, err
+	}
+
+	// On the same file system, for the files to be renamed in place
+	scratch, err := ioutil.TempDir(clusterPath, ".build-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(scratch)
+
+	for _, topology := range topologies {
+		err = copyRingFiles(topology.Ring, scratch)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	response := &BuildRingResponse{}
+	response.Rings, err = buildRings(scratch, topologies, job)
+	if err != nil {
+		return nil, err
+	}
+
+	ringParts := make([]map[int]int, len(topologies))
+	for i, topology := range topologies {
+		ringParts[i], err = ringFilePartsByDevice(filepath.Join(scratch, topology.Ring.Info.Name+".ring.gz"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Keep a copy of the files of every ring that changed
+	versions := make([]*RingVersionEntry, 0, len(topologies))
+	for i, topology := range topologies {
+		if response.Rings[i].Unchanged {
+			response.Rings[i].Version = topology.Ring.Version
+			job.Printf("Ring %v: unchanged, still version %v", topology.Ring.Info.Name,
+				topology.Ring.Version)
+			continue
+		}
+
+		version, err := newRingVersion(scratch, topology, response.Rings[i], author)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+		job.Printf("Ring %v: saved version %v", topology.Ring.Info.Name, version.Info.Version)
+	}
+	expired := make(map[*RingEntry][]int)
+
+	// Remember the id given to the devices by the builders and their
+	// weight in the ring
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, topology := range topologies {
+			for _, td := range topology.Devices {
+				if !td.changed {
+					continue
+				}
+
+				device, err := NewDeviceEntryFromId(tx, td.Device.Info.Id)
+				if err == ErrNotFound {
+					continue
+				} else if err != nil {
+					return err
+				}
+				device.BuilderId = td.Device.BuilderId
+				device.InBuilder = td.Device.InBuilder
+				device.Info.Weight.Current = td.Device.Info.Weight.Current
+				device.WeightChangedAt = td.Device.WeightChangedAt
+				err = device.Save(tx)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, version := range versions {
+			ring, err := NewRingEntryFromId(tx, version.Info.RingId)
+			if err != nil {
+				return err
+			}
+			ring.Version = version.Info.Version
+			ring.LastVersion = version.Info.Version
+			err = ring.Save(tx)
+			if err != nil {
+				return err
+			}
+
+			err = version.Save(tx)
+			if err != nil {
+				return err
+			}
+
+			expired[ring], err = expireRingVersions(tx, ring)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Devices of drained nodes go away once they are empty
+		for i, topology := range topologies {
+			for _, td := range topology.Devices {
+				if !td.Node.Draining || !td.Device.IsDrained(ringParts[i]) {
+					continue
+				}
+
+				node, err := NewNodeEntryFromId(tx, td.Node.Info.Id)
+				if err != nil {
+					return err
+				}
+				device, err := NewDeviceEntryFromId(tx, td.Device.Info.Id)
+				if err == ErrNotFound {
+					continue
+				} else if err != nil {
+					return err
+				}
+				err = deviceDelete(tx, node, device, ringParts[i], false)
+				if err != nil {
+					return err
+				}
+				err = node.Save(tx)
+				if err != nil {
+					return err
+				}
+				response.Rings[i].DevicesDrained = append(response.Rings[i].DevicesDrained,
+					device.Info.Id)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The builders now match the db
+	for i, topology := range topologies {
+		if response.Rings[i].Unchanged {
+			continue
+		}
+		err = moveRingFiles(topology.Ring, scratch)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for ring, expiredVersions := range expired {
+		for _, version := range expiredVersions {
+			err = os.RemoveAll(ringVersionPath(ring, version))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return response, nil
+}
+
+// buildRings builds or composes the file of every ring of the cluster in
+// clusterPath, in the order of the topologies
+func buildRings(clusterPath string, topologies []*ringTopology, job *buildJob) ([]*RingBuildResult, error) {
+	results := make([]*RingBuildResult, len(topologies))
+
+	// Composite rings are composed once their components are built
+	for _, composite := range []bool{false, true} {
+		for i, topology := range topologies {
+			if topology.Ring.IsComposite() != composite {
+				continue
+			}
+
+			var result *RingBuildResult
+			var err error
+			if composite {
+				job.Printf("Composing ring %v of %v component rings", topology.Ring.Info.Name,
+					len(topology.Ring.Info.Components))
+				result, err = composeRing(clusterPath, topology, topologies, results)
+			} else {
+				job.Printf("Building ring %v with %v devices", topology.Ring.Info.Name, len(topology.Devices))
+				result, err = buildRing(clusterPath, topology)
+			}
+			if err != nil {
+				err = fmt.Errorf("Unable to build ring %v: %v", topology.Ring.Info.Name, err)
+				job.Printf("%v", err)
+				return nil, err
+			}
+			if !composite {
+				job.Printf("Ring %v: %v devices added, %v removed, %v reweighted, %v updated",
+					result.Name, len(result.DevicesAdded), len(result.DevicesRemoved),
+					len(result.DevicesReweighted), len(result.DevicesUpdated))
+			}
+			job.Printf("Ring %v: reassigned %v partitions, balance is now %.2f",
+				result.Name, result.ChangedParts, result.Balance)
+			results[i] = result
+		}
+	}
+
+	return results, nil
+}
+
+// newRingVersion saves the files of the ring just built in dir as its
+// next version and snapshots the topology they were built from
+func newRingVersion(dir string, topology *ringTopology, result *RingBuildResult, author string) (*RingVersionEntry, error) {
+	version := NewRingVersionEntry()
+	version.Info.RingId = topology.Ring.Info.Id
+	version.Info.Version = topology.Ring.LastVersion + 1
+	version.Info.Created = time.Now().UTC()
+	version.Info.Author = author
+	version.Info.ChangedParts = result.ChangedParts
+	version.Info.Balance = result.Balance
+
+	for _, td := range topology.Devices {
+		version.Info.Devices = append(version.Info.Devices, &RingVersionDevice{
+			DeviceId:        td.Device.Info.Id,
+			NodeId:          td.Node.Info.Id,
+			BuilderId:       td.Device.BuilderId,
+			Region:          td.Node.Info.Region,
+			Zone:            td.Node.Info.Zone,
+			Ip:              td.Node.Info.Ip,
+			Port:            td.Node.Info.Port,
+			ReplicationIP:   td.Node.Info.ReplicationIP,
+			ReplicationPort: td.Node.Info.ReplicationPort,
+			Name:            td.Device.Info.Name,
+			Meta:            td.Device.Info.Meta,
+			Weight:          td.Device.Info.Weight.Current,
+		})
+	}
+
+	var err error
+	version.Info.RingHash, err = saveRingVersion(topology.Ring, version.Info.Version, dir)
+	if err != nil {
+		return nil, err
+	}
+	result.Version = version.Info.Version
+
+	return version, nil
+}
+
+func buildRing(clusterPath string, topology *ringTopology) (*RingBuildResult, error) {
+	info := &topology.Ring.Info
+	path := filepath.Join(clusterPath, info.Name+".builder")
+
+	result := &RingBuildResult{
+		Id:                info.Id,
+		Name:              info.Name,
+		DevicesAdded:      make([]string, 0),
+		DevicesRemoved:    make([]string, 0),
+		DevicesReweighted: make([]string, 0),
+		DevicesUpdated:    make([]string, 0),
+		DevicesDrained:    make([]string, 0),
+	}
+
+	_, policy := ringPolicy(&info.RingAddRequest)
+	nodes, devices := topology.failureDomains()
+	err := checkFailureDomains(info.Name, policy, nodes, devices)
+	if err != nil {
+		return nil, err
+	}
+
+	// Two replicas of a partition must not share a device
+	if replicas := int(math.Ceil(info.Replicas)); devices < replicas {
+		return nil, fmt.Errorf("Ring %v has %v devices with weight, it needs one for each of its %v replicas",
+			info.Name, devices, replicas)
+	}
+
+	var builder RingBuilder
+	if _, err = os.Stat(path); os.IsNotExist(err) {
+		builder, err = builderBackend.Create(path, info.PartPower, info.Replicas, info.MinPartHours)
+		result.Created = true
+
+		// Ids given by a previous builder are meaningless now
+		for _, td := range topology.Devices {
+			if td.Device.InBuilder {
+				td.Device.InBuilder = false
+				td.changed = true
+			}
+		}
+	} else {
+		builder, err = builderBackend.Open(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = syncBuilder(builder, topology, result, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	rebalance, err := builder.Rebalance()
+	if err != nil {
+		return nil, err
+	}
+	result.ChangedParts = rebalance.ChangedParts
+	result.Balance = rebalance.Balance
+
+	// The ring keeps its version and checksum when nothing changed, so
+	// that the nodes do not download it again
+	if !result.Created && result.ChangedParts == 0 && len(result.DevicesAdded) == 0 &&
+		len(result.DevicesRemoved) == 0 && len(result.DevicesReweighted) == 0 &&
+		len(result.DevicesUpdated) == 0 {
+		result.Unchanged = true
+		return result, nil
+	}
+
+	err = builder.Save()
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// syncBuilder adds, removes and updates the devices in the builder to
+// match the devices of the ring topology.  Weights move one step toward
+// their target, except in a new builder, and at most once every
+// min_part_hours so that the partitions can follow.
+func syncBuilder(builder RingBuilder, topology *ringTopology, result *RingBuildResult, now time.Time) error {
+	devs, err := builder.Devices()
+	if err != nil {
+		return err
+	}
+	current := make(map[int]*ringbuilder.Device)
+	for _, d := range devs {
+		current[d.Id] = d
+	}
+
+	// Find the devices already in the builder
+	claimed := make(map[int]bool)
+	present := make([]*ringTopologyDevice, 0)
+	missing := make([]*ringTopologyDevice, 0)
+	for _, td := range topology.Devices {
+		id := td.Device.BuilderId
+		if _, ok := current[id]; td.Device.InBuilder && ok && !claimed[id] {
+			claimed[id] = true
+			present = append(present, td)
+		} else {
+			missing = append(missing, td)
+		}
+	}
+
+	// Remove first so that a device can be replaced by one with the
+	// same address
+	for _, d := range devs {
+		if claimed[d.Id] {
+			continue
+		}
+		err := builder.RemoveDevice(d.Id)
+		if err != nil {
+			return err
+		}
+		result.DevicesRemoved = append(result.DevicesRemoved, d.String())
+	}
+
+	for _, td := range present {
+		// The builder knows the weight the partitions are placed with
+		have := current[td.Device.BuilderId]
+		stepDeviceWeight(td, uint64(math.Floor(have.Weight+0.5)), topology.Ring, result.Created, now)
+
+		want, err := newBuilderDevice(td.Node, td.Device)
+		if err != nil {
+			return err
+		}
+
+		if have.Weight != want.Weight {
+			err := builder.SetDeviceWeight(have.Id, want.Weight)
+			if err != nil {
+				return err
+			}
+			result.DevicesReweighted = append(result.DevicesReweighted, td.Device.Info.Id)
+		}
+		if builderDeviceInfoDiffers(have, want) {
+			err := builder.SetDeviceInfo(have.Id, want)
+			if err != nil {
+				return err
+			}
+			result.DevicesUpdated = append(result.DevicesUpdated, td.Device.Info.Id)
+		}
+	}
+
+	for _, td := range missing {
+		stepDeviceWeight(td, td.Device.Info.Weight.Current, topology.Ring, result.Created, now)
+
+		want, err := newBuilderDevice(td.Node, td.Device)
+		if err != nil {
+			return err
+		}
+		id, err := builder.AddDevice(want)
+		if err != nil {
+			return err
+		}
+		td.Device.BuilderId = id
+		td.Device.InBuilder = true
+		td.changed = true
+		result.DevicesAdded = append(result.DevicesAdded, td.Device.Info.Id)
+	}
+
+	return nil
+}
+
+// stepDeviceWeight moves the current weight of the device one step from
+// weight toward its target, or straight to it if the builder is new
+func stepDeviceWeight(td *ringTopologyDevice, weight uint64, ring *RingEntry, created bool, now time.Time) {
+	next := td.Device.Info.Weight.Target
+	if !created {
+		next = deviceWeightStep.next(weight, next)
+
+		// The partitions moved by the last step may still be waiting
+		// for min_part_hours
+		last := time.Unix(td.Device.WeightChangedAt, 0)
+		wait := time.Duration(ring.Info.MinPartHours) * time.Hour
+		if next != weight && now.Before(last.Add(wait)) {
+			next = weight
+		}
+	}
+	if next != weight {
+		td.Device.WeightChangedAt = now.Unix()
+		td.changed = true
+	}
+
+	if next != td.Device.Info.Weight.Current {
+		td.Device.Info.Weight.Current = next
+		td.changed = true
+	}
+}
+
+func builderDeviceInfoDiffers(a, b *ringbuilder.Device) bool {
+	replicationIp := func(d *ringbuilder.Device) string {
+		if d.ReplicationIp == "" {
+			return d.Ip
+		}
+		return d.ReplicationIp
+	}
+	replicationPort := func(d *ringbuilder.Device) int {
+		if d.ReplicationPort == 0 {
+			return d.Port
+		}
+		return d.ReplicationPort
+	}
+
+	return a.Region != b.Region ||
+		a.Zone != b.Zone ||
+		a.Ip != b.Ip ||
+		a.Port != b.Port ||
+		replicationIp(a) != replicationIp(b) ||
+		replicationPort(a) != replicationPort(b) ||
+		a.Device != b.Device ||
+		a.Meta != b.Meta
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	BUILD_JOB_RUNNING   = "running"
+	BUILD_JOB_COMPLETED = "completed"
+	BUILD_JOB_FAILED    = "failed"
+
+	// Finished jobs are forgotten after a day
+	buildJobExpiration = 24 * time.Hour
+)
+
+// buildJob is a build running in the background
+type buildJob struct {
+	lock     sync.Mutex
+	info     BuildJobResponse
+	finished time.Time
+}
+
+// Printf adds a line to the output of the job.  Output is discarded
+// when there is no job.
+func (j *buildJob) Printf(format string, args ...interface{}) {
+	if j == nil {
+		return
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.info.Output = append(j.info.Output, fmt.Sprintf(format, args...))
+}
+
+func (j *buildJob) finish(result *BuildRingResponse, err error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	j.finished = time.Now()
+	if err != nil {
+		j.info.Status = BUILD_JOB_FAILED
+		j.info.Error = err.Error()
+	} else {
+		j.info.Status = BUILD_JOB_COMPLETED
+		j.info.Result = result
+	}
+}
+
+func (j *buildJob) response() *BuildJobResponse {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	info := j.info
+	info.Output = append([]string{}, j.info.Output...)
+	return &info
+}
+
+type buildJobs struct {
+	lock sync.Mutex
+	jobs map[string]*buildJob
+}
+
+func newBuildJobs() *buildJobs {
+	return &buildJobs{jobs: make(map[string]*buildJob)}
+}
+
+func (b *buildJobs) add(id, clusterId, author string) *buildJob {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	// Forget the old jobs
+	for jobId, job := range b.jobs {
+		job.lock.Lock()
+		expired := !job.finished.IsZero() && time.Since(job.finished) > buildJobExpiration
+		job.lock.Unlock()
+		if expired {
+			delete(b.jobs, jobId)
+		}
+	}
+
+	job := &buildJob{
+		info: BuildJobResponse{
+			Id:        id,
+			ClusterId: clusterId,
+			Author:    author,
+			Status:    BUILD_JOB_RUNNING,
+			Output:    make([]string, 0),
+		},
+	}
+	b.jobs[id] = job
+	return job
+}
+
+func (b *buildJobs) get(id string) (*buildJob, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	job, ok := b.jobs[id]
+	return job, ok
+}
+
+// startBuildJob runs the build of the cluster in the background and
+// answers with the location of the job in the queue
+func startBuildJob(w http.ResponseWriter, r *http.Request, clusterId, author string) {
+	startJob(w, r, clusterId, author, func(job *buildJob) (*BuildRingResponse, error) {
+		return buildCluster(clusterId, author, job)
+	})
+}
+
+// startDryRunJob runs a dry run of the build in the background, like
+// startBuildJob
+func startDryRunJob(w http.ResponseWriter, r *http.Request, clusterId string, partitionSize uint64) {
+	startJob(w, r, clusterId, "", func(job *buildJob) (*BuildRingResponse, error) {
+		return dryRunCluster(clusterId, partitionSize, job)
+	})
+}
+
+func startJob(w http.ResponseWriter, r *http.Request, clusterId, author string,
+	run func(job *buildJob) (*BuildRingResponse, error)) {
+	handler := app.asyncManager.NewHandler()
+	jobId := path.Base(handler.Url())
+	job := app.buildJobs.add(jobId, clusterId, author)
+
+	go func() {
+		result, err := runJob(job, run)
+		job.finish(result, err)
+		if err != nil {
+			handler.CompletedWithError(err)
+		} else {
+			handler.CompletedWithLocation("/buildjobs/" + jobId)
+		}
+	}()
+
+	http.Redirect(w, r, handler.Url(), http.StatusAccepted)
+}
+
+// runJob runs the job, a panic fails the job instead of stopping the
+// server
+func runJob(job *buildJob, run func(job *buildJob) (*BuildRingResponse, error)) (
+	result *BuildRingResponse, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Build job %v panicked: %v\n%s", job.info.Id, r, debug.Stack())
+			result, err = nil, fmt.Errorf("Job %v failed: %v", job.info.Id, r)
+		}
+	}()
+
+	return run(job)
+}
+
+// QueueStatus reports if the job is still pending, with its output so
+// far, or redirects to its result once done
+func QueueStatus(w http.ResponseWriter, r *http.Request) {
+	job, _ := app.buildJobs.get(mux.Vars(r)["id"])
+	app.asyncManager.HandlerStatus(&queueStatusWriter{ResponseWriter: w, job: job}, r)
+}
+
+// queueStatusWriter adds the job to the pending answers of the queue
+type queueStatusWriter struct {
+	http.ResponseWriter
+	job *buildJob
+}
+
+func (q *queueStatusWriter) WriteHeader(status int) {
+	if q.job == nil || status != http.StatusOK || q.Header().Get("X-Pending") != "true" {
+		q.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	q.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	q.ResponseWriter.WriteHeader(status)
+	if err := json.NewEncoder(q.ResponseWriter).Encode(q.job.response()); err != nil {
+		panic(err)
+	}
+}
+
+func BuildJobInformation(w http.ResponseWriter, r *http.Request) {
+	// Get the id from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	job, ok := app.buildJobs.get(id)
+	if !ok {
+		http.Error(w, ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	// Write msg
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(job.response()); err != nil {
+		panic(err)
+	}
+}
@@ -17,17 +17,17 @@ package ringmanager
 
 import (
 	"encoding/json"
-	"fmt"
 	"io"
+	"log"
 	"net/http"
-	"os/exec"
 
 	"os"
+	"sort"
+	"strconv"
+	"time"
 
 	"path/filepath"
 
-	"log"
-
 	"github.com/boltdb/bolt"
 	"github.com/gorilla/mux"
 )
@@ -142,7 +142,23 @@ func ClusterDelete(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
+	// cascade deletes the rings, nodes and devices of the cluster too,
+	// dry_run only reports what would be deleted
+	cascade := GetBoolFromQuery(r, "cascade")
+	dryRun := GetBoolFromQuery(r, "dry_run")
+
+	// Do not remove the files while a build is writing them
+	buildLock.Lock()
+	defer buildLock.Unlock()
+
 	// Delete cluster from db
+	response := &ClusterDeleteResponse{
+		Id:      id,
+		DryRun:  dryRun,
+		Rings:   make([]string, 0),
+		Nodes:   make([]string, 0),
+		Devices: make([]string, 0),
+	}
 	err := db.Update(func(tx *bolt.Tx) error {
 
 		// Access cluster entry
@@ -156,6 +172,14 @@ func ClusterDelete(w http.ResponseWriter, r *http.Request) {
 			return err
 		}
 
+		if cascade {
+			err = clusterDeleteRings(tx, entry, response)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return err
+			}
+		}
+
 		err = entry.Delete(tx)
 		if err != nil {
 			if err == ErrConflict {
@@ -166,9 +190,24 @@ func ClusterDelete(w http.ResponseWriter, r *http.Request) {
 			return err
 		}
 
+		// Roll everything back
+		if dryRun {
+			return ErrDryRun
+		}
+
+		// Last, so that nothing is deleted if the files can not be
+		// removed
+		if cascade {
+			err = os.RemoveAll(filepath.Join(ringManagerDir, id))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return err
+			}
+		}
+
 		return nil
 	})
-	if err != nil {
+	if err != nil && err != ErrDryRun {
 		return
 	}
 
@@ -179,7 +218,57 @@ func ClusterDelete(w http.ResponseWriter, r *http.Request) {
 	//logger.Info("Deleted cluster [%s]", id)
 
 	// Write msg
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		panic(err)
+	}
+}
+
+// clusterDeleteRings deletes every ring of the cluster with its nodes and
+// devices, and lists them in the response
+func clusterDeleteRings(tx *bolt.Tx, cluster *ClusterEntry, response *ClusterDeleteResponse) error {
+	for _, ringId := range append(sort.StringSlice{}, cluster.Info.Rings...) {
+		ring, err := NewRingEntryFromId(tx, ringId)
+		if err != nil {
+			return err
+		}
+
+		for _, nodeId := range append(sort.StringSlice{}, ring.Nodes...) {
+			node, err := NewNodeEntryFromId(tx, nodeId)
+			if err != nil {
+				return err
+			}
+
+			for _, deviceId := range append(sort.StringSlice{}, node.Devices...) {
+				device, err := NewDeviceEntryFromId(tx, deviceId)
+				if err != nil {
+					return err
+				}
+				err = deviceDelete(tx, node, device, nil, true)
+				if err != nil {
+					return err
+				}
+				response.Devices = append(response.Devices, deviceId)
+			}
+
+			err = node.Delete(tx)
+			if err != nil {
+				return err
+			}
+			ring.NodeDelete(nodeId)
+			response.Nodes = append(response.Nodes, nodeId)
+		}
+
+		err = ring.Delete(tx)
+		if err != nil {
+			return err
+		}
+		cluster.RingDelete(ringId)
+		response.Rings = append(response.Rings, ringId)
+	}
+
+	return nil
 }
 
 func BuildRing(w http.ResponseWriter, r *http.Request) {
@@ -187,9 +276,7 @@ func BuildRing(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	// Get info from db
-	// TODO: should build one struct with all topology info
-	clusterInfo, err := getClusterInfo(id)
+	_, err := getClusterInfo(id)
 	if err == ErrNotFound {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
@@ -198,66 +285,44 @@ func BuildRing(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	clusterPath := filepath.Join(ringManagerDir, clusterInfo.Id)
-	os.Mkdir(clusterPath, 0774)
-
-	for _, ringId := range clusterInfo.Rings {
-		ringInfo, err := getRingInfo(ringId)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		ringbuilderName := ringInfo.Name + ".builder"
-		ringBuilderPath := filepath.Join(clusterPath, ringbuilderName)
-		if _, err := os.Stat(ringBuilderPath); os.IsNotExist(err) {
-			// create builder
-			cmdRingBuilder := "/usr/bin/swift-ring-builder"
-			cmdArgs := []string{ringBuilderPath, "create", "10", "3", "1"}
-			out, err := exec.Command(cmdRingBuilder, cmdArgs...).CombinedOutput()
-			if err != nil {
-				output := fmt.Sprintf("%s", out)
-				http.Error(w, output, http.StatusInternalServerError)
+	// dry_run rebalances a copy of the rings and estimates the data
+	// moved with the average partition_size, in bytes
+	if GetBoolFromQuery(r, "dry_run") {
+		size := partitionSize
+		if value := r.URL.Query().Get("partition_size"); value != "" {
+			size, err = strconv.ParseUint(value, 10, 64)
+			if err != nil || size == 0 {
+				http.Error(w, "Invalid partition size "+value, http.StatusBadRequest)
 				return
 			}
+		}
+		startDryRunJob(w, r, id, size)
+		return
+	}
 
-			// Add Nodes/Devices to ring
-			for _, nodeId := range ringInfo.Nodes {
-				n, err := getNodeInfo(nodeId)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-				for _, deviceId := range n.Devices {
-					d, err := getDeviceInfo(deviceId)
-					if err != nil {
-						http.Error(w, err.Error(), http.StatusInternalServerError)
-						return
-					}
-					//swift-ring-builder object.builder add r1z1-127.0.0.1:6010/sdb1 1
-					deviceArg := fmt.Sprintf("r%dz%d-%s:%s/%s", n.Region, n.Zone, n.Ip, n.Port, d.Name)
-					weightArg := fmt.Sprintf("%d", d.Weight.Target)
-					cmdArgs = []string{ringBuilderPath, "add", deviceArg, weightArg}
-					out, err = exec.Command(cmdRingBuilder, cmdArgs...).CombinedOutput()
-					if err != nil {
-						log.Printf("output %s", out)
-						output := fmt.Sprintf("%s", out)
-						http.Error(w, output, http.StatusInternalServerError)
-						return
-					}
-				}
-			}
+	// Bring the builders up to date and rebalance in the background.
+	// The author is kept with the new versions of the rings.
+	startBuildJob(w, r, id, r.URL.Query().Get("author"))
+}
 
-			// rebalance
-			cmdArgs = []string{ringBuilderPath, "rebalance"}
-			out, err = exec.Command(cmdRingBuilder, cmdArgs...).CombinedOutput()
+// getRingByName returns the ring of the cluster with the name, or nil
+// when there is none
+func getRingByName(cluster *ClusterInfoResponse, name string) (*RingEntry, error) {
+	var entry *RingEntry
+	err := db.View(func(tx *bolt.Tx) error {
+		for _, ringId := range cluster.Rings {
+			ring, err := NewRingEntryFromId(tx, ringId)
 			if err != nil {
-				output := fmt.Sprintf("%s", out)
-				http.Error(w, output, http.StatusInternalServerError)
-				return
+				return err
+			}
+			if ring.Info.Name == name {
+				entry = ring
+				return nil
 			}
 		}
-	}
-	w.WriteHeader(http.StatusOK)
+		return nil
+	})
+	return entry, err
 }
 
 func DownloadRing(w http.ResponseWriter, r *http.Request) {
@@ -276,21 +341,65 @@ func DownloadRing(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	clusterPath := filepath.Join(ringManagerDir, clusterInfo.Id)
+	ringEntry, err := getRingByName(clusterInfo, ring)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	version := 0
+	if ringEntry != nil {
+		version = ringEntry.Version
+	}
 	ringName := ring + ".ring.gz"
 	ringPath := filepath.Join(clusterPath, ringName)
 	ringFile, err := os.Open(ringPath)
-	defer ringFile.Close()
-	if err != nil {
+	if os.IsNotExist(err) {
+		http.Error(w, "Ring has not been built", http.StatusNotFound)
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	etag, err := FileHash(ringFile)
+	defer ringFile.Close()
+
+	// Nodes poll for new rings, only hash the file when it changes
+	etag, info, err := CachedFileHash(ringPath, ringFile)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	modified := info.ModTime().UTC().Truncate(time.Second)
 	w.Header().Set("Etag", etag)
+	w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	w.Header().Set("X-Ring-Version", strconv.Itoa(version))
+
+	// Storage nodes say who they are so we know which ring they have.
+	// They install composite rings, not their components.
+	if node := r.URL.Query().Get("node"); node != "" && ringEntry != nil && r.Method == "GET" &&
+		ringEntry.Info.Type != RING_TYPE_COMPONENT {
+		err := recordRingFetch(ringEntry.Info.Id, node, etag)
+		if err != nil {
+			log.Printf("Unable to record ring fetch of node %v: %v", node, err)
+		}
+	}
+
+	// The client already has this ring
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if EtagMatches(match, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil &&
+		!modified.After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
 	w.WriteHeader(http.StatusOK)
-	ringFile.Seek(0, 0)
+	if r.Method == "HEAD" {
+		return
+	}
 	io.Copy(w, ringFile)
 }
@@ -91,6 +91,18 @@ func (c *ClusterEntry) Delete(tx *bolt.Tx) error {
 		return ErrConflict
 	}
 
+	// Forget the agents of the cluster
+	agents, err := AgentEntryList(tx, c.Info.Id)
+	if err != nil {
+		return err
+	}
+	for _, agent := range agents {
+		err = agent.Delete(tx)
+		if err != nil {
+			return err
+		}
+	}
+
 	return EntryDelete(tx, c, c.Info.Id)
 }
 
@@ -16,10 +16,17 @@ limitations under the License.
 package ringmanager
 
 import (
+	"compress/gzip"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http/httptest"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"bytes"
 	"net/http"
@@ -27,6 +34,7 @@ import (
 	"github.com/boltdb/bolt"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringbuilder"
 )
 
 var ts *httptest.Server
@@ -43,7 +51,8 @@ func setupDatabase(t *testing.T) (string, func(t *testing.T)) {
 	v.Set("dbfilename", "swift_clusters.db")
 	v.Set("ringmanager_dir", dir)
 
-	router := NewRouter(v)
+	router, err := NewRouter(v)
+	assert.Nil(t, err)
 	ts = httptest.NewServer(router)
 
 	clusterId := setupCluster(t)
@@ -198,3 +207,502 @@ func TestClusterDeleteRingExists(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, res.StatusCode, http.StatusConflict)
 }
+
+func TestClusterDeleteCascadeDryRun(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	ringId := setupTopology(t, id, "object", 2)
+
+	res := httpDelete(t, ts.URL+"/clusters/"+id+"?cascade=true&dry_run=true")
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+
+	var msg ClusterDeleteResponse
+	err := GetJsonFromResponse(res, &msg)
+	assert.Nil(t, err)
+	assert.True(t, msg.DryRun)
+	assert.Equal(t, []string{ringId}, msg.Rings)
+	assert.Equal(t, 2, len(msg.Nodes))
+	assert.Equal(t, 2, len(msg.Devices))
+
+	// Nothing was deleted
+	r, err := http.Get(ts.URL + "/rings/" + ringId)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+	for _, deviceId := range msg.Devices {
+		r, err = http.Get(ts.URL + "/devices/" + deviceId)
+		assert.Nil(t, err)
+		assert.Equal(t, r.StatusCode, http.StatusOK)
+	}
+
+	// Without cascade the rings are still in the way
+	res = httpDelete(t, ts.URL+"/clusters/"+id+"?dry_run=true")
+	assert.Equal(t, res.StatusCode, http.StatusConflict)
+}
+
+func TestClusterDeleteCascade(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	setupTopology(t, id, "account", 3)
+	setupTopology(t, id, "object", 3)
+
+	runBuild(t, id)
+	_, err := os.Stat(filepath.Join(ringManagerDir, id))
+	assert.Nil(t, err)
+
+	res := httpDelete(t, ts.URL+"/clusters/"+id+"?cascade=true")
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+
+	var msg ClusterDeleteResponse
+	err = GetJsonFromResponse(res, &msg)
+	assert.Nil(t, err)
+	assert.False(t, msg.DryRun)
+	assert.Equal(t, 2, len(msg.Rings))
+	assert.Equal(t, 6, len(msg.Nodes))
+	assert.Equal(t, 6, len(msg.Devices))
+
+	r, err := http.Get(ts.URL + "/clusters/" + id)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusNotFound)
+	for _, nodeId := range msg.Nodes {
+		r, err = http.Get(ts.URL + "/nodes/" + nodeId)
+		assert.Nil(t, err)
+		assert.Equal(t, r.StatusCode, http.StatusNotFound)
+	}
+	for _, deviceId := range msg.Devices {
+		r, err = http.Get(ts.URL + "/devices/" + deviceId)
+		assert.Nil(t, err)
+		assert.Equal(t, r.StatusCode, http.StatusNotFound)
+	}
+
+	_, err = os.Stat(filepath.Join(ringManagerDir, id))
+	assert.True(t, os.IsNotExist(err))
+
+	// The register keys are released
+	id = setupCluster(t)
+	setupTopology(t, id, "object", 2)
+}
+
+func setupRing(t *testing.T, clusterId, name string) string {
+	return setupRingWithParameters(t, clusterId, name, "")
+}
+
+// setupRingWithParameters adds a ring with extra json fields in the request
+func setupRingWithParameters(t *testing.T, clusterId, name, params string) string {
+	if params != "" {
+		params = ", " + params
+	}
+	body := []byte(`{"name":"` + name + `", "cluster":"` + clusterId + `"` + params + `}`)
+	r, err := http.Post(ts.URL+"/rings", "application/json", bytes.NewBuffer(body))
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusCreated)
+
+	var msg RingInfo
+	err = GetJsonFromResponse(r, &msg)
+	assert.Nil(t, err)
+	return msg.Id
+}
+
+func setupNode(t *testing.T, ringId, ip string, zone int) string {
+	body := []byte(fmt.Sprintf(`{"ring":"%v", "ip":"%v", "port":"6010", "zone":%v}`, ringId, ip, zone))
+	r, err := http.Post(ts.URL+"/nodes", "application/json", bytes.NewBuffer(body))
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusCreated)
+
+	var msg NodeInfo
+	err = GetJsonFromResponse(r, &msg)
+	assert.Nil(t, err)
+	return msg.Id
+}
+
+func setupDevice(t *testing.T, nodeId, name string, weight int) string {
+	body := []byte(fmt.Sprintf(`{"node":"%v", "name":"%v", "weight":%v}`, nodeId, name, weight))
+	r, err := http.Post(ts.URL+"/devices", "application/json", bytes.NewBuffer(body))
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusCreated)
+
+	var msg DeviceInfo
+	err = GetJsonFromResponse(r, &msg)
+	assert.Nil(t, err)
+	return msg.Id
+}
+
+func httpDelete(t *testing.T, url string) *http.Response {
+	req, err := http.NewRequest("DELETE", url, nil)
+	assert.Nil(t, err)
+	client := &http.Client{}
+	res, err := client.Do(req)
+	assert.Nil(t, err)
+	return res
+}
+
+func httpPatch(t *testing.T, url, body string) *http.Response {
+	req, err := http.NewRequest("PATCH", url, bytes.NewBufferString(body))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{}
+	res, err := client.Do(req)
+	assert.Nil(t, err)
+	return res
+}
+
+// runBuild builds the rings of the cluster and waits for the result
+func runBuild(t *testing.T, clusterId string) *BuildRingResponse {
+	return runBuildWithQuery(t, clusterId, "")
+}
+
+func runBuildWithQuery(t *testing.T, clusterId, query string) *BuildRingResponse {
+	r, err := http.Post(ts.URL+"/buildring/"+clusterId+query, "application/json", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusAccepted)
+	location, err := r.Location()
+	assert.Nil(t, err)
+
+	// The client follows the redirect to the job once done
+	for {
+		r, err = http.Get(location.String())
+		assert.Nil(t, err)
+		assert.Equal(t, r.StatusCode, http.StatusOK)
+		if r.Header.Get("X-Pending") != "true" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var job BuildJobResponse
+	err = GetJsonFromResponse(r, &job)
+	assert.Nil(t, err)
+	assert.Equal(t, BUILD_JOB_COMPLETED, job.Status, job.Error)
+	return job.Result
+}
+
+// setupTopology adds a ring with one node per zone and one device per node
+func setupTopology(t *testing.T, clusterId, name string, zones int) string {
+	ringId := setupRing(t, clusterId, name)
+	for z := 1; z <= zones; z++ {
+		nodeId := setupNode(t, ringId, fmt.Sprintf("127.0.0.%d", z), z)
+		setupDevice(t, nodeId, "sdb1", 100)
+	}
+	return ringId
+}
+
+func TestBuildRing(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	setupTopology(t, id, "object", 3)
+
+	runBuild(t, id)
+
+	builder, err := ringbuilder.Load(filepath.Join(ringManagerDir, id, "object.builder"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(builder.Devs))
+	assert.Equal(t, 1, builder.Version)
+
+	ring, err := ringbuilder.LoadRingData(filepath.Join(ringManagerDir, id, "object.ring.gz"))
+	assert.Nil(t, err)
+	assert.Equal(t, builder.GetRing(), ring)
+
+	r, err := http.Get(ts.URL + "/downloadring/" + id + "/object")
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+	assert.NotEmpty(t, r.Header.Get("Etag"))
+}
+
+func TestBuildRingIdNotFound(t *testing.T) {
+
+	// setup and teardown test case
+	_, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	r, err := http.Post(ts.URL+"/buildring/12345", "application/json", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusNotFound)
+}
+
+func TestBuildRingJob(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	setupTopology(t, id, "object", 3)
+
+	// The build waits for the one in progress
+	buildLock.Lock()
+	r, err := http.Post(ts.URL+"/buildring/"+id, "application/json", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusAccepted)
+	location, err := r.Location()
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(location.Path, ASYNC_ROUTE+"/"))
+	jobId := path.Base(location.Path)
+
+	// Wait without following the redirect
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	// The queue shows the output of the pending job
+	var pending BuildJobResponse
+	for len(pending.Output) == 0 {
+		r, err = client.Get(location.String())
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, r.StatusCode)
+		assert.Equal(t, "true", r.Header.Get("X-Pending"))
+		err = GetJsonFromResponse(r, &pending)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, jobId, pending.Id)
+	assert.Equal(t, BUILD_JOB_RUNNING, pending.Status)
+	assert.Equal(t, []string{"Building the rings of cluster " + id}, pending.Output)
+	assert.Nil(t, pending.Result)
+	buildLock.Unlock()
+
+	for {
+		r, err = client.Get(location.String())
+		assert.Nil(t, err)
+		if r.StatusCode != http.StatusOK {
+			break
+		}
+		assert.Equal(t, "true", r.Header.Get("X-Pending"))
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, r.StatusCode, http.StatusSeeOther)
+	assert.Equal(t, "/buildjobs/"+jobId, r.Header.Get("Location"))
+
+	r, err = http.Get(ts.URL + "/buildjobs/" + jobId)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+
+	var job BuildJobResponse
+	err = GetJsonFromResponse(r, &job)
+	assert.Nil(t, err)
+	assert.Equal(t, jobId, job.Id)
+	assert.Equal(t, id, job.ClusterId)
+	assert.Equal(t, BUILD_JOB_COMPLETED, job.Status)
+	assert.NotEmpty(t, job.Output)
+	assert.Equal(t, 1, len(job.Result.Rings))
+	assert.Equal(t, 3, len(job.Result.Rings[0].DevicesAdded))
+}
+
+func TestBuildRingJobFailed(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	// Nothing to put in the ring
+	setupRing(t, id, "object")
+
+	r, err := http.Post(ts.URL+"/buildring/"+id, "application/json", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusAccepted)
+	location, err := r.Location()
+	assert.Nil(t, err)
+
+	for {
+		r, err = http.Get(location.String())
+		assert.Nil(t, err)
+		if r.Header.Get("X-Pending") != "true" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, r.StatusCode, http.StatusInternalServerError)
+
+	r, err = http.Get(ts.URL + "/buildjobs/" + path.Base(location.Path))
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+
+	var job BuildJobResponse
+	err = GetJsonFromResponse(r, &job)
+	assert.Nil(t, err)
+	assert.Equal(t, BUILD_JOB_FAILED, job.Status)
+	assert.Contains(t, job.Error, "Unable to build ring object")
+	assert.Nil(t, job.Result)
+
+	r, err = http.Get(ts.URL + "/buildjobs/12345")
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusNotFound)
+}
+
+func TestBuildRingJobPanic(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	// The job fails, the server and the build lock survive
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/buildring/"+id, nil)
+	startJob(w, r, id, "", func(job *buildJob) (*BuildRingResponse, error) {
+		buildLock.Lock()
+		defer buildLock.Unlock()
+		panic("index out of range")
+	})
+	assert.Equal(t, w.Code, http.StatusAccepted)
+	jobId := path.Base(w.Header().Get("Location"))
+
+	var job BuildJobResponse
+	for job.Status != BUILD_JOB_FAILED {
+		res, err := http.Get(ts.URL + "/buildjobs/" + jobId)
+		assert.Nil(t, err)
+		err = GetJsonFromResponse(res, &job)
+		assert.Nil(t, err)
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Contains(t, job.Error, "index out of range")
+	assert.Nil(t, job.Result)
+
+	setupTopology(t, id, "object", 3)
+	runBuild(t, id)
+}
+
+func TestBuildRingIncremental(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	ringId := setupTopology(t, id, "object", 3)
+
+	msg := runBuild(t, id)
+	assert.Equal(t, 1, len(msg.Rings))
+	assert.True(t, msg.Rings[0].Created)
+	assert.Equal(t, 3, len(msg.Rings[0].DevicesAdded))
+
+	// Add a device after the first build
+	nodeId := setupNode(t, ringId, "127.0.0.4", 4)
+	deviceId := setupDevice(t, nodeId, "sdb1", 100)
+
+	msg = runBuild(t, id)
+	assert.False(t, msg.Rings[0].Created)
+	assert.Equal(t, []string{deviceId}, msg.Rings[0].DevicesAdded)
+	assert.Empty(t, msg.Rings[0].DevicesRemoved)
+
+	builder, err := ringbuilder.Load(filepath.Join(ringManagerDir, id, "object.builder"))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(builder.Devs))
+	assert.Equal(t, 2, builder.Version)
+
+	// The builder id of the device is remembered
+	var device *DeviceEntry
+	err = db.View(func(tx *bolt.Tx) error {
+		var err error
+		device, err = NewDeviceEntryFromId(tx, deviceId)
+		return err
+	})
+	assert.Nil(t, err)
+	assert.True(t, device.InBuilder)
+	assert.Equal(t, 3, device.BuilderId)
+
+	// Nothing changed since the last build, the ring keeps its version
+	// and checksum
+	etag, err := PathHash(filepath.Join(ringManagerDir, id, "object.ring.gz"))
+	assert.Nil(t, err)
+	msg = runBuild(t, id)
+	assert.True(t, msg.Rings[0].Unchanged)
+	assert.Equal(t, 2, msg.Rings[0].Version)
+	assert.Empty(t, msg.Rings[0].DevicesAdded)
+	assert.Empty(t, msg.Rings[0].DevicesRemoved)
+	assert.Empty(t, msg.Rings[0].DevicesReweighted)
+	assert.Empty(t, msg.Rings[0].DevicesUpdated)
+	same, err := PathHash(filepath.Join(ringManagerDir, id, "object.ring.gz"))
+	assert.Nil(t, err)
+	assert.Equal(t, etag, same)
+	_, err = os.Stat(filepath.Join(ringManagerDir, id, "versions", ringId, "3"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDownloadRingConditional(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	ringId := setupTopology(t, id, "object", 3)
+
+	r, err := http.Get(ts.URL + "/downloadring/" + id + "/object")
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusNotFound)
+
+	runBuild(t, id)
+
+	r, err = http.Get(ts.URL + "/downloadring/" + id + "/object")
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	assert.Nil(t, err)
+	etag := r.Header.Get("Etag")
+	assert.NotEmpty(t, etag)
+	assert.Equal(t, "application/octet-stream", r.Header.Get("Content-Type"))
+	assert.Equal(t, fmt.Sprintf("%v", len(body)), r.Header.Get("Content-Length"))
+	lastModified := r.Header.Get("Last-Modified")
+	_, err = http.ParseTime(lastModified)
+	assert.Nil(t, err)
+
+	ring, err := ringbuilder.Deserialize(mustGunzip(t, body))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(ring.Devs))
+
+	// The client already has it
+	for _, match := range []string{etag, `"` + etag + `"`, `"other", W/"` + etag + `"`, "*"} {
+		req, err := http.NewRequest("GET", ts.URL+"/downloadring/"+id+"/object", nil)
+		assert.Nil(t, err)
+		req.Header.Set("If-None-Match", match)
+		r, err = http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		assert.Equal(t, r.StatusCode, http.StatusNotModified, match)
+		assert.Equal(t, etag, r.Header.Get("Etag"))
+	}
+
+	req, err := http.NewRequest("GET", ts.URL+"/downloadring/"+id+"/object", nil)
+	assert.Nil(t, err)
+	req.Header.Set("If-Modified-Since", lastModified)
+	r, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusNotModified)
+
+	// HEAD only returns the headers
+	r, err = http.Head(ts.URL + "/downloadring/" + id + "/object")
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+	assert.Equal(t, etag, r.Header.Get("Etag"))
+	assert.Equal(t, int64(len(body)), r.ContentLength)
+	head, err := ioutil.ReadAll(r.Body)
+	assert.Nil(t, err)
+	assert.Empty(t, head)
+
+	// A new ring has a new etag
+	nodeId := setupNode(t, ringId, "127.0.0.4", 4)
+	setupDevice(t, nodeId, "sdb1", 100)
+	runBuild(t, id)
+
+	req, err = http.NewRequest("GET", ts.URL+"/downloadring/"+id+"/object", nil)
+	assert.Nil(t, err)
+	req.Header.Set("If-None-Match", etag)
+	r, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+	assert.NotEqual(t, etag, r.Header.Get("Etag"))
+	hash, err := PathHash(filepath.Join(ringManagerDir, id, "object.ring.gz"))
+	assert.Nil(t, err)
+	assert.Equal(t, hash, r.Header.Get("Etag"))
+}
+
+func mustGunzip(t *testing.T, data []byte) io.Reader {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	assert.Nil(t, err)
+	return gz
+}
@@ -0,0 +1,280 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/boltdb/bolt"
+	"github.com/lpabon/godbc"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringbuilder"
+)
+
+// compositeParameters checks the rings can be the components of the
+// composite ring and returns its part power and replicas.  swift checks
+// the builders the same way when composing them.
+func compositeParameters(name, ringType string, components []*RingAddRequest) (int, float64, error) {
+	if ringType == RING_TYPE_COMPONENT {
+		return 0, 0, fmt.Errorf("Component ring %v can not be composite", name)
+	}
+	if len(components) < 2 {
+		return 0, 0, fmt.Errorf("Composite ring %v needs two or more component rings", name)
+	}
+
+	names := make(map[string]bool)
+	replicas := 0.0
+	for _, c := range components {
+		if c.Type != RING_TYPE_COMPONENT {
+			return 0, 0, fmt.Errorf("Ring %v is not a component ring", c.Name)
+		}
+		if names[c.Name] {
+			return 0, 0, fmt.Errorf("Ring %v is a component of ring %v twice", c.Name, name)
+		}
+		names[c.Name] = true
+
+		if c.PartPower != components[0].PartPower {
+			return 0, 0, fmt.Errorf("Components of ring %v must have the same part power", name)
+		}
+		if c.Replicas != math.Trunc(c.Replicas) {
+			return 0, 0, fmt.Errorf("Component ring %v must have a whole number of replicas", c.Name)
+		}
+		replicas += c.Replicas
+	}
+
+	return components[0].PartPower, replicas, nil
+}
+
+// compositeOf returns the composite ring of the cluster the ring is a
+// component of, if any
+func compositeOf(tx *bolt.Tx, cluster *ClusterEntry, id string) (*RingEntry, error) {
+	godbc.Require(tx != nil)
+
+	for _, ringId := range cluster.Info.Rings {
+		ring, err := NewRingEntryFromId(tx, ringId)
+		if err != nil {
+			return nil, err
+		}
+		for _, componentId := range ring.Info.Components {
+			if componentId == id {
+				return ring, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// newCompositeRing checks the components of the new composite ring are
+// rings of its cluster not yet composed, and gives the ring their part
+// power and replicas
+func newCompositeRing(tx *bolt.Tx, cluster *ClusterEntry, ring *RingEntry) error {
+	info := &ring.Info
+	components := make([]*RingAddRequest, len(info.Components))
+	for i, id := range info.Components {
+		component, err := NewRingEntryFromId(tx, id)
+		if err == ErrNotFound {
+			return &topologyError{status: http.StatusNotFound,
+				msg: fmt.Sprintf("Component ring %v does not exist", id)}
+		} else if err != nil {
+			return err
+		}
+		if component.Info.ClusterId != info.ClusterId {
+			return &topologyError{status: http.StatusBadRequest,
+				msg: fmt.Sprintf("Component ring %v is not in cluster %v", id, info.ClusterId)}
+		}
+
+		composite, err := compositeOf(tx, cluster, id)
+		if err != nil {
+			return err
+		}
+		if composite != nil {
+			return newTopologyConflict("Ring %v is already a component of ring %v",
+				component.Info.Name, composite.Info.Name)
+		}
+		components[i] = &component.Info.RingAddRequest
+	}
+
+	partPower, replicas, err := compositeParameters(info.Name, info.Type, components)
+	if err != nil {
+		return &topologyError{status: http.StatusBadRequest, msg: err.Error()}
+	}
+	replicas, err = ringReplicas(info.Name, replicas, info.Policy)
+	if err != nil {
+		return &topologyError{status: http.StatusBadRequest, msg: err.Error()}
+	}
+	info.PartPower = partPower
+	info.Replicas = replicas
+
+	return nil
+}
+
+// composeRing composes the builders of the components, already built
+// with the results given for each ring of the cluster, into the ring file
+// of the composite ring the way swift's ring-composer does, and keeps the
+// composite metadata next to it
+func composeRing(clusterPath string, topology *ringTopology, topologies []*ringTopology, results []*RingBuildResult) (*RingBuildResult, error) {
+	info := &topology.Ring.Info
+	components := make([]*ringTopology, 0, len(info.Components))
+	built := make([]*RingBuildResult, 0, len(info.Components))
+	for _, id := range info.Components {
+		for i, t := range topologies {
+			if t.Ring.Info.Id == id && results[i] != nil {
+				components = append(components, t)
+				built = append(built, results[i])
+			}
+		}
+	}
+	if len(components) != len(info.Components) {
+		return nil, fmt.Errorf("Components of ring %v are missing", info.Name)
+	}
+
+	result := &RingBuildResult{
+		Id:                info.Id,
+		Name:              info.Name,
+		DevicesAdded:      make([]string, 0),
+		DevicesRemoved:    make([]string, 0),
+		DevicesReweighted: make([]string, 0),
+		DevicesUpdated:    make([]string, 0),
+		DevicesDrained:    make([]string, 0),
+	}
+
+	// The fragments are spread over the nodes of every component
+	nodes, devices := 0, 0
+	for _, c := range components {
+		n, d := c.failureDomains()
+		nodes += n
+		devices += d
+	}
+	_, policy := ringPolicy(&info.RingAddRequest)
+	err := checkFailureDomains(info.Name, policy, nodes, devices)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(clusterPath, info.Name+".composite.json")
+	metadata, err := ringbuilder.LoadCompositeMetadata(path)
+	if os.IsNotExist(err) {
+		metadata = ringbuilder.NewCompositeMetadata()
+		result.Created = true
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Composed again only when a component changed
+	result.Unchanged = !result.Created
+	for _, b := range built {
+		result.Balance = math.Max(result.Balance, b.Balance)
+		result.Unchanged = result.Unchanged && b.Unchanged
+	}
+	if result.Unchanged {
+		return result, nil
+	}
+
+	builders := make([]*ringbuilder.RingBuilder, len(components))
+	paths := make([]string, len(components))
+	for i, c := range components {
+		paths[i] = ringBuilderPath(c.Ring.Info.ClusterId, c.Ring.Info.Name)
+		builders[i], err = builderBackend.Load(filepath.Join(clusterPath, c.Ring.Info.Name+".builder"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ring, err := metadata.Compose(builders, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	ringPath := filepath.Join(clusterPath, info.Name+".ring.gz")
+	previous, err := ringbuilder.LoadRingData(ringPath)
+	if err == nil {
+		result.ChangedParts = ringChangedParts(previous, ring)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	err = ring.Save(ringPath)
+	if err != nil {
+		return nil, err
+	}
+	err = metadata.Save(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ringChangedParts returns the number of partitions with a replica on
+// another device in ring than in previous
+func ringChangedParts(previous, ring *ringbuilder.RingData) int {
+	moved := ringMovedReplicas(previous, ring)
+	replicas := ringReplicaTables(previous, ring)
+	changed := 0
+	for part := 0; part < ring.PartCount(); part++ {
+		for replica := 0; replica < replicas; replica++ {
+			if moved(replica, part) {
+				changed++
+				break
+			}
+		}
+	}
+	return changed
+}
+
+// ringReplicaTables returns the number of replica tables of the larger of
+// the two rings
+func ringReplicaTables(previous, ring *ringbuilder.RingData) int {
+	if len(previous.Replica2Part2Dev) > len(ring.Replica2Part2Dev) {
+		return len(previous.Replica2Part2Dev)
+	}
+	return len(ring.Replica2Part2Dev)
+}
+
+// ringMovedReplicas returns a function telling if the replica of the
+// partition is on another device in ring than in previous.  Devices are
+// compared by address, as their ids shift in composite rings when a
+// component gains devices.  The table of the last replica is short when
+// the replica count is fractional, a replica missing from both rings has
+// not moved.
+func ringMovedReplicas(previous, ring *ringbuilder.RingData) func(replica, part int) bool {
+	devices := func(r *ringbuilder.RingData) []string {
+		addrs := make([]string, len(r.Devs))
+		for i, d := range r.Devs {
+			if d != nil {
+				addrs[i] = fmt.Sprintf("%v:%v/%v", d.Ip, d.Port, d.Device)
+			}
+		}
+		return addrs
+	}
+	device := func(r *ringbuilder.RingData, addrs []string, replica, part int) string {
+		if replica >= len(r.Replica2Part2Dev) || part >= len(r.Replica2Part2Dev[replica]) {
+			return ""
+		}
+		if id := int(r.Replica2Part2Dev[replica][part]); id < len(addrs) {
+			return addrs[id]
+		}
+		return ""
+	}
+	before, after := devices(previous), devices(ring)
+
+	return func(replica, part int) bool {
+		return device(previous, before, replica, part) != device(ring, after, replica, part)
+	}
+}
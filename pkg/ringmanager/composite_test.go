@@ -0,0 +1,383 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringbuilder"
+)
+
+// setupComponent adds a component ring with a node in each of 3 zones of
+// the region
+func setupComponent(t *testing.T, clusterId, name string, region int) string {
+	ringId := setupRingWithParameters(t, clusterId, name, `"type":"component", "part_power":8, "min_part_hours":0`)
+	for z := 1; z <= 3; z++ {
+		body := fmt.Sprintf(`{"ring":"%v", "ip":"10.0.%v.%v", "port":"6010", "region":%v, "zone":%v}`,
+			ringId, region, z, region, z)
+		r, err := http.Post(ts.URL+"/nodes", "application/json", bytes.NewBufferString(body))
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusCreated, r.StatusCode)
+
+		var node NodeInfo
+		err = GetJsonFromResponse(r, &node)
+		assert.Nil(t, err)
+		setupDevice(t, node.Id, "sdb1", 100)
+	}
+	return ringId
+}
+
+// buildResult returns the result of the ring in the build
+func buildResult(result *BuildRingResponse, ringId string) *RingBuildResult {
+	for _, ring := range result.Rings {
+		if ring.Id == ringId {
+			return ring
+		}
+	}
+	return nil
+}
+
+func TestRingAddComposite(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	east := setupComponent(t, id, "object-1-east", 1)
+	west := setupComponent(t, id, "object-1-west", 2)
+
+	// Part power and replicas come from the components
+	r := addRing(t, id, `"name":"object-1", "components":["`+east+`", "`+west+`"]`)
+	assert.Equal(t, http.StatusCreated, r.StatusCode)
+	var ring RingInfoResponse
+	err := GetJsonFromResponse(r, &ring)
+	assert.Nil(t, err)
+	assert.Equal(t, 8, ring.PartPower)
+	assert.Equal(t, 6.0, ring.Replicas)
+	assert.Equal(t, []string{east, west}, ring.Components)
+
+	other := setupComponent(t, id, "other", 3)
+	small := setupRingWithParameters(t, id, "small", `"type":"component", "part_power":6`)
+	object := setupRing(t, id, "object")
+	for _, test := range []struct {
+		body   string
+		status int
+	}{
+		{`"name":"object-2", "components":["` + other + `"]`, http.StatusBadRequest},
+		{`"name":"object-2", "components":["` + other + `", "abcdef"]`, http.StatusNotFound},
+		{`"name":"object-2", "components":["` + other + `", "` + object + `"]`, http.StatusBadRequest},
+		{`"name":"object-2", "components":["` + other + `", "` + small + `"]`, http.StatusBadRequest},
+		{`"name":"object-2", "components":["` + other + `", "` + other + `"]`, http.StatusBadRequest},
+		{`"name":"object-2", "components":["` + other + `", "` + east + `"]`, http.StatusConflict},
+		{`"name":"other-2", "type":"component", "components":["` + other + `", "` + small + `"]`,
+			http.StatusBadRequest},
+
+		// 4+2 fragments need 6 replicas
+		{`"name":"object-2", "components":["` + other + `", "` + small + `"], ` +
+			`"policy":{"index":2, "policy_type":"erasure_coding", "ec_type":"liberasurecode_rs_vand", ` +
+			`"ec_num_data_fragments":4, "ec_num_parity_fragments":1}`, http.StatusBadRequest},
+
+		// Component rings are not named like swift rings
+		{`"name":"object-2", "type":"component"`, http.StatusBadRequest},
+		{`"name":"../object-2", "type":"component"`, http.StatusBadRequest},
+	} {
+		r := addRing(t, id, test.body)
+		assert.Equal(t, test.status, r.StatusCode, test.body)
+	}
+
+	// The nodes of a composite ring are the ones of its components
+	r, err = http.Post(ts.URL+"/nodes", "application/json",
+		bytes.NewBufferString(`{"ring":"`+ring.Id+`", "ip":"10.0.9.1", "port":"6010"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, r.StatusCode)
+
+	// Components go away after their composite ring
+	a := setupRingWithParameters(t, id, "a", `"type":"component", "part_power":6`)
+	r = addRing(t, id, `"name":"object-3", "components":["`+small+`", "`+a+`"]`)
+	assert.Equal(t, http.StatusCreated, r.StatusCode)
+	err = GetJsonFromResponse(r, &ring)
+	assert.Nil(t, err)
+	r = httpDelete(t, ts.URL+"/rings/"+a)
+	assert.Equal(t, http.StatusConflict, r.StatusCode)
+	r = httpDelete(t, ts.URL+"/rings/"+ring.Id)
+	assert.Equal(t, http.StatusOK, r.StatusCode)
+	r = httpDelete(t, ts.URL+"/rings/"+a)
+	assert.Equal(t, http.StatusOK, r.StatusCode)
+}
+
+func TestRingAddCompositeErasureCoding(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	east := setupComponent(t, id, "east", 1)
+	west := setupComponent(t, id, "west", 2)
+
+	r := addRing(t, id, `"name":"object-1", "components":["`+east+`", "`+west+`"], `+testEcPolicy)
+	assert.Equal(t, http.StatusCreated, r.StatusCode)
+	var ring RingInfoResponse
+	err := GetJsonFromResponse(r, &ring)
+	assert.Nil(t, err)
+	assert.Equal(t, 6.0, ring.Replicas)
+	assert.Equal(t, POLICY_TYPE_ERASURE_CODING, ring.Policy.PolicyType)
+}
+
+func TestBuildRingComposite(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	east := setupComponent(t, id, "east", 1)
+	west := setupComponent(t, id, "west", 2)
+	ringId := setupRingWithParameters(t, id, "object-1", `"components":["`+east+`", "`+west+`"], `+testEcPolicy)
+
+	result := runBuild(t, id)
+	assert.Equal(t, 3, len(result.Rings))
+	composite := buildResult(result, ringId)
+	assert.True(t, composite.Created)
+	assert.Equal(t, 1, composite.Version)
+
+	// The composite ring holds the replicas of both components
+	clusterPath := filepath.Join(ringManagerDir, id)
+	ring, err := ringbuilder.LoadRingData(filepath.Join(clusterPath, "object-1.ring.gz"))
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(ring.Replica2Part2Dev))
+	assert.Equal(t, 6, len(ring.Devs))
+	assert.Equal(t, 1, ring.Devs[0].Region)
+	assert.Equal(t, 2, ring.Devs[5].Region)
+	assert.Equal(t, 1, ring.Version)
+
+	metadata, err := ringbuilder.LoadCompositeMetadata(filepath.Join(clusterPath, "object-1.composite.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, metadata.Version)
+	assert.Equal(t, 2, len(metadata.Components))
+	eastBuilder, err := ringbuilder.Load(filepath.Join(clusterPath, "east.builder"))
+	assert.Nil(t, err)
+	assert.Equal(t, eastBuilder.Id, metadata.Components[0].Id)
+	assert.Equal(t, filepath.Join(clusterPath, "east.builder"), metadata.ComponentBuilderFiles[eastBuilder.Id])
+
+	r, err := http.Get(ts.URL + "/downloadring/" + id + "/object-1")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, r.StatusCode)
+	assert.Equal(t, "1", r.Header.Get("X-Ring-Version"))
+
+	// A new node in a component changes the composite ring
+	node := fmt.Sprintf(`{"ring":"%v", "ip":"10.0.2.4", "port":"6010", "region":2, "zone":4}`, west)
+	r, err = http.Post(ts.URL+"/nodes", "application/json", bytes.NewBufferString(node))
+	assert.Nil(t, err)
+	var info NodeInfo
+	err = GetJsonFromResponse(r, &info)
+	assert.Nil(t, err)
+	setupDevice(t, info.Id, "sdb1", 100)
+
+	// The dry run of the composite ring moves what west moves
+	result = runBuildWithQuery(t, id, "?dry_run=true")
+	estimate := buildResult(result, ringId).Estimate
+	assert.Equal(t, buildResult(result, west).Estimate.ReplicasMoved, estimate.ReplicasMoved)
+	assert.NotEqual(t, 0, estimate.ReplicasMoved)
+	metadata, err = ringbuilder.LoadCompositeMetadata(filepath.Join(clusterPath, "object-1.composite.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, metadata.Version)
+	assert.Equal(t, filepath.Join(clusterPath, "east.builder"), metadata.ComponentBuilderFiles[eastBuilder.Id])
+
+	// Only the partitions of west move
+	result = runBuild(t, id)
+	composite = buildResult(result, ringId)
+	assert.False(t, composite.Created)
+	assert.Equal(t, 2, composite.Version)
+	assert.Equal(t, buildResult(result, west).ChangedParts, composite.ChangedParts)
+	assert.Equal(t, 0, buildResult(result, east).ChangedParts)
+	metadata, err = ringbuilder.LoadCompositeMetadata(filepath.Join(clusterPath, "object-1.composite.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, metadata.Version)
+
+	// The metadata is kept with the ring of each version
+	r, err = http.Post(ts.URL+"/rings/"+ringId+"/rollback/1", "application/json", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, r.StatusCode)
+	metadata, err = ringbuilder.LoadCompositeMetadata(filepath.Join(clusterPath, "object-1.composite.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, metadata.Version)
+
+	r = httpDelete(t, ts.URL+"/rings/"+ringId)
+	assert.Equal(t, http.StatusOK, r.StatusCode)
+	_, err = os.Stat(filepath.Join(clusterPath, "archive", ringId, "object-1.composite.json"))
+	assert.Nil(t, err)
+}
+
+func TestBuildRingCompositeSameRegion(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	// Swift composes components in distinct regions only
+	east := setupComponent(t, id, "east", 1)
+	west := setupRingWithParameters(t, id, "west", `"type":"component", "part_power":8, "min_part_hours":0`)
+	for z := 1; z <= 3; z++ {
+		nodeId := setupNode(t, west, fmt.Sprintf("127.0.0.%d", z), z)
+		setupDevice(t, nodeId, "sdb1", 100)
+	}
+	setupRingWithParameters(t, id, "object-1", `"components":["`+east+`", "`+west+`"]`)
+
+	r, err := http.Post(ts.URL+"/buildring/"+id, "application/json", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusAccepted, r.StatusCode)
+	location, err := r.Location()
+	assert.Nil(t, err)
+	for {
+		r, err = http.Get(location.String())
+		assert.Nil(t, err)
+		if r.Header.Get("X-Pending") != "true" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, http.StatusInternalServerError, r.StatusCode)
+
+	r, err = http.Get(ts.URL + "/buildjobs/" + path.Base(location.Path))
+	assert.Nil(t, err)
+	var job BuildJobResponse
+	err = GetJsonFromResponse(r, &job)
+	assert.Nil(t, err)
+	assert.Equal(t, BUILD_JOB_FAILED, job.Status)
+	assert.Contains(t, job.Error, "Same region 1")
+
+	// The components built before the failure are not kept
+	for _, name := range []string{"east.builder", "east.ring.gz", "west.builder"} {
+		_, err = os.Stat(filepath.Join(ringManagerDir, id, name))
+		assert.True(t, os.IsNotExist(err), name)
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		ring, err := NewRingEntryFromId(tx, east)
+		if err != nil {
+			return err
+		}
+		assert.Equal(t, 0, ring.LastVersion)
+		node, err := NewNodeEntryFromId(tx, ring.Nodes[0])
+		if err != nil {
+			return err
+		}
+		device, err := NewDeviceEntryFromId(tx, node.Devices[0])
+		if err != nil {
+			return err
+		}
+		assert.False(t, device.InBuilder)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+const testCompositeTopology = `
+rings:
+- name: object-1
+  components: [east, west]
+- name: east
+  type: component
+  part_power: 8
+  min_part_hours: 0
+  nodes:
+  - {ip: 10.0.1.1, port: "6010", region: 1, zone: 1, devices: [{name: sdb1, weight: 100}]}
+  - {ip: 10.0.1.2, port: "6010", region: 1, zone: 2, devices: [{name: sdb1, weight: 100}]}
+  - {ip: 10.0.1.3, port: "6010", region: 1, zone: 3, devices: [{name: sdb1, weight: 100}]}
+- name: west
+  type: component
+  part_power: 8
+  min_part_hours: 0
+  nodes:
+  - {ip: 10.0.2.1, port: "6010", region: 2, zone: 1, devices: [{name: sdb1, weight: 100}]}
+  - {ip: 10.0.2.2, port: "6010", region: 2, zone: 2, devices: [{name: sdb1, weight: 100}]}
+  - {ip: 10.0.2.3, port: "6010", region: 2, zone: 3, devices: [{name: sdb1, weight: 100}]}
+`
+
+func TestClusterApplyComposite(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	msg := applyTopologyChanges(t, id, testCompositeTopology, "")
+	assert.Equal(t, 3, changeCount(msg)["add ring"])
+
+	r, err := http.Get(ts.URL + "/clusters/" + id + "/export")
+	assert.Nil(t, err)
+	var topology Topology
+	err = GetJsonFromResponse(r, &topology)
+	assert.Nil(t, err)
+	assert.Equal(t, "object-1", topology.Rings[1].Name)
+	assert.Equal(t, []string{"east", "west"}, topology.Rings[1].Components)
+	assert.Equal(t, 8, topology.Rings[1].PartPower)
+	assert.Equal(t, 6.0, topology.Rings[1].Replicas)
+
+	// Nothing changes when applied again
+	msg = applyTopologyChanges(t, id, testCompositeTopology, "")
+	assert.Equal(t, 0, len(msg.Changes))
+
+	swapped := `
+rings:
+- name: object-1
+  components: [west, east]
+- name: east
+  type: component
+  part_power: 8
+  min_part_hours: 0
+- name: west
+  type: component
+  part_power: 8
+  min_part_hours: 0
+`
+	msg = applyTopologyChanges(t, id, swapped, "?plan=true")
+	var changes []string
+	for _, change := range msg.Changes {
+		if change.Name == "object-1" {
+			changes = change.Changes
+		}
+	}
+	assert.Equal(t, []string{"components: east, west -> west, east"}, changes)
+
+	// The composite ring keeps its components once built
+	runBuild(t, id)
+	r = applyTopology(t, id, "application/x-yaml", swapped, "")
+	assert.Equal(t, http.StatusConflict, r.StatusCode)
+
+	for _, invalid := range []string{
+		// Unknown component
+		"rings:\n- name: object-1\n  components: [east, north]\n- name: east\n  type: component\n",
+		// Composite rings have no nodes
+		"rings:\n- name: object-1\n  components: [east, west]\n  nodes: [{ip: 10.0.3.1, port: \"6010\"}]\n" +
+			"- name: east\n  type: component\n- name: west\n  type: component\n",
+		// A component is composed once
+		"rings:\n- name: object-1\n  components: [east, west]\n- name: object-2\n  components: [east, west]\n" +
+			"- name: east\n  type: component\n- name: west\n  type: component\n",
+		// Replicas are the ones of the components
+		"rings:\n- name: object-1\n  replicas: 3\n  components: [east, west]\n" +
+			"- name: east\n  type: component\n- name: west\n  type: component\n",
+	} {
+		r = applyTopology(t, id, "application/x-yaml", invalid, "")
+		assert.Equal(t, http.StatusBadRequest, r.StatusCode, invalid)
+	}
+}
@@ -0,0 +1,347 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+)
+
+const (
+	DEPLOYMENT_CURRENT      = "current"
+	DEPLOYMENT_STALE        = "stale"
+	DEPLOYMENT_NEVER_SYNCED = "never_synced"
+)
+
+// Etag returns the ring the node has, taken from whichever of the last
+// download and the last report is more recent
+func (d *NodeDeployment) Etag() string {
+	if d.Reported.Before(d.Fetched) {
+		return d.FetchedEtag
+	}
+	return d.ReportedEtag
+}
+
+// Status tells if the node has the ring with the etag
+func (d *NodeDeployment) Status(etag string) string {
+	switch d.Etag() {
+	case "":
+		return DEPLOYMENT_NEVER_SYNCED
+	case etag:
+		return DEPLOYMENT_CURRENT
+	default:
+		return DEPLOYMENT_STALE
+	}
+}
+
+// deploymentNodes returns the nodes installing the ring.  Nodes install
+// the composite ring instead of the components they belong to.
+func deploymentNodes(tx *bolt.Tx, ring *RingEntry) ([]string, error) {
+	if !ring.IsComposite() {
+		return ring.Nodes, nil
+	}
+
+	nodes := make([]string, 0)
+	for _, id := range ring.Info.Components {
+		component, err := NewRingEntryFromId(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, component.Nodes...)
+	}
+	return nodes, nil
+}
+
+// updateNodeDeployments updates the deployment of the nodes of the ring
+// with the address, which is the ip or the id of the node
+func updateNodeDeployments(tx *bolt.Tx, ring *RingEntry, addr string,
+	update func(d *NodeDeployment)) error {
+
+	nodes, err := deploymentNodes(tx, ring)
+	if err != nil {
+		return err
+	}
+
+	for _, nodeId := range nodes {
+		node, err := NewNodeEntryFromId(tx, nodeId)
+		if err != nil {
+			return err
+		}
+		if !node.HasAddress(addr) {
+			continue
+		}
+
+		update(&node.Deployment)
+		err = node.Save(tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ringFetchRecorded tells if the nodes with the address are already known
+// to have the ring with the etag
+func ringFetchRecorded(tx *bolt.Tx, ring *RingEntry, addr, etag string) (bool, error) {
+	nodes, err := deploymentNodes(tx, ring)
+	if err != nil {
+		return false, err
+	}
+
+	for _, nodeId := range nodes {
+		node, err := NewNodeEntryFromId(tx, nodeId)
+		if err != nil {
+			return false, err
+		}
+		if !node.HasAddress(addr) {
+			continue
+		}
+
+		d := &node.Deployment
+		if d.FetchedEtag != etag || d.Etag() != etag {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// recordRingFetch remembers the node at addr downloaded the ring. Nodes
+// poll for their rings, so the database is only written when the ring
+// they fetch changes.
+func recordRingFetch(ringId, addr, etag string) error {
+	var recorded bool
+	err := db.View(func(tx *bolt.Tx) error {
+		ring, err := NewRingEntryFromId(tx, ringId)
+		if err != nil {
+			return err
+		}
+
+		recorded, err = ringFetchRecorded(tx, ring, addr, etag)
+		return err
+	})
+	if err != nil || recorded {
+		return err
+	}
+
+	now := time.Now().UTC()
+	return db.Update(func(tx *bolt.Tx) error {
+		ring, err := NewRingEntryFromId(tx, ringId)
+		if err != nil {
+			return err
+		}
+
+		return updateNodeDeployments(tx, ring, addr, func(d *NodeDeployment) {
+			d.FetchedEtag = etag
+			d.Fetched = now
+		})
+	})
+}
+
+// ringFileEtag returns the etag of the ring file served to the nodes, or
+// nothing when the ring has not been built
+func ringFileEtag(clusterId, ringName string) (string, error) {
+	path := ringFilePath(clusterId, ringName)
+	fp, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	defer fp.Close()
+
+	etag, _, err := CachedFileHash(path, fp)
+	return etag, err
+}
+
+// DeploymentReport saves the rings installed on a storage node, as
+// reported by its agent
+func DeploymentReport(w http.ResponseWriter, r *http.Request) {
+	// Get the id from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var msg AgentReportRequest
+	err := GetJsonFromRequest(r, &msg)
+	if err != nil {
+		http.Error(w, "request unable to be parsed", 422)
+		return
+	}
+
+	// check information in JSON request
+	if len(msg.Node) == 0 {
+		http.Error(w, "Node missing", http.StatusBadRequest)
+		return
+	}
+	for _, ring := range msg.Rings {
+		if ring == nil || len(ring.Name) == 0 {
+			http.Error(w, "Ring name missing", http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		agent := NewAgentEntry()
+		agent.Info.ClusterId = id
+		agent.Info.Node = msg.Node
+		if msg.Rings != nil {
+			agent.Info.Rings = msg.Rings
+		}
+		agent.Info.Reported = time.Now().UTC()
+
+		err = agent.Save(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		// Track the rings on the nodes with the address of the agent
+		for _, ringId := range cluster.Info.Rings {
+			ring, err := NewRingEntryFromId(tx, ringId)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return err
+			}
+			if ring.Info.Type == RING_TYPE_COMPONENT {
+				continue
+			}
+
+			for _, status := range agent.Info.Rings {
+				if status.Name != ring.Info.Name {
+					continue
+				}
+
+				err = updateNodeDeployments(tx, ring, msg.Node, func(d *NodeDeployment) {
+					d.ReportedEtag = status.Etag
+					d.Reported = agent.Info.Reported
+				})
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// Write msg
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeploymentStatus shows which nodes have the current build of the rings
+// of the cluster, which have an older one and which never got any.
+// Component rings are not installed, only their composite ring is.
+func DeploymentStatus(w http.ResponseWriter, r *http.Request) {
+	// Get the id from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	msg := &DeploymentResponse{
+		ClusterId: id,
+		Rings:     make([]*RingDeploymentStatus, 0),
+		Current:   true,
+	}
+	err := db.View(func(tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		for _, ringId := range cluster.Info.Rings {
+			ring, err := NewRingEntryFromId(tx, ringId)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return err
+			}
+			if ring.Info.Type == RING_TYPE_COMPONENT {
+				continue
+			}
+			etag, err := ringFileEtag(id, ring.Info.Name)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return err
+			}
+			nodes, err := deploymentNodes(tx, ring)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return err
+			}
+
+			ringStatus := &RingDeploymentStatus{
+				Id:      ring.Info.Id,
+				Name:    ring.Info.Name,
+				Etag:    etag,
+				Version: ring.Version,
+				Nodes:   make([]*NodeDeploymentStatus, 0, len(nodes)),
+			}
+			for _, nodeId := range nodes {
+				node, err := NewNodeEntryFromId(tx, nodeId)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return err
+				}
+
+				nodeStatus := &NodeDeploymentStatus{
+					NodeDeployment: node.Deployment,
+					Id:             node.Info.Id,
+					Ip:             node.Info.Ip,
+					Port:           node.Info.Port,
+					Status:         node.Deployment.Status(etag),
+				}
+				if nodeStatus.Status != DEPLOYMENT_CURRENT {
+					msg.Current = false
+				}
+				ringStatus.Nodes = append(ringStatus.Nodes, nodeStatus)
+			}
+			msg.Rings = append(msg.Rings, ringStatus)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// Write msg
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(msg); err != nil {
+		panic(err)
+	}
+}
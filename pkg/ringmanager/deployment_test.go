@@ -0,0 +1,256 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeploymentReport(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	body := []byte(`{"node":"127.0.0.1", "rings":[{"name":"object", "etag":"abc", "version":2}]}`)
+	r, err := http.Post(ts.URL+"/clusters/"+id+"/deployment", "application/json", bytes.NewBuffer(body))
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusNoContent)
+
+	err = db.View(func(tx *bolt.Tx) error {
+		agent, err := NewAgentEntryFromId(tx, id, "127.0.0.1")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(agent.Info.Rings))
+		assert.Equal(t, "abc", agent.Info.Rings[0].Etag)
+		assert.Equal(t, 2, agent.Info.Rings[0].Version)
+		assert.False(t, agent.Info.Reported.IsZero())
+		return nil
+	})
+	assert.Nil(t, err)
+
+	// Unknown cluster
+	r, err = http.Post(ts.URL+"/clusters/123/deployment", "application/json", bytes.NewBuffer(body))
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusNotFound)
+
+	// Missing node
+	body = []byte(`{"rings":[]}`)
+	r, err = http.Post(ts.URL+"/clusters/"+id+"/deployment", "application/json", bytes.NewBuffer(body))
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusBadRequest)
+
+	// The agents go away with the cluster
+	r = httpDelete(t, ts.URL+"/clusters/"+id)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+	err = db.View(func(tx *bolt.Tx) error {
+		_, err := NewAgentEntryFromId(tx, id, "127.0.0.1")
+		assert.Equal(t, ErrNotFound, err)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func getDeployment(t *testing.T, id string) *DeploymentResponse {
+	r, err := http.Get(ts.URL + "/clusters/" + id + "/deployment")
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+
+	var msg DeploymentResponse
+	err = GetJsonFromResponse(r, &msg)
+	assert.Nil(t, err)
+	return &msg
+}
+
+func deploymentStatus(msg *DeploymentResponse) map[string]string {
+	status := make(map[string]string)
+	for _, ring := range msg.Rings {
+		for _, node := range ring.Nodes {
+			status[node.Ip] = node.Status
+		}
+	}
+	return status
+}
+
+func TestDeploymentStatus(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	setupTopology(t, id, "object", 3)
+
+	msg := getDeployment(t, id)
+	assert.Equal(t, 1, len(msg.Rings))
+	assert.Empty(t, msg.Rings[0].Etag)
+	assert.False(t, msg.Current)
+	assert.Equal(t, map[string]string{
+		"127.0.0.1": DEPLOYMENT_NEVER_SYNCED,
+		"127.0.0.2": DEPLOYMENT_NEVER_SYNCED,
+		"127.0.0.3": DEPLOYMENT_NEVER_SYNCED,
+	}, deploymentStatus(msg))
+
+	runBuild(t, id)
+
+	// First node downloads the ring
+	r, err := http.Get(ts.URL + "/downloadring/" + id + "/object?node=127.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+	etag := r.Header.Get("Etag")
+
+	// Second node reports it installed the ring, third an old one
+	for node, reported := range map[string]string{"127.0.0.2": etag, "127.0.0.3": "abc"} {
+		body := []byte(fmt.Sprintf(`{"node":"%v", "rings":[{"name":"object", "etag":"%v", "version":1}]}`,
+			node, reported))
+		r, err = http.Post(ts.URL+"/clusters/"+id+"/deployment", "application/json", bytes.NewBuffer(body))
+		assert.Nil(t, err)
+		assert.Equal(t, r.StatusCode, http.StatusNoContent)
+	}
+
+	msg = getDeployment(t, id)
+	assert.Equal(t, etag, msg.Rings[0].Etag)
+	assert.Equal(t, 1, msg.Rings[0].Version)
+	assert.False(t, msg.Current)
+	assert.Equal(t, map[string]string{
+		"127.0.0.1": DEPLOYMENT_CURRENT,
+		"127.0.0.2": DEPLOYMENT_CURRENT,
+		"127.0.0.3": DEPLOYMENT_STALE,
+	}, deploymentStatus(msg))
+	var third string
+	for _, node := range msg.Rings[0].Nodes {
+		switch node.Ip {
+		case "127.0.0.1":
+			assert.Equal(t, etag, node.FetchedEtag)
+			assert.False(t, node.Fetched.IsZero())
+			assert.True(t, node.Reported.IsZero())
+		case "127.0.0.3":
+			assert.Equal(t, "abc", node.ReportedEtag)
+			third = node.Id
+		}
+	}
+
+	// Third node catches up, known by its id
+	req, err := http.NewRequest("GET", ts.URL+"/downloadring/"+id+"/object?node="+third, nil)
+	assert.Nil(t, err)
+	req.Header.Set("If-None-Match", "abc")
+	r, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+
+	msg = getDeployment(t, id)
+	assert.True(t, msg.Current)
+
+	// Unknown cluster
+	r, err = http.Get(ts.URL + "/clusters/123/deployment")
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusNotFound)
+}
+
+func TestDeploymentFetchPolling(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	setupTopology(t, id, "object", 3)
+	runBuild(t, id)
+
+	fetched := func() *NodeDeploymentStatus {
+		for _, node := range getDeployment(t, id).Rings[0].Nodes {
+			if node.Ip == "127.0.0.1" {
+				return node
+			}
+		}
+		return nil
+	}
+
+	r, err := http.Get(ts.URL + "/downloadring/" + id + "/object?node=127.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+	etag := r.Header.Get("Etag")
+	first := fetched()
+	assert.Equal(t, etag, first.FetchedEtag)
+
+	// Polling the same ring does not record the fetch again
+	req, err := http.NewRequest("GET", ts.URL+"/downloadring/"+id+"/object?node=127.0.0.1", nil)
+	assert.Nil(t, err)
+	req.Header.Set("If-None-Match", etag)
+	r, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusNotModified)
+	assert.True(t, first.Fetched.Equal(fetched().Fetched))
+
+	// Unless the node reported another ring since
+	body := []byte(`{"node":"127.0.0.1", "rings":[{"name":"object", "etag":"abc", "version":1}]}`)
+	r, err = http.Post(ts.URL+"/clusters/"+id+"/deployment", "application/json", bytes.NewBuffer(body))
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusNoContent)
+
+	r, err = http.Get(ts.URL + "/downloadring/" + id + "/object?node=127.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+	assert.True(t, first.Fetched.Before(fetched().Fetched))
+	assert.Equal(t, DEPLOYMENT_CURRENT, fetched().Status)
+}
+
+func TestDeploymentComposite(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	east := setupComponent(t, id, "east", 1)
+	west := setupComponent(t, id, "west", 2)
+	setupRingWithParameters(t, id, "object-1", `"components":["`+east+`", "`+west+`"], `+testEcPolicy)
+	runBuild(t, id)
+
+	// Only the composite ring is installed, by the nodes of its
+	// components
+	msg := getDeployment(t, id)
+	assert.Equal(t, 1, len(msg.Rings))
+	assert.Equal(t, "object-1", msg.Rings[0].Name)
+	assert.Equal(t, 6, len(msg.Rings[0].Nodes))
+	assert.False(t, msg.Current)
+
+	// Fetching a component does not count as installing it
+	r, err := http.Get(ts.URL + "/downloadring/" + id + "/east?node=10.0.1.1")
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+	assert.Equal(t, DEPLOYMENT_NEVER_SYNCED, deploymentStatus(getDeployment(t, id))["10.0.1.1"])
+
+	for region := 1; region <= 2; region++ {
+		for z := 1; z <= 3; z++ {
+			r, err = http.Get(fmt.Sprintf("%v/downloadring/%v/object-1?node=10.0.%v.%v", ts.URL, id, region, z))
+			assert.Nil(t, err)
+			assert.Equal(t, r.StatusCode, http.StatusOK)
+		}
+	}
+	msg = getDeployment(t, id)
+	assert.True(t, msg.Current)
+	assert.Equal(t, DEPLOYMENT_CURRENT, deploymentStatus(msg)["10.0.2.3"])
+
+	// The nodes report the composite ring
+	body := []byte(`{"node":"10.0.1.1", "rings":[{"name":"object-1", "etag":"abc", "version":1}]}`)
+	r, err = http.Post(ts.URL+"/clusters/"+id+"/deployment", "application/json", bytes.NewBuffer(body))
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusNoContent)
+	assert.Equal(t, DEPLOYMENT_STALE, deploymentStatus(getDeployment(t, id))["10.0.1.1"])
+}
@@ -116,6 +116,135 @@ func DeviceInformation(w http.ResponseWriter, r *http.Request) {
 
 }
 
+func DeviceUpdate(w http.ResponseWriter, r *http.Request) {
+	// Get device id from URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var msg DeviceUpdateRequest
+	err := GetJsonFromRequest(r, &msg)
+	if err != nil {
+		http.Error(w, "request unable to be parsed", 422)
+		return
+	}
+
+	// check information in JSON request
+	if msg.Name != nil && *msg.Name == "" {
+		http.Error(w, "Device name missing", http.StatusBadRequest)
+		return
+	}
+
+	// The changes reach the builder on the next build, the device keeps
+	// its partitions
+	var info *DeviceInfoResponse
+	err = db.Update(func(tx *bolt.Tx) error {
+		device, err := NewDeviceEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		// The name is part of the register key
+		err = device.Deregister(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		device.Update(&msg)
+
+		err = device.Register(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return err
+		}
+
+		err = device.Save(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		info, err = device.NewInfoResponse()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// Write msg
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		panic(err)
+	}
+}
+
+func DeviceSetWeight(w http.ResponseWriter, r *http.Request) {
+	// Get device id from URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var msg DeviceWeightRequest
+	err := GetJsonFromRequest(r, &msg)
+	if err != nil {
+		http.Error(w, "request unable to be parsed", 422)
+		return
+	}
+
+	// check information in JSON request
+	if msg.Weight == nil {
+		http.Error(w, "Weight missing", http.StatusBadRequest)
+		return
+	}
+
+	// Only the target is set, the builds move the current weight
+	var info *DeviceInfoResponse
+	err = db.Update(func(tx *bolt.Tx) error {
+		device, err := NewDeviceEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		device.Info.Weight.Target = *msg.Weight
+		err = device.Save(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		info, err = device.NewInfoResponse()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// Write msg
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		panic(err)
+	}
+}
+
 func getDeviceInfo(id string) (*DeviceInfoResponse, error) {
 	var info *DeviceInfoResponse
 	err := db.View(func(tx *bolt.Tx) error {
@@ -141,10 +270,76 @@ func getDeviceInfo(id string) (*DeviceInfoResponse, error) {
 }
 
 func DeviceDelete(w http.ResponseWriter, r *http.Request) {
-	// TODO
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.WriteHeader(http.StatusNotFound)
-	if err := json.NewEncoder(w).Encode(jsonErr{Code: http.StatusNotFound, Text: "Not Implemented yet"}); err != nil {
-		panic(err)
+	// Get the id from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// Devices holding partitions are only deleted when forced
+	force := GetBoolFromQuery(r, "force")
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		device, err := NewDeviceEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		node, err := NewNodeEntryFromId(tx, device.NodeId)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		ring, err := NewRingEntryFromId(tx, node.Info.RingId)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		parts, err := ringPartsByDevice(ring)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		// The device is removed from the builder by the next build
+		err = deviceDelete(tx, node, device, parts, force)
+		if err == ErrConflict {
+			http.Error(w, device.ConflictString(parts[device.BuilderId]), http.StatusConflict)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		err = node.Save(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
 	}
+
+	// Write msg
+	w.WriteHeader(http.StatusOK)
+}
+
+// deviceDelete deletes the device and removes it from the node, which
+// still needs to be saved.  It fails with ErrConflict if the device holds
+// partitions in the built ring, unless forced.
+func deviceDelete(tx *bolt.Tx, node *NodeEntry, device *DeviceEntry, parts map[int]int, force bool) error {
+	if device.InBuilder && parts[device.BuilderId] > 0 && !force {
+		return ErrConflict
+	}
+
+	node.DeviceDelete(device.Info.Id)
+
+	return device.Delete(tx)
 }
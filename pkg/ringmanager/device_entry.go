@@ -29,6 +29,13 @@ type DeviceEntry struct {
 
 	Info   DeviceInfo
 	NodeId string
+
+	// Id of the device in the ring builder, once added by BuildRing
+	BuilderId int
+	InBuilder bool
+
+	// Unix time of the last build that changed the current weight
+	WeightChangedAt int64
 }
 
 func DeviceList(tx *bolt.Tx) ([]string, error) {
@@ -120,6 +127,45 @@ func (d *DeviceEntry) Deregister(tx *bolt.Tx) error {
 	return nil
 }
 
+func (d *DeviceEntry) Delete(tx *bolt.Tx) error {
+	godbc.Require(tx != nil)
+
+	err := d.Deregister(tx)
+	if err != nil {
+		return err
+	}
+
+	return EntryDelete(tx, d, d.Info.Id)
+}
+
+// ConflictString returns why the device holding parts partitions can not
+// be deleted
+func (d *DeviceEntry) ConflictString(parts int) string {
+	return fmt.Sprintf("Unable to delete device [%v] because it holds %v partitions in the ring",
+		d.Info.Id, parts)
+}
+
+// Update changes the device to the values set in the request
+func (d *DeviceEntry) Update(req *DeviceUpdateRequest) {
+	godbc.Require(req != nil)
+
+	if req.Name != nil {
+		d.Info.Name = *req.Name
+	}
+	if req.Meta != nil {
+		d.Info.Meta = *req.Meta
+	}
+}
+
+// IsDrained checks the device has no weight and holds none of the parts
+// of the built ring
+func (d *DeviceEntry) IsDrained(parts map[int]int) bool {
+	return d.Info.Weight.Target == 0 &&
+		d.Info.Weight.Current == 0 &&
+		d.InBuilder &&
+		parts[d.BuilderId] == 0
+}
+
 func (d *DeviceEntry) BucketName() string {
 	return BOLTDB_BUCKET_DEVICE
 }
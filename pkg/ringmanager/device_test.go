@@ -0,0 +1,222 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringbuilder"
+)
+
+func TestDeviceDelete(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	ringId := setupRing(t, id, "object")
+	nodeId := setupNode(t, ringId, "127.0.0.1", 1)
+	deviceId := setupDevice(t, nodeId, "sdb1", 100)
+
+	// Not built yet, nothing holds partitions
+	res := httpDelete(t, ts.URL+"/devices/"+deviceId)
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+
+	r, err := http.Get(ts.URL + "/devices/" + deviceId)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusNotFound)
+
+	r, err = http.Get(ts.URL + "/nodes/" + nodeId)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+
+	var msg NodeInfoResponse
+	err = GetJsonFromResponse(r, &msg)
+	assert.Nil(t, err)
+	assert.Empty(t, msg.Devices)
+
+	// The register key is released
+	setupDevice(t, nodeId, "sdb1", 100)
+}
+
+func TestDeviceDeleteIdNotFound(t *testing.T) {
+
+	// setup and teardown test case
+	_, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	res := httpDelete(t, ts.URL+"/devices/12345")
+	assert.Equal(t, res.StatusCode, http.StatusNotFound)
+}
+
+func TestDeviceDeleteHoldsPartitions(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	ringId := setupTopology(t, id, "object", 4)
+
+	runBuild(t, id)
+
+	var device *DeviceEntry
+	err := db.View(func(tx *bolt.Tx) error {
+		ring, err := NewRingEntryFromId(tx, ringId)
+		if err != nil {
+			return err
+		}
+		node, err := NewNodeEntryFromId(tx, ring.Nodes[0])
+		if err != nil {
+			return err
+		}
+		device, err = NewDeviceEntryFromId(tx, node.Devices[0])
+		return err
+	})
+	assert.Nil(t, err)
+
+	res := httpDelete(t, ts.URL+"/devices/"+device.Info.Id)
+	assert.Equal(t, res.StatusCode, http.StatusConflict)
+
+	r, err := http.Get(ts.URL + "/devices/" + device.Info.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+
+	res = httpDelete(t, ts.URL+"/devices/"+device.Info.Id+"?force=true")
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+
+	// The next build removes the device from the builder
+	msg := runBuild(t, id)
+	assert.Equal(t, 1, len(msg.Rings[0].DevicesRemoved))
+	assert.Contains(t, msg.Rings[0].DevicesRemoved[0], fmt.Sprintf("d%d", device.BuilderId))
+}
+
+func TestDeviceUpdate(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	ringId := setupRing(t, id, "object")
+	nodeId := setupNode(t, ringId, "127.0.0.1", 1)
+	deviceId := setupDevice(t, nodeId, "sdb1", 100)
+	setupDevice(t, nodeId, "sdc1", 100)
+
+	res := httpPatch(t, ts.URL+"/devices/"+deviceId, `{"meta":"slot 3"}`)
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+
+	var device DeviceInfoResponse
+	err := GetJsonFromResponse(res, &device)
+	assert.Nil(t, err)
+	assert.Equal(t, "sdb1", device.Name)
+	assert.Equal(t, "slot 3", device.Meta)
+
+	// The name is unique in the node
+	res = httpPatch(t, ts.URL+"/devices/"+deviceId, `{"name":"sdc1"}`)
+	assert.Equal(t, res.StatusCode, http.StatusConflict)
+
+	res = httpPatch(t, ts.URL+"/devices/"+deviceId, `{"name":"sdd1"}`)
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+
+	// The old name is free again
+	setupDevice(t, nodeId, "sdb1", 100)
+
+	res = httpPatch(t, ts.URL+"/devices/"+deviceId, `{"name":""}`)
+	assert.Equal(t, res.StatusCode, http.StatusBadRequest)
+
+	res = httpPatch(t, ts.URL+"/devices/12345", `{"meta":""}`)
+	assert.Equal(t, res.StatusCode, http.StatusNotFound)
+}
+
+func TestDeviceSetWeight(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	deviceWeightStep = &weightStep{value: 25}
+	defer func() { deviceWeightStep = nil }()
+
+	// Step on every build
+	ringId := setupRingWithParameters(t, id, "object", `"min_part_hours":0`)
+	for z := 1; z <= 3; z++ {
+		nodeId := setupNode(t, ringId, fmt.Sprintf("127.0.0.%d", z), z)
+		setupDevice(t, nodeId, "sdb1", 100)
+	}
+
+	// A new builder starts at the target weights
+	runBuild(t, id)
+
+	var ring RingInfoResponse
+	r, err := http.Get(ts.URL + "/rings/" + ringId)
+	assert.Nil(t, err)
+	err = GetJsonFromResponse(r, &ring)
+	assert.Nil(t, err)
+	var node NodeInfoResponse
+	r, err = http.Get(ts.URL + "/nodes/" + ring.Nodes[0])
+	assert.Nil(t, err)
+	err = GetJsonFromResponse(r, &node)
+	assert.Nil(t, err)
+	deviceId := node.Devices[0]
+
+	r, err = http.Get(ts.URL + "/devices/" + deviceId)
+	assert.Nil(t, err)
+	var device DeviceInfoResponse
+	err = GetJsonFromResponse(r, &device)
+	assert.Nil(t, err)
+	assert.Equal(t, DeviceWeight{Current: 100, Target: 100}, device.Weight)
+
+	req, err := http.NewRequest("PUT", ts.URL+"/devices/"+deviceId+"/weight",
+		bytes.NewBufferString(`{"weight":150}`))
+	assert.Nil(t, err)
+	res, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+	err = GetJsonFromResponse(res, &device)
+	assert.Nil(t, err)
+	assert.Equal(t, DeviceWeight{Current: 100, Target: 150}, device.Weight)
+
+	// Every build moves the weight one step
+	for _, current := range []uint64{125, 150, 150} {
+		runBuild(t, id)
+
+		r, err = http.Get(ts.URL + "/devices/" + deviceId)
+		assert.Nil(t, err)
+		err = GetJsonFromResponse(r, &device)
+		assert.Nil(t, err)
+		assert.Equal(t, DeviceWeight{Current: current, Target: 150}, device.Weight)
+	}
+
+	builder, err := ringbuilder.Load(filepath.Join(ringManagerDir, id, "object.builder"))
+	assert.Nil(t, err)
+	weights := make([]float64, 0)
+	for _, d := range builder.Devices() {
+		weights = append(weights, d.Weight)
+	}
+	assert.ElementsMatch(t, []float64{150, 100, 100}, weights)
+
+	req, err = http.NewRequest("PUT", ts.URL+"/devices/"+deviceId+"/weight",
+		bytes.NewBufferString(`{}`))
+	assert.Nil(t, err)
+	res, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, res.StatusCode, http.StatusBadRequest)
+}
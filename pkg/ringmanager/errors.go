@@ -29,4 +29,6 @@ var (
 	ErrAccessList       = errors.New("Unable to access list")
 	ErrKeyExists        = errors.New("Key already exists in the database")
 	ErrNoReplacement    = errors.New("No Replacement was found for resource requested to be removed")
+	ErrDryRun           = errors.New("Dry run, the changes were rolled back")
+	ErrPartPower        = errors.New("Part power must be between 1 and 24")
 )
@@ -0,0 +1,134 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringbuilder"
+)
+
+const (
+	// Average size of a replica of a partition when none is configured
+	DEFAULT_PARTITION_SIZE = 1 << 30
+)
+
+// dryRunCluster builds the rings of the cluster like buildCluster, but in
+// a scratch copy of their files, and estimates what the rebalance would
+// move.  Neither the files of the rings nor the db are changed.
+func dryRunCluster(id string, partitionSize uint64, job *buildJob) (*BuildRingResponse, error) {
+	job.Printf("Estimating the rebalance of the rings of cluster %v", id)
+	buildLock.Lock()
+	defer buildLock.Unlock()
+
+	topologies, err := clusterTopology(id)
+	if err != nil {
+		return nil, err
+	}
+
+	scratch, err := ioutil.TempDir("", "ringmanager-dry-run-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(scratch)
+
+	for _, topology := range topologies {
+		err = copyRingFiles(topology.Ring, scratch)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	response := &BuildRingResponse{DryRun: true, PartitionSize: partitionSize}
+	response.Rings, err = buildRings(scratch, topologies, job)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, topology := range topologies {
+		estimate, err := ringMoveEstimate(topology.Ring, scratch, partitionSize)
+		if err != nil {
+			return nil, err
+		}
+		job.Printf("Ring %v: would move %v replicas of %v partitions, about %v bytes",
+			topology.Ring.Info.Name, estimate.ReplicasMoved, estimate.PartsMoved, estimate.BytesMoved)
+		response.Rings[i].Estimate = estimate
+	}
+
+	return response, nil
+}
+
+// ringMoveEstimate compares the current ring file of the ring with the
+// one built in dir
+func ringMoveEstimate(ring *RingEntry, dir string, partitionSize uint64) (*RingMoveEstimate, error) {
+	after, err := ringbuilder.LoadRingData(filepath.Join(dir, ring.Info.Name+".ring.gz"))
+	if err != nil {
+		return nil, err
+	}
+	report, err := ringDataReport(after, ring)
+	if err != nil {
+		return nil, err
+	}
+	estimate := &RingMoveEstimate{
+		BalanceAfter:    report.Balance,
+		DispersionAfter: report.Dispersion,
+	}
+
+	before, err := ringbuilder.LoadRingData(ringFilePath(ring.Info.ClusterId, ring.Info.Name))
+	if os.IsNotExist(err) {
+		return estimate, nil
+	} else if err != nil {
+		return nil, err
+	}
+	report, err = ringDataReport(before, ring)
+	if err != nil {
+		return nil, err
+	}
+	estimate.BalanceBefore = report.Balance
+	estimate.DispersionBefore = report.Dispersion
+
+	estimate.PartsMoved = ringChangedParts(before, after)
+	estimate.ReplicasMoved = ringChangedReplicas(before, after)
+	estimate.BytesMoved = uint64(estimate.ReplicasMoved) * partitionSize
+
+	return estimate, nil
+}
+
+func ringDataReport(data *ringbuilder.RingData, ring *RingEntry) (*ringbuilder.Report, error) {
+	b, err := ringbuilder.NewRingBuilderFromRing(data, ring.Info.MinPartHours)
+	if err != nil {
+		return nil, err
+	}
+	return b.Report(), nil
+}
+
+// ringChangedReplicas returns the number of replicas of partitions on
+// another device in ring than in previous.  Replicas added to the ring
+// are copied to their device too.
+func ringChangedReplicas(previous, ring *ringbuilder.RingData) int {
+	moved := ringMovedReplicas(previous, ring)
+	changed := 0
+	for replica, part2dev := range ring.Replica2Part2Dev {
+		for part := range part2dev {
+			if moved(replica, part) {
+				changed++
+			}
+		}
+	}
+	return changed
+}
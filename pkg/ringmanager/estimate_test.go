@@ -0,0 +1,195 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringbuilder"
+)
+
+func TestBuildRingDryRun(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	ringId := setupRingWithParameters(t, id, "object", `"min_part_hours":0`)
+	for z := 1; z <= 3; z++ {
+		nodeId := setupNode(t, ringId, fmt.Sprintf("127.0.0.%d", z), z)
+		setupDevice(t, nodeId, "sdb1", 100)
+	}
+
+	// A ring never built has nothing to move, and is not built by the
+	// dry run
+	msg := runBuildWithQuery(t, id, "?dry_run=true")
+	assert.True(t, msg.DryRun)
+	assert.Equal(t, uint64(DEFAULT_PARTITION_SIZE), msg.PartitionSize)
+	assert.True(t, msg.Rings[0].Created)
+	assert.Equal(t, &RingMoveEstimate{}, msg.Rings[0].Estimate)
+	_, err := os.Stat(filepath.Join(ringManagerDir, id, "object.ring.gz"))
+	assert.True(t, os.IsNotExist(err))
+
+	msg = runBuild(t, id)
+	assert.False(t, msg.DryRun)
+	assert.Nil(t, msg.Rings[0].Estimate)
+
+	nodeId := setupNode(t, ringId, "127.0.0.4", 4)
+	deviceId := setupDevice(t, nodeId, "sdb1", 100)
+
+	files := make(map[string][]byte)
+	for _, name := range []string{"object.builder", "object.ring.gz"} {
+		files[name], err = ioutil.ReadFile(filepath.Join(ringManagerDir, id, name))
+		assert.Nil(t, err)
+	}
+
+	msg = runBuildWithQuery(t, id, "?dry_run=true&partition_size=1000")
+	assert.True(t, msg.DryRun)
+	assert.Equal(t, uint64(1000), msg.PartitionSize)
+	assert.False(t, msg.Rings[0].Created)
+	assert.Equal(t, []string{deviceId}, msg.Rings[0].DevicesAdded)
+	assert.Equal(t, 0, msg.Rings[0].Version)
+
+	// One replica of a partition moves per rebalance, a quarter of them
+	// to the new device
+	estimate := msg.Rings[0].Estimate
+	assert.Equal(t, estimate.PartsMoved, estimate.ReplicasMoved)
+	assert.Equal(t, 768, estimate.ReplicasMoved)
+	assert.Equal(t, uint64(768000), estimate.BytesMoved)
+	assert.Equal(t, 0.0, estimate.BalanceBefore)
+	assert.Equal(t, 0.0, estimate.BalanceAfter)
+	assert.Equal(t, 0.0, estimate.DispersionBefore)
+	assert.Equal(t, 0.0, estimate.DispersionAfter)
+
+	// Neither the files nor the db changed
+	for name, data := range files {
+		current, err := ioutil.ReadFile(filepath.Join(ringManagerDir, id, name))
+		assert.Nil(t, err)
+		assert.Equal(t, data, current, name)
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		ring, err := NewRingEntryFromId(tx, ringId)
+		if err != nil {
+			return err
+		}
+		assert.Equal(t, 1, ring.LastVersion)
+
+		device, err := NewDeviceEntryFromId(tx, deviceId)
+		if err != nil {
+			return err
+		}
+		assert.False(t, device.InBuilder)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	// The build does what the dry run said
+	msg = runBuild(t, id)
+	assert.Equal(t, []string{deviceId}, msg.Rings[0].DevicesAdded)
+	assert.Equal(t, estimate.PartsMoved, msg.Rings[0].ChangedParts)
+	assert.Equal(t, 2, msg.Rings[0].Version)
+}
+
+func TestBuildRingDryRunPartitionSize(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	setupTopology(t, id, "object", 3)
+
+	for _, size := range []string{"0", "-1", "1GB"} {
+		r, err := http.Post(ts.URL+"/buildring/"+id+"?dry_run=true&partition_size="+size,
+			"application/json", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, r.StatusCode, size)
+	}
+}
+
+func TestRingChangedReplicas(t *testing.T) {
+	dev := func(id int, ip string) *ringbuilder.Device {
+		return &ringbuilder.Device{Id: id, Ip: ip, Port: 6010, Device: "sdb1"}
+	}
+	previous := &ringbuilder.RingData{
+		Devs:             []*ringbuilder.Device{dev(0, "10.0.1.1"), dev(1, "10.0.2.1"), dev(2, "10.0.2.2")},
+		Replica2Part2Dev: [][]uint16{{0, 0}, {1, 2}},
+		PartShift:        31,
+	}
+
+	// A device added to the first component of a composite ring shifts
+	// the ids of the devices of the others
+	ring := &ringbuilder.RingData{
+		Devs: []*ringbuilder.Device{dev(0, "10.0.1.1"), dev(1, "10.0.1.2"),
+			dev(2, "10.0.2.1"), dev(3, "10.0.2.2")},
+		Replica2Part2Dev: [][]uint16{{0, 1}, {2, 3}},
+		PartShift:        31,
+	}
+	assert.Equal(t, 1, ringChangedReplicas(previous, ring))
+	assert.Equal(t, 1, ringChangedParts(previous, ring))
+
+	// A new replica is copied to its device
+	ring.Replica2Part2Dev = append(ring.Replica2Part2Dev, []uint16{3, 2})
+	assert.Equal(t, 3, ringChangedReplicas(previous, ring))
+	assert.Equal(t, 2, ringChangedParts(previous, ring))
+
+	// The last replica of a fractional ring only covers some partitions
+	fractional := &ringbuilder.RingData{
+		Devs:             previous.Devs,
+		Replica2Part2Dev: [][]uint16{{0, 0, 0, 0}, {1, 2, 1, 2}, {2, 1}},
+		PartShift:        30,
+	}
+	assert.Equal(t, 0, ringChangedReplicas(fractional, fractional))
+	assert.Equal(t, 0, ringChangedParts(fractional, fractional))
+
+	// It grows or shrinks with the replica count
+	grown := &ringbuilder.RingData{
+		Devs:             previous.Devs,
+		Replica2Part2Dev: [][]uint16{{0, 0, 0, 0}, {1, 2, 1, 2}, {2, 1, 2}},
+		PartShift:        30,
+	}
+	assert.Equal(t, 1, ringChangedReplicas(fractional, grown))
+	assert.Equal(t, 1, ringChangedParts(fractional, grown))
+	assert.Equal(t, 0, ringChangedReplicas(grown, fractional))
+	assert.Equal(t, 1, ringChangedParts(grown, fractional))
+}
+
+func TestBuildRingDryRunFractional(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	ringId := setupRingWithParameters(t, id, "object", `"replicas":2.5, "min_part_hours":1`)
+	for z := 1; z <= 3; z++ {
+		nodeId := setupNode(t, ringId, fmt.Sprintf("127.0.0.%d", z), z)
+		setupDevice(t, nodeId, "sdb1", 100)
+	}
+	runBuild(t, id)
+
+	// Every partition just moved, none can move again
+	nodeId := setupNode(t, ringId, "127.0.0.4", 4)
+	setupDevice(t, nodeId, "sdb1", 100)
+	msg := runBuildWithQuery(t, id, "?dry_run=true")
+	assert.Equal(t, 0, msg.Rings[0].Estimate.PartsMoved)
+	assert.Equal(t, 0, msg.Rings[0].Estimate.ReplicasMoved)
+}
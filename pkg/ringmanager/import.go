@@ -0,0 +1,388 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringbuilder"
+)
+
+// Uploads larger than this are kept in temporary files while parsed
+const importMaxMemory = 32 << 20
+
+// Largest file imported, before and after it is uncompressed
+var importMaxSize int64 = 256 << 20
+
+var errImportTooLarge = errors.New("Import file too large")
+
+// importNode is a node of an imported builder: the devices sharing the
+// same ip and port
+type importNode struct {
+	req  NodeAddRequest
+	devs []*ringbuilder.Device
+}
+
+// readImportFile returns the uncompressed file sent either as the body of
+// the request or as the "file" field of a form, with its file name
+func readImportFile(w http.ResponseWriter, r *http.Request) ([]byte, string, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, importMaxSize+importMaxMemory)
+
+	var data []byte
+	var filename string
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		err := r.ParseMultipartForm(importMaxMemory)
+		if err != nil {
+			return nil, "", err
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			return nil, "", err
+		}
+		defer file.Close()
+		filename = header.Filename
+		data, err = readImportLimited(file)
+		if err != nil {
+			return nil, "", err
+		}
+	} else {
+		var err error
+		data, err = readImportLimited(r.Body)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	// Rings are always gzipped, builders may have been to upload them
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, "", err
+		}
+		data, err = readImportLimited(gz)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return data, filename, nil
+}
+
+// readImportLimited reads at most importMaxSize bytes
+func readImportLimited(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, importMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > importMaxSize {
+		return nil, errImportTooLarge
+	}
+	return data, nil
+}
+
+// importRingName returns the name of the ring in a file name like
+// object-1.builder or object-1.ring.gz
+func importRingName(filename string) string {
+	name := filepath.Base(filepath.ToSlash(filename))
+	name = strings.TrimSuffix(name, ".gz")
+	name = strings.TrimSuffix(name, ".ring")
+	name = strings.TrimSuffix(name, ".builder")
+	if name == "." || name == "/" {
+		return ""
+	}
+	return name
+}
+
+// newImportNodes groups the devices of the builder by ip and port.  All
+// the devices of a node share its region, zone and replication address.
+func newImportNodes(b *ringbuilder.RingBuilder) ([]*importNode, error) {
+	nodes := make([]*importNode, 0)
+	byAddress := make(map[string]*importNode)
+	for _, dev := range b.Devices() {
+		req := NodeAddRequest{
+			Region:        dev.Region,
+			Zone:          dev.Zone,
+			Ip:            dev.Ip,
+			ReplicationIP: dev.ReplicationIp,
+			Port:          strconv.Itoa(dev.Port),
+		}
+		if dev.ReplicationPort != 0 {
+			req.ReplicationPort = strconv.Itoa(dev.ReplicationPort)
+		}
+
+		address := req.Ip + ":" + req.Port
+		node, ok := byAddress[address]
+		if !ok {
+			node = &importNode{req: req}
+			byAddress[address] = node
+			nodes = append(nodes, node)
+		} else if node.req != req {
+			return nil, fmt.Errorf("Devices %v and %v of node %v have a different region, zone or replication address",
+				node.devs[0].Id, dev.Id, address)
+		}
+		node.devs = append(node.devs, dev)
+	}
+
+	return nodes, nil
+}
+
+// importWeight returns the weight of the device in the db.  Builders have
+// fractional weights, which the next build rounds.
+func importWeight(weight float64) uint64 {
+	return uint64(math.Floor(weight + 0.5))
+}
+
+// importRing saves the ring, nodes and devices of the imported builder in
+// the cluster and returns the ring and the ids of its devices.  Its files
+// are written next, by the backend.
+func importRing(tx *bolt.Tx, cluster *ClusterEntry, name, ringType string, policy *StoragePolicy,
+	b *ringbuilder.RingBuilder, nodes []*importNode) (*RingEntry, []string, error) {
+
+	ring := NewRingEntryFromRequest(&RingAddRequest{
+		ClusterId:    cluster.Info.Id,
+		Name:         name,
+		PartPower:    b.PartPower,
+		Replicas:     b.Replicas,
+		MinPartHours: b.MinPartHours,
+		Type:         ringType,
+		Policy:       policy,
+	})
+	err := ring.Register(tx)
+	if err != nil {
+		return nil, nil, &topologyError{status: http.StatusConflict, msg: err.Error()}
+	}
+	if policy != nil {
+		policies, err := clusterPolicies(tx, cluster, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		err = validatePolicies(append(policies, policy))
+		if err != nil {
+			return nil, nil, &topologyError{status: http.StatusConflict, msg: err.Error()}
+		}
+	}
+	cluster.RingAdd(ring.Info.Id)
+
+	devices := make([]string, 0)
+	for _, in := range nodes {
+		req := in.req
+		req.RingId = ring.Info.Id
+		node := NewNodeEntryFromRequest(&req)
+		err = node.Register(tx)
+		if err != nil {
+			return nil, nil, &topologyError{status: http.StatusConflict, msg: err.Error()}
+		}
+		ring.NodeAdd(node.Info.Id)
+
+		for _, dev := range in.devs {
+			deviceReq := &DeviceAddRequest{NodeId: node.Info.Id, Weight: importWeight(dev.Weight)}
+			deviceReq.Name = dev.Device
+			deviceReq.Meta = dev.Meta
+			device := NewDeviceEntryFromRequest(deviceReq)
+			err = device.Register(tx)
+			if err != nil {
+				return nil, nil, &topologyError{status: http.StatusConflict, msg: err.Error()}
+			}
+
+			// The device is already in the builder with its weight
+			device.Info.Weight.Current = device.Info.Weight.Target
+			device.BuilderId = dev.Id
+			device.InBuilder = true
+			err = device.Save(tx)
+			if err != nil {
+				return nil, nil, err
+			}
+			node.DeviceAdd(device.Info.Id)
+			devices = append(devices, device.Info.Id)
+		}
+
+		err = node.Save(tx)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = cluster.Save(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ring, devices, ring.Save(tx)
+}
+
+// ClusterImport adds a ring to the cluster from a swift builder or ring
+// file, so that the manager takes over rings built by hand.  The builder
+// is kept as is: the next build only moves the partitions of the devices
+// changed in the manager.
+func ClusterImport(w http.ResponseWriter, r *http.Request) {
+	// Get the id from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	data, filename, err := readImportFile(w, r)
+	if err == errImportTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, "request unable to be parsed", 422)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = importRingName(filename)
+	}
+	if name == "" {
+		http.Error(w, "Ring name missing", http.StatusBadRequest)
+		return
+	}
+
+	// Rings do not keep min_part_hours
+	minPartHours := RING_DEFAULT_MIN_PART_HOURS
+	if s := r.URL.Query().Get("min_part_hours"); s != "" {
+		minPartHours, err = strconv.Atoi(s)
+		if err != nil || minPartHours < 0 {
+			http.Error(w, ringbuilder.ErrMinPartHours.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ringType, policy, err := newRingPolicy(name, "", nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	builder, isRing, err := ringbuilder.ImportBuilder(data, minPartHours)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	nodes, err := newImportNodes(builder)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Builds must not see the ring before its files are written
+	buildLock.Lock()
+	defer buildLock.Unlock()
+
+	var info *RingImportResponse
+	err = db.Update(func(tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		ring, devices, err := importRing(tx, cluster, name, ringType, policy, builder, nodes)
+		if e, ok := err.(*topologyError); ok {
+			http.Error(w, e.msg, e.status)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		err = importRingFiles(tx, ring, builder, data, isRing, r.URL.Query().Get("author"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		ringInfo, err := ring.NewInfoResponse()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+		info = &RingImportResponse{RingInfoResponse: *ringInfo, Devices: devices}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// Send back we created it (as long as we did not fail)
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		panic(err)
+	}
+}
+
+// importRingFiles writes the builder and ring files of the imported ring.
+// A builder already rebalanced is kept as the first version of the ring,
+// as if the manager had built it.
+func importRingFiles(tx *bolt.Tx, ring *RingEntry, builder *ringbuilder.RingBuilder,
+	data []byte, isRing bool, author string) error {
+
+	err := os.MkdirAll(filepath.Join(ringManagerDir, ring.Info.ClusterId), 0774)
+	if err != nil {
+		return err
+	}
+	path := ringBuilderPath(ring.Info.ClusterId, ring.Info.Name)
+	err = builderBackend.Import(path, builder, data, isRing)
+	if err == nil && builder.Replica2Part2Dev != nil {
+		err = importRingVersion(tx, ring, builder, author)
+	}
+	if err != nil {
+		// Builds would otherwise find the files of a ring never added
+		os.Remove(path)
+		os.Remove(ringFilePath(ring.Info.ClusterId, ring.Info.Name))
+		return err
+	}
+
+	return nil
+}
+
+func importRingVersion(tx *bolt.Tx, ring *RingEntry, builder *ringbuilder.RingBuilder, author string) error {
+	topology, err := newRingTopology(tx, ring.Info.Id)
+	if err != nil {
+		return err
+	}
+	version, err := newRingVersion(filepath.Join(ringManagerDir, ring.Info.ClusterId), topology,
+		&RingBuildResult{Balance: builder.Balance()}, author)
+	if err != nil {
+		return err
+	}
+
+	ring.Version = version.Info.Version
+	ring.LastVersion = version.Info.Version
+	err = ring.Save(tx)
+	if err != nil {
+		return err
+	}
+	return version.Save(tx)
+}
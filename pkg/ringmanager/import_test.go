@@ -0,0 +1,228 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringbuilder"
+)
+
+// The builder and ring fixtures of the ringbuilder package, as written
+// by swift
+const importTestdata = "../ringbuilder/testdata"
+
+func importFile(t *testing.T, id, filename, query string) *http.Response {
+	data, err := ioutil.ReadFile(filepath.Join(importTestdata, filename))
+	assert.Nil(t, err)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filename)
+	assert.Nil(t, err)
+	_, err = part.Write(data)
+	assert.Nil(t, err)
+	assert.Nil(t, form.Close())
+
+	r, err := http.Post(ts.URL+"/clusters/"+id+"/import"+query, form.FormDataContentType(), &body)
+	assert.Nil(t, err)
+	return r
+}
+
+func TestClusterImportBuilder(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	r := importFile(t, id, "object.builder", "?author=alice")
+	assert.Equal(t, http.StatusCreated, r.StatusCode)
+
+	var msg RingImportResponse
+	err := GetJsonFromResponse(r, &msg)
+	assert.Nil(t, err)
+	assert.Equal(t, "object", msg.Name)
+	assert.Equal(t, 4, msg.PartPower)
+	assert.Equal(t, 3.0, msg.Replicas)
+	assert.Equal(t, 1, msg.MinPartHours)
+	assert.Equal(t, 1, msg.Version)
+	assert.Equal(t, 3, len(msg.Nodes))
+	assert.Equal(t, 3, len(msg.Devices))
+
+	// Devices keep their builder id and weight
+	var device *DeviceEntry
+	err = db.View(func(tx *bolt.Tx) error {
+		var err error
+		device, err = NewDeviceEntryFromId(tx, msg.Devices[2])
+		return err
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "sdc", device.Info.Name)
+	assert.Equal(t, "fixture", device.Info.Meta)
+	assert.Equal(t, 3, device.BuilderId)
+	assert.True(t, device.InBuilder)
+	assert.Equal(t, DeviceWeight{Current: 100, Target: 100}, device.Info.Weight)
+
+	// The ring served is the one swift wrote
+	ring, err := ringbuilder.LoadRingData(filepath.Join(importTestdata, "object.ring.gz"))
+	assert.Nil(t, err)
+	imported, err := ringbuilder.LoadRingData(filepath.Join(ringManagerDir, id, "object.ring.gz"))
+	assert.Nil(t, err)
+	assert.Equal(t, ring, imported)
+
+	r, err = http.Get(ts.URL + "/rings/" + msg.Id + "/versions/1")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, r.StatusCode)
+	var version RingVersionInfo
+	err = GetJsonFromResponse(r, &version)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", version.Author)
+	assert.Equal(t, 3, len(version.Devices))
+
+	// The next build goes on from the imported builder, which already
+	// matches the topology
+	result := runBuild(t, id)
+	assert.Equal(t, 1, result.Rings[0].Version)
+	assert.True(t, result.Rings[0].Unchanged)
+	assert.False(t, result.Rings[0].Created)
+	assert.Zero(t, result.Rings[0].ChangedParts)
+	assert.Empty(t, result.Rings[0].DevicesAdded)
+}
+
+func TestClusterImportRing(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	data, err := ioutil.ReadFile(filepath.Join(importTestdata, "fractional.ring.gz"))
+	assert.Nil(t, err)
+	r, err := http.Post(ts.URL+"/clusters/"+id+"/import?name=object-1&min_part_hours=2",
+		"application/octet-stream", bytes.NewReader(data))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, r.StatusCode)
+
+	var msg RingImportResponse
+	err = GetJsonFromResponse(r, &msg)
+	assert.Nil(t, err)
+	assert.Equal(t, "object-1", msg.Name)
+	assert.Equal(t, 2.5, msg.Replicas)
+	assert.Equal(t, 2, msg.MinPartHours)
+	assert.Equal(t, 3, len(msg.Devices))
+
+	builder, err := ringbuilder.Load(filepath.Join(ringManagerDir, id, "object-1.builder"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, builder.MinPartHours)
+
+	// The ring is already known to the cluster
+	r, err = http.Post(ts.URL+"/clusters/"+id+"/import?name=object-1",
+		"application/octet-stream", bytes.NewReader(data))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusConflict, r.StatusCode)
+}
+
+func TestClusterImportInvalid(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	// Not a builder
+	r, err := http.Post(ts.URL+"/clusters/"+id+"/import?name=object",
+		"application/octet-stream", bytes.NewBufferString("garbage"))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, r.StatusCode)
+
+	// No name in the query nor a file name
+	data, err := ioutil.ReadFile(filepath.Join(importTestdata, "object.builder"))
+	assert.Nil(t, err)
+	r, err = http.Post(ts.URL+"/clusters/"+id+"/import",
+		"application/octet-stream", bytes.NewReader(data))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, r.StatusCode)
+
+	r = importFile(t, "123", "object.builder", "")
+	assert.Equal(t, http.StatusNotFound, r.StatusCode)
+
+	// A ring whose device is not at the index of its id leaves nothing
+	// behind
+	ring, err := ringbuilder.LoadRingData(filepath.Join(importTestdata, "object.ring.gz"))
+	assert.Nil(t, err)
+	ring.Devs[1].Id = 5
+	var broken bytes.Buffer
+	assert.Nil(t, ring.Serialize(&broken))
+	r, err = http.Post(ts.URL+"/clusters/"+id+"/import?name=object",
+		"application/octet-stream", &broken)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, r.StatusCode)
+	_, err = os.Stat(filepath.Join(ringManagerDir, id, "object.builder"))
+	assert.True(t, os.IsNotExist(err))
+
+	// A small gzip file too large once uncompressed
+	importMaxSize = 1 << 20
+	defer func() { importMaxSize = 256 << 20 }()
+	var bomb bytes.Buffer
+	gz := gzip.NewWriter(&bomb)
+	_, err = gz.Write(make([]byte, 2<<20))
+	assert.Nil(t, err)
+	assert.Nil(t, gz.Close())
+	r, err = http.Post(ts.URL+"/clusters/"+id+"/import?name=object",
+		"application/octet-stream", &bomb)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, r.StatusCode)
+}
+
+func TestImportRingName(t *testing.T) {
+	assert.Equal(t, "object", importRingName("object.builder"))
+	assert.Equal(t, "object-1", importRingName("/etc/swift/object-1.ring.gz"))
+	assert.Equal(t, "", importRingName(""))
+}
+
+func TestNewImportNodes(t *testing.T) {
+	builder, err := ringbuilder.NewRingBuilder(4, 3, 1)
+	assert.Nil(t, err)
+	for _, dev := range []*ringbuilder.Device{
+		{Region: 1, Zone: 1, Ip: "10.0.0.1", Port: 6200, Device: "sdb", Weight: 100},
+		{Region: 1, Zone: 2, Ip: "10.0.0.2", Port: 6200, Device: "sdb", Weight: 100},
+		{Region: 1, Zone: 1, Ip: "10.0.0.1", Port: 6200, Device: "sdc", Weight: 100},
+	} {
+		_, err = builder.AddDev(dev)
+		assert.Nil(t, err)
+	}
+
+	nodes, err := newImportNodes(builder)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(nodes))
+	assert.Equal(t, "6200", nodes[0].req.Port)
+	assert.Equal(t, 2, len(nodes[0].devs))
+	assert.Equal(t, 1, len(nodes[1].devs))
+
+	// Devices of a node can not be in different zones
+	_, err = builder.AddDev(&ringbuilder.Device{
+		Region: 1, Zone: 3, Ip: "10.0.0.2", Port: 6200, Device: "sdc", Weight: 100})
+	assert.Nil(t, err)
+	_, err = newImportNodes(builder)
+	assert.NotNil(t, err)
+}
@@ -17,7 +17,10 @@ package ringmanager
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
 
 	"github.com/boltdb/bolt"
 	"github.com/gorilla/mux"
@@ -61,6 +64,11 @@ func NodeAdd(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return err
 		}
+		if ring.IsComposite() {
+			err = fmt.Errorf("Ring %v is composite, its nodes belong to its components", ring.Info.Name)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		}
 
 		// Register node
 		err = node.Register(tx)
@@ -124,6 +132,100 @@ func NodeInformation(w http.ResponseWriter, r *http.Request) {
 
 }
 
+func NodeUpdate(w http.ResponseWriter, r *http.Request) {
+	// Get node id from URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var msg NodeUpdateRequest
+	err := GetJsonFromRequest(r, &msg)
+	if err != nil {
+		http.Error(w, "request unable to be parsed", 422)
+		return
+	}
+
+	// check information in JSON request
+	if msg.Region != nil && *msg.Region < 1 {
+		http.Error(w, "Region must be at least 1", http.StatusBadRequest)
+		return
+	}
+
+	if msg.Zone != nil && *msg.Zone < 1 {
+		http.Error(w, "Zone must be at least 1", http.StatusBadRequest)
+		return
+	}
+
+	if (msg.Ip != nil && len(*msg.Ip) == 0) ||
+		(msg.ReplicationIP != nil && len(*msg.ReplicationIP) == 0) {
+		http.Error(w, "Ip missing", http.StatusBadRequest)
+		return
+	}
+
+	if (msg.Port != nil && !isValidPort(*msg.Port)) ||
+		(msg.ReplicationPort != nil && !isValidPort(*msg.ReplicationPort)) {
+		http.Error(w, "Port must be a number between 1 and 65535", http.StatusBadRequest)
+		return
+	}
+
+	// The changes reach the builder on the next build, the device keeps
+	// its partitions
+	var info *NodeInfoResponse
+	err = db.Update(func(tx *bolt.Tx) error {
+		node, err := NewNodeEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		err = node.Deregister(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		node.Update(&msg)
+
+		err = node.Register(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return err
+		}
+
+		err = node.Save(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		info, err = node.NewInfoResponse()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// Write msg
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		panic(err)
+	}
+}
+
+// isValidPort checks the port can be given to the ring builder
+func isValidPort(port string) bool {
+	value, err := strconv.Atoi(port)
+	return err == nil && value > 0 && value <= 65535
+}
+
 func getNodeInfo(id string) (*NodeInfoResponse, error) {
 	var info *NodeInfoResponse
 	err := db.View(func(tx *bolt.Tx) error {
@@ -140,6 +242,13 @@ func getNodeInfo(id string) (*NodeInfoResponse, error) {
 			return err
 		}
 
+		if entry.Draining {
+			info.Drain, err = getNodeDrainStatus(tx, entry)
+			if err != nil {
+				return err
+			}
+		}
+
 		return nil
 	})
 	if err != nil {
@@ -148,11 +257,160 @@ func getNodeInfo(id string) (*NodeInfoResponse, error) {
 	return info, nil
 }
 
-func NodeDelete(w http.ResponseWriter, r *http.Request) {
-	// TODO
+// getNodeDrainStatus returns how much of the node is left to drain
+func getNodeDrainStatus(tx *bolt.Tx, node *NodeEntry) (*NodeDrainStatus, error) {
+	ring, err := NewRingEntryFromId(tx, node.Info.RingId)
+	if err != nil {
+		return nil, err
+	}
+
+	parts, err := ringPartsByDevice(ring)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &NodeDrainStatus{Devices: len(node.Devices)}
+	for _, deviceId := range node.Devices {
+		device, err := NewDeviceEntryFromId(tx, deviceId)
+		if err != nil {
+			return nil, err
+		}
+		status.Weight += device.Info.Weight.Current
+		if device.InBuilder {
+			status.Parts += parts[device.BuilderId]
+		}
+	}
+
+	return status, nil
+}
+
+func NodeDrain(w http.ResponseWriter, r *http.Request) {
+	// Get node id from URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// The builds step the weights down and delete the empty devices
+	err := db.Update(func(tx *bolt.Tx) error {
+		node, err := NewNodeEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		for _, deviceId := range node.Devices {
+			device, err := NewDeviceEntryFromId(tx, deviceId)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return err
+			}
+
+			device.Info.Weight.Target = 0
+			err = device.Save(tx)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return err
+			}
+		}
+
+		node.Draining = true
+		err = node.Save(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	info, err := getNodeInfo(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write msg
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.WriteHeader(http.StatusNotFound)
-	if err := json.NewEncoder(w).Encode(jsonErr{Code: http.StatusNotFound, Text: "Not Implemented yet"}); err != nil {
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(info); err != nil {
 		panic(err)
 	}
 }
+
+func NodeDelete(w http.ResponseWriter, r *http.Request) {
+	// Get the id from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// Devices holding partitions are only deleted when forced
+	force := GetBoolFromQuery(r, "force")
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		node, err := NewNodeEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		ring, err := NewRingEntryFromId(tx, node.Info.RingId)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		parts, err := ringPartsByDevice(ring)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		// Delete the devices of the node first, they are removed from
+		// the builder by the next build
+		for _, deviceId := range append(sort.StringSlice{}, node.Devices...) {
+			device, err := NewDeviceEntryFromId(tx, deviceId)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return err
+			}
+
+			err = deviceDelete(tx, node, device, parts, force)
+			if err == ErrConflict {
+				http.Error(w, device.ConflictString(parts[device.BuilderId]), http.StatusConflict)
+				return err
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return err
+			}
+		}
+
+		err = node.Delete(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		// remove node from ring
+		ring.NodeDelete(node.Info.Id)
+		err = ring.Save(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// Write msg
+	w.WriteHeader(http.StatusOK)
+}
@@ -30,6 +30,13 @@ type NodeEntry struct {
 
 	Info    NodeInfo
 	Devices sort.StringSlice
+
+	// The weight of all the devices is going down to 0, and the devices
+	// are deleted once they hold no partitions
+	Draining bool
+
+	// Ring last fetched and installed by the node
+	Deployment NodeDeployment
 }
 
 func NewNodeEntry() *NodeEntry {
@@ -87,8 +94,10 @@ func NewNodeEntryFromId(tx *bolt.Tx, id string) (*NodeEntry, error) {
 	return entry, nil
 }
 
+// The address of a node is unique within its ring, the builder would
+// otherwise hold two nodes behind the same storage server
 func (n *NodeEntry) registerKey() string {
-	return "NODE" + n.Info.RingId + n.Info.Id
+	return "NODE" + n.Info.RingId + n.Info.Ip + ":" + n.Info.Port
 }
 
 func (n *NodeEntry) Register(tx *bolt.Tx) error {
@@ -111,8 +120,8 @@ func (n *NodeEntry) Register(tx *bolt.Tx) error {
 		}
 
 		// Return that we found a conflict
-		return fmt.Errorf("Node %v already used by ring with id %v\n",
-			n.Info.Ip, conflictId)
+		return fmt.Errorf("Address %v:%v already used by node %v in the ring",
+			n.Info.Ip, n.Info.Port, conflictId)
 	} else if err != nil {
 		return err
 	}
@@ -120,6 +129,34 @@ func (n *NodeEntry) Register(tx *bolt.Tx) error {
 	return nil
 
 }
+
+func (n *NodeEntry) Deregister(tx *bolt.Tx) error {
+	godbc.Require(tx != nil)
+
+	err := EntryDelete(tx, n, n.registerKey())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (n *NodeEntry) Delete(tx *bolt.Tx) error {
+	godbc.Require(tx != nil)
+
+	// Check if the node still has devices
+	if len(n.Devices) > 0 {
+		return ErrConflict
+	}
+
+	err := n.Deregister(tx)
+	if err != nil {
+		return err
+	}
+
+	return EntryDelete(tx, n, n.Info.Id)
+}
+
 func (n *NodeEntry) BucketName() string {
 	return BOLTDB_BUCKET_NODE
 }
@@ -171,6 +208,43 @@ func (n *NodeEntry) NewInfoResponse() (*NodeInfoResponse, error) {
 	return info, nil
 }
 
+// Update changes the node to the values set in the request.  The
+// replication address keeps following the address if it was the same.
+func (n *NodeEntry) Update(req *NodeUpdateRequest) {
+	godbc.Require(req != nil)
+
+	if req.Region != nil {
+		n.Info.Region = *req.Region
+	}
+	if req.Zone != nil {
+		n.Info.Zone = *req.Zone
+	}
+	if req.Ip != nil {
+		if req.ReplicationIP == nil && n.Info.ReplicationIP == n.Info.Ip {
+			n.Info.ReplicationIP = *req.Ip
+		}
+		n.Info.Ip = *req.Ip
+	}
+	if req.ReplicationIP != nil {
+		n.Info.ReplicationIP = *req.ReplicationIP
+	}
+	if req.Port != nil {
+		if req.ReplicationPort == nil && n.Info.ReplicationPort == n.Info.Port {
+			n.Info.ReplicationPort = *req.Port
+		}
+		n.Info.Port = *req.Port
+	}
+	if req.ReplicationPort != nil {
+		n.Info.ReplicationPort = *req.ReplicationPort
+	}
+}
+
+// HasAddress tells if addr, as reported by the agent of a storage node,
+// is the ip, the replication ip or the id of the node
+func (n *NodeEntry) HasAddress(addr string) bool {
+	return addr == n.Info.Ip || addr == n.Info.ReplicationIP || addr == n.Info.Id
+}
+
 func (n *NodeEntry) DeviceAdd(id string) {
 	godbc.Require(!SortedStringHas(n.Devices, id))
 
@@ -0,0 +1,341 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringbuilder"
+)
+
+func TestNodeDelete(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	ringId := setupRing(t, id, "object")
+	nodeId := setupNode(t, ringId, "127.0.0.1", 1)
+	deviceId := setupDevice(t, nodeId, "sdb1", 100)
+
+	res := httpDelete(t, ts.URL+"/nodes/"+nodeId)
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+
+	r, err := http.Get(ts.URL + "/nodes/" + nodeId)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusNotFound)
+
+	r, err = http.Get(ts.URL + "/devices/" + deviceId)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusNotFound)
+
+	r, err = http.Get(ts.URL + "/rings/" + ringId)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+
+	var msg RingInfoResponse
+	err = GetJsonFromResponse(r, &msg)
+	assert.Nil(t, err)
+	assert.Empty(t, msg.Nodes)
+
+	// The register key is released
+	setupNode(t, ringId, "127.0.0.1", 1)
+}
+
+func TestNodeDeleteIdNotFound(t *testing.T) {
+
+	// setup and teardown test case
+	_, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	res := httpDelete(t, ts.URL+"/nodes/12345")
+	assert.Equal(t, res.StatusCode, http.StatusNotFound)
+}
+
+func TestNodeDeleteHoldsPartitions(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	ringId := setupTopology(t, id, "object", 4)
+
+	runBuild(t, id)
+
+	var nodeId string
+	err := db.View(func(tx *bolt.Tx) error {
+		ring, err := NewRingEntryFromId(tx, ringId)
+		if err != nil {
+			return err
+		}
+		nodeId = ring.Nodes[0]
+		return nil
+	})
+	assert.Nil(t, err)
+
+	res := httpDelete(t, ts.URL+"/nodes/"+nodeId)
+	assert.Equal(t, res.StatusCode, http.StatusConflict)
+
+	// Nothing was deleted
+	r, err := http.Get(ts.URL + "/nodes/" + nodeId)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+
+	var node NodeInfoResponse
+	err = GetJsonFromResponse(r, &node)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(node.Devices))
+
+	res = httpDelete(t, ts.URL+"/nodes/"+nodeId+"?force=true")
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+
+	msg := runBuild(t, id)
+	assert.Equal(t, 1, len(msg.Rings[0].DevicesRemoved))
+}
+
+func TestNodeUpdate(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	ringId := setupTopology(t, id, "object", 3)
+
+	runBuild(t, id)
+
+	var ring RingInfoResponse
+	r, err := http.Get(ts.URL + "/rings/" + ringId)
+	assert.Nil(t, err)
+	err = GetJsonFromResponse(r, &ring)
+	assert.Nil(t, err)
+	nodeId := ring.Nodes[0]
+
+	res := httpPatch(t, ts.URL+"/nodes/"+nodeId, `{"ip":"10.0.0.1", "port":"6200"}`)
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+
+	var node NodeInfoResponse
+	err = GetJsonFromResponse(res, &node)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.1", node.Ip)
+	assert.Equal(t, "6200", node.Port)
+
+	// The replication address followed
+	assert.Equal(t, "10.0.0.1", node.ReplicationIP)
+	assert.Equal(t, "6200", node.ReplicationPort)
+
+	// The device is updated in place by the next build
+	msg := runBuild(t, id)
+	assert.Equal(t, node.Devices, sort.StringSlice(msg.Rings[0].DevicesUpdated))
+	assert.Empty(t, msg.Rings[0].DevicesAdded)
+	assert.Empty(t, msg.Rings[0].DevicesRemoved)
+	assert.Zero(t, msg.Rings[0].ChangedParts)
+
+	builder, err := ringbuilder.Load(filepath.Join(ringManagerDir, id, "object.builder"))
+	assert.Nil(t, err)
+	found := false
+	for _, d := range builder.Devices() {
+		if d.Ip == "10.0.0.1" {
+			assert.Equal(t, 6200, d.Port)
+			found = true
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestNodeUpdateInvalid(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	ringId := setupRing(t, id, "object")
+	nodeId := setupNode(t, ringId, "127.0.0.1", 1)
+
+	for _, body := range []string{
+		`{"zone":0}`,
+		`{"region":-1}`,
+		`{"ip":""}`,
+		`{"port":"abc"}`,
+		`{"replicationPort":"70000"}`,
+	} {
+		res := httpPatch(t, ts.URL+"/nodes/"+nodeId, body)
+		assert.Equal(t, res.StatusCode, http.StatusBadRequest, body)
+	}
+
+	res := httpPatch(t, ts.URL+"/nodes/12345", `{"zone":2}`)
+	assert.Equal(t, res.StatusCode, http.StatusNotFound)
+}
+
+func TestNodeAddressConflict(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	ringId := setupRing(t, id, "object")
+	setupNode(t, ringId, "127.0.0.1", 1)
+	nodeId := setupNode(t, ringId, "127.0.0.2", 2)
+
+	// Another node of the ring already listens on the address
+	body := []byte(fmt.Sprintf(`{"ring":"%v", "ip":"127.0.0.1", "port":"6010", "zone":3}`, ringId))
+	r, err := http.Post(ts.URL+"/nodes", "application/json", bytes.NewBuffer(body))
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusConflict)
+
+	res := httpPatch(t, ts.URL+"/nodes/"+nodeId, `{"ip":"127.0.0.1"}`)
+	assert.Equal(t, res.StatusCode, http.StatusConflict)
+	assert.Equal(t, "127.0.0.2", getNode(t, nodeId).Ip)
+
+	// Another port of the same server is a different node
+	res = httpPatch(t, ts.URL+"/nodes/"+nodeId, `{"ip":"127.0.0.1", "port":"6020"}`)
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+
+	// The old address is free again
+	setupNode(t, ringId, "127.0.0.2", 3)
+
+	// The same server can be part of another ring
+	other := setupRing(t, id, "container")
+	setupNode(t, other, "127.0.0.1", 1)
+}
+
+func drainNode(t *testing.T, nodeId string) *NodeInfoResponse {
+	r, err := http.Post(ts.URL+"/nodes/"+nodeId+"/drain", "application/json", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+
+	var node NodeInfoResponse
+	err = GetJsonFromResponse(r, &node)
+	assert.Nil(t, err)
+	return &node
+}
+
+func getNode(t *testing.T, nodeId string) *NodeInfoResponse {
+	r, err := http.Get(ts.URL + "/nodes/" + nodeId)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+
+	var node NodeInfoResponse
+	err = GetJsonFromResponse(r, &node)
+	assert.Nil(t, err)
+	return &node
+}
+
+func TestNodeDrain(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	deviceWeightStep = &weightStep{value: 50}
+	defer func() { deviceWeightStep = nil }()
+
+	ringId := setupRingWithParameters(t, id, "object", `"min_part_hours":0`)
+	var nodeId string
+	for z := 1; z <= 4; z++ {
+		nodeId = setupNode(t, ringId, fmt.Sprintf("127.0.0.%d", z), z)
+		setupDevice(t, nodeId, "sdb1", 100)
+	}
+
+	runBuild(t, id)
+
+	node := drainNode(t, nodeId)
+	assert.Equal(t, 1, len(node.Devices))
+	assert.Equal(t, 1, node.Drain.Devices)
+	assert.Equal(t, uint64(100), node.Drain.Weight)
+	assert.True(t, node.Drain.Parts > 0)
+	deviceId := node.Devices[0]
+
+	// The weight goes down one step per build
+	var msg *BuildRingResponse
+	for _, weight := range []uint64{50, 0} {
+		msg = runBuild(t, id)
+
+		node = getNode(t, nodeId)
+		assert.Equal(t, weight, node.Drain.Weight)
+	}
+
+	// and the device is deleted once empty
+	for i := 0; i < 5 && len(msg.Rings[0].DevicesDrained) == 0; i++ {
+		msg = runBuild(t, id)
+	}
+	assert.Equal(t, []string{deviceId}, msg.Rings[0].DevicesDrained)
+
+	node = getNode(t, nodeId)
+	assert.Empty(t, node.Devices)
+	assert.Equal(t, &NodeDrainStatus{}, node.Drain)
+
+	r, err := http.Get(ts.URL + "/devices/" + deviceId)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusNotFound)
+
+	// The next build removes it from the builder
+	msg = runBuild(t, id)
+	assert.Equal(t, 1, len(msg.Rings[0].DevicesRemoved))
+}
+
+func TestNodeDrainMinPartHours(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	deviceWeightStep = &weightStep{value: 50}
+	defer func() { deviceWeightStep = nil }()
+
+	ringId := setupTopology(t, id, "object", 4)
+
+	runBuild(t, id)
+
+	var ring RingInfoResponse
+	r, err := http.Get(ts.URL + "/rings/" + ringId)
+	assert.Nil(t, err)
+	err = GetJsonFromResponse(r, &ring)
+	assert.Nil(t, err)
+	nodeId := ring.Nodes[0]
+	drainNode(t, nodeId)
+
+	// The builder was just created, so the first step has to wait for
+	// min_part_hours too
+	runBuild(t, id)
+	assert.Equal(t, uint64(100), getNode(t, nodeId).Drain.Weight)
+
+	// Pretend the last weight change was long ago
+	err = db.Update(func(tx *bolt.Tx) error {
+		node, err := NewNodeEntryFromId(tx, nodeId)
+		if err != nil {
+			return err
+		}
+		device, err := NewDeviceEntryFromId(tx, node.Devices[0])
+		if err != nil {
+			return err
+		}
+		device.WeightChangedAt = 0
+		return device.Save(tx)
+	})
+	assert.Nil(t, err)
+
+	for i := 0; i < 2; i++ {
+		runBuild(t, id)
+		assert.Equal(t, uint64(50), getNode(t, nodeId).Drain.Weight)
+	}
+}
@@ -0,0 +1,387 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+)
+
+const (
+	RING_TYPE_ACCOUNT   = "account"
+	RING_TYPE_CONTAINER = "container"
+	RING_TYPE_OBJECT    = "object"
+
+	// A component ring is only built to be composed into a composite
+	// ring, swift never reads it
+	RING_TYPE_COMPONENT = "component"
+
+	POLICY_TYPE_REPLICATION    = "replication"
+	POLICY_TYPE_ERASURE_CODING = "erasure_coding"
+
+	// Default of swift for ec_object_segment_size
+	POLICY_DEFAULT_EC_SEGMENT_SIZE = 1048576
+
+	// Swift names policy 0 like this when swift.conf has no policies
+	POLICY_LEGACY_NAME = "Policy-0"
+)
+
+// The ec_type backends of PyECLib
+var ecTypes = map[string]bool{
+	"liberasurecode_rs_vand": true,
+	"jerasure_rs_vand":       true,
+	"jerasure_rs_cauchy":     true,
+	"flat_xor_hd_3":          true,
+	"flat_xor_hd_4":          true,
+	"isa_l_rs_vand":          true,
+	"isa_l_rs_cauchy":        true,
+	"shss":                   true,
+	"libphazr":               true,
+}
+
+var (
+	// object is the ring of policy 0 and object-N the one of policy N
+	objectRingRegexp = regexp.MustCompile(`^object(-([1-9][0-9]*))?$`)
+
+	// The characters swift allows in policy names and aliases
+	policyNameRegexp = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+	// Component rings name their files
+	componentNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+)
+
+// ringNameType returns the type of the ring saved in the swift file of
+// the name, and the index of the storage policy of object rings
+func ringNameType(name string) (string, int, bool) {
+	switch name {
+	case RING_TYPE_ACCOUNT, RING_TYPE_CONTAINER:
+		return name, 0, true
+	}
+
+	match := objectRingRegexp.FindStringSubmatch(name)
+	if match == nil {
+		return "", 0, false
+	}
+	index := 0
+	if match[2] != "" {
+		var err error
+		index, err = strconv.Atoi(match[2])
+		if err != nil {
+			return "", 0, false
+		}
+	}
+	return RING_TYPE_OBJECT, index, true
+}
+
+// newRingPolicy checks the type and storage policy of the ring match its
+// name and returns them with their defaults filled in.  The policy given
+// is not modified.
+func newRingPolicy(name, ringType string, policy *StoragePolicy) (string, *StoragePolicy, error) {
+	nameType, index, ok := ringNameType(name)
+	if ringType == RING_TYPE_COMPONENT {
+		if ok || !componentNameRegexp.MatchString(name) {
+			return "", nil, fmt.Errorf("Component ring name %v must be made of letters, digits, '.', '_' and '-' "+
+				"and not be the name of a swift ring", name)
+		}
+		if policy != nil {
+			return "", nil, fmt.Errorf("Ring %v: only object rings have a storage policy", name)
+		}
+		return ringType, nil, nil
+	}
+	if !ok {
+		return "", nil, fmt.Errorf("Ring name %v must be account, container, object or object-<policy index>",
+			name)
+	}
+	if ringType == "" {
+		ringType = nameType
+	} else if ringType != nameType {
+		return "", nil, fmt.Errorf("Ring %v must be of type %v, not %v", name, nameType, ringType)
+	}
+
+	if ringType != RING_TYPE_OBJECT {
+		if policy != nil {
+			return "", nil, fmt.Errorf("Ring %v: only object rings have a storage policy", name)
+		}
+		return ringType, nil, nil
+	}
+
+	p := &StoragePolicy{Index: index}
+	if policy != nil {
+		if policy.Index != index {
+			return "", nil, fmt.Errorf("Ring %v holds storage policy %v, not %v", name, index, policy.Index)
+		}
+		*p = *policy
+		p.Aliases = append([]string(nil), policy.Aliases...)
+	}
+	if p.Name == "" {
+		p.Name = fmt.Sprintf("Policy-%d", index)
+	}
+	if p.PolicyType == "" {
+		p.PolicyType = POLICY_TYPE_REPLICATION
+	}
+	if p.PolicyType == POLICY_TYPE_ERASURE_CODING && p.EcObjectSegmentSize == 0 {
+		p.EcObjectSegmentSize = POLICY_DEFAULT_EC_SEGMENT_SIZE
+	}
+
+	err := validatePolicy(p)
+	if err != nil {
+		return "", nil, fmt.Errorf("Ring %v: %v", name, err)
+	}
+	return ringType, p, nil
+}
+
+// validatePolicy checks the policy the way swift does when it reads
+// swift.conf
+func validatePolicy(p *StoragePolicy) error {
+	switch p.PolicyType {
+	case POLICY_TYPE_REPLICATION:
+		if p.EcType != "" || p.EcNumDataFragments != 0 || p.EcNumParityFragments != 0 ||
+			p.EcObjectSegmentSize != 0 {
+			return fmt.Errorf("Only %v storage policies have erasure coding parameters",
+				POLICY_TYPE_ERASURE_CODING)
+		}
+	case POLICY_TYPE_ERASURE_CODING:
+		if !ecTypes[p.EcType] {
+			return fmt.Errorf("Unknown ec_type %q", p.EcType)
+		}
+		if p.EcNumDataFragments < 1 || p.EcNumParityFragments < 1 {
+			return fmt.Errorf("Erasure coded storage policies need data and parity fragments")
+		}
+		if p.EcObjectSegmentSize < 1 {
+			return fmt.Errorf("Invalid ec_object_segment_size %v", p.EcObjectSegmentSize)
+		}
+	default:
+		return fmt.Errorf("Unknown storage policy type %v", p.PolicyType)
+	}
+
+	names := make(map[string]bool)
+	for _, name := range p.names() {
+		if !policyNameRegexp.MatchString(name) {
+			return fmt.Errorf("Invalid storage policy name %q, only letters, digits and - are allowed", name)
+		}
+		if strings.EqualFold(name, POLICY_LEGACY_NAME) && p.Index != 0 {
+			return fmt.Errorf("The name %v is reserved for policy 0", POLICY_LEGACY_NAME)
+		}
+		if names[strings.ToUpper(name)] {
+			return fmt.Errorf("Storage policy name %v is used twice", name)
+		}
+		names[strings.ToUpper(name)] = true
+	}
+
+	if p.Default && p.Deprecated {
+		return fmt.Errorf("Deprecated storage policy %v can not be the default", p.Name)
+	}
+	return nil
+}
+
+// fragments returns the number of fragments of an erasure coded object,
+// or 0 for replicated policies
+func (p *StoragePolicy) fragments() int {
+	if p == nil || p.PolicyType != POLICY_TYPE_ERASURE_CODING {
+		return 0
+	}
+	return p.EcNumDataFragments + p.EcNumParityFragments
+}
+
+// ringReplicas returns the replica count of a ring, by default the one of
+// the manager.  Erasure coded rings have one replica per fragment.
+func ringReplicas(name string, replicas float64, p *StoragePolicy) (float64, error) {
+	fragments := p.fragments()
+	if fragments == 0 {
+		if replicas == 0 {
+			return RING_DEFAULT_REPLICAS, nil
+		}
+		return replicas, nil
+	}
+
+	if replicas != 0 && replicas != float64(fragments) {
+		return 0, fmt.Errorf("Ring %v needs %v replicas, one per fragment, not %v",
+			name, fragments, replicas)
+	}
+	return float64(fragments), nil
+}
+
+// checkFailureDomains checks an erasure coded ring has a device for every
+// fragment, and enough nodes that losing one of them does not lose more
+// fragments than there are parity fragments
+func checkFailureDomains(name string, p *StoragePolicy, nodes, devices int) error {
+	fragments := p.fragments()
+	if fragments == 0 {
+		return nil
+	}
+
+	if devices < fragments {
+		return fmt.Errorf("Ring %v has %v devices, it needs one for each of its %v fragments",
+			name, devices, fragments)
+	}
+	needed := (fragments + p.EcNumParityFragments - 1) / p.EcNumParityFragments
+	if nodes < needed {
+		return fmt.Errorf("Ring %v has %v nodes, it needs %v so that losing one loses at most %v of its %v fragments",
+			name, nodes, needed, p.EcNumParityFragments, fragments)
+	}
+	return nil
+}
+
+// names returns the name of the policy followed by its aliases
+func (p *StoragePolicy) names() []string {
+	return append([]string{p.Name}, p.Aliases...)
+}
+
+// validatePolicies checks the storage policies of the object rings of a
+// cluster can be in the same swift.conf.  Names and aliases are case
+// insensitive.
+func validatePolicies(policies []*StoragePolicy) error {
+	names := make(map[string]int)
+	defaults := make([]string, 0)
+	for _, p := range policies {
+		for _, name := range p.names() {
+			if index, ok := names[strings.ToUpper(name)]; ok {
+				return fmt.Errorf("Storage policy name %v is used by policies %v and %v",
+					name, index, p.Index)
+			}
+			names[strings.ToUpper(name)] = p.Index
+		}
+		if p.Default {
+			defaults = append(defaults, p.Name)
+		}
+	}
+
+	if len(defaults) > 1 {
+		return fmt.Errorf("Only one storage policy can be the default, not %v",
+			strings.Join(defaults, " and "))
+	}
+	return nil
+}
+
+// ringPolicy returns the type and storage policy of a ring saved in the
+// db.  Rings added before they had a type get the ones of their name.
+func ringPolicy(info *RingAddRequest) (string, *StoragePolicy) {
+	if info.Type != "" {
+		return info.Type, info.Policy
+	}
+	ringType, policy, err := newRingPolicy(info.Name, "", nil)
+	if err != nil {
+		return "", nil
+	}
+	return ringType, policy
+}
+
+// clusterPolicies returns the storage policies of the object rings of the
+// cluster, except the one of the ring skipped, sorted by index
+func clusterPolicies(tx *bolt.Tx, cluster *ClusterEntry, skip string) ([]*StoragePolicy, error) {
+	policies := make([]*StoragePolicy, 0)
+	for _, ringId := range cluster.Info.Rings {
+		if ringId == skip {
+			continue
+		}
+		ring, err := NewRingEntryFromId(tx, ringId)
+		if err != nil {
+			return nil, err
+		}
+		if _, policy := ringPolicy(&ring.Info.RingAddRequest); policy != nil {
+			policies = append(policies, policy)
+		}
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].Index < policies[j].Index
+	})
+	return policies, nil
+}
+
+// defaultPolicy returns the index of the default storage policy.  Without
+// one marked as default, swift needs it to be named, so the first policy
+// not deprecated is.
+func defaultPolicy(policies []*StoragePolicy) int {
+	for _, p := range policies {
+		if p.Default {
+			return p.Index
+		}
+	}
+	for _, p := range policies {
+		if !p.Deprecated {
+			return p.Index
+		}
+	}
+	return -1
+}
+
+// writeSwiftConf writes the [storage-policy:N] sections of swift.conf
+func writeSwiftConf(w io.Writer, clusterId string, policies []*StoragePolicy) {
+	fmt.Fprintf(w, "# Storage policies of cluster %v\n", clusterId)
+	defaultIndex := defaultPolicy(policies)
+	for _, p := range policies {
+		fmt.Fprintf(w, "\n[storage-policy:%d]\n", p.Index)
+		fmt.Fprintf(w, "name = %v\n", p.Name)
+		if len(p.Aliases) > 0 {
+			fmt.Fprintf(w, "aliases = %v\n", strings.Join(p.Aliases, ", "))
+		}
+		fmt.Fprintf(w, "policy_type = %v\n", p.PolicyType)
+		if p.PolicyType == POLICY_TYPE_ERASURE_CODING {
+			fmt.Fprintf(w, "ec_type = %v\n", p.EcType)
+			fmt.Fprintf(w, "ec_num_data_fragments = %v\n", p.EcNumDataFragments)
+			fmt.Fprintf(w, "ec_num_parity_fragments = %v\n", p.EcNumParityFragments)
+			fmt.Fprintf(w, "ec_object_segment_size = %v\n", p.EcObjectSegmentSize)
+		}
+		if p.Index == defaultIndex {
+			fmt.Fprintf(w, "default = yes\n")
+		}
+		if p.Deprecated {
+			fmt.Fprintf(w, "deprecated = yes\n")
+		}
+	}
+}
+
+// ClusterSwiftConf returns the storage policy sections of swift.conf for
+// the object rings of the cluster
+func ClusterSwiftConf(w http.ResponseWriter, r *http.Request) {
+	// Get the id from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var policies []*StoragePolicy
+	err := db.View(func(tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		policies, err = clusterPolicies(tx, cluster, "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	writeSwiftConf(w, id, policies)
+}
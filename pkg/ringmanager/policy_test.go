@@ -0,0 +1,262 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringbuilder"
+)
+
+func addRing(t *testing.T, clusterId, body string) *http.Response {
+	r, err := http.Post(ts.URL+"/rings", "application/json",
+		bytes.NewBufferString(`{"cluster":"`+clusterId+`", `+body+`}`))
+	assert.Nil(t, err)
+	return r
+}
+
+func TestRingAddPolicy(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	// Type and policy come from the name
+	r := addRing(t, id, `"name":"object"`)
+	assert.Equal(t, http.StatusCreated, r.StatusCode)
+	var ring RingInfoResponse
+	err := GetJsonFromResponse(r, &ring)
+	assert.Nil(t, err)
+	assert.Equal(t, RING_TYPE_OBJECT, ring.Type)
+	assert.Equal(t, &StoragePolicy{
+		Index:      0,
+		Name:       "Policy-0",
+		PolicyType: POLICY_TYPE_REPLICATION,
+	}, ring.Policy)
+
+	r = addRing(t, id, `"name":"object-1", "policy":{"index":1, "name":"gold", "aliases":["yellow"], "default":true}`)
+	assert.Equal(t, http.StatusCreated, r.StatusCode)
+	err = GetJsonFromResponse(r, &ring)
+	assert.Nil(t, err)
+	assert.Equal(t, "gold", ring.Policy.Name)
+	assert.True(t, ring.Policy.Default)
+
+	r = addRing(t, id, `"name":"account", "type":"account"`)
+	assert.Equal(t, http.StatusCreated, r.StatusCode)
+	var account RingInfoResponse
+	err = GetJsonFromResponse(r, &account)
+	assert.Nil(t, err)
+	assert.Nil(t, account.Policy)
+
+	r, err = http.Get(ts.URL + "/rings/" + account.Id)
+	assert.Nil(t, err)
+	err = GetJsonFromResponse(r, &account)
+	assert.Nil(t, err)
+	assert.Equal(t, RING_TYPE_ACCOUNT, account.Type)
+
+	for _, test := range []struct {
+		body   string
+		status int
+	}{
+		// Not a swift ring file name
+		{`"name":"objects"`, http.StatusBadRequest},
+		{`"name":"object-0"`, http.StatusBadRequest},
+		// Type not matching the name
+		{`"name":"container", "type":"object"`, http.StatusBadRequest},
+		{`"name":"container", "policy":{"index":0}`, http.StatusBadRequest},
+		// Policy index not matching the name
+		{`"name":"object-2", "policy":{"index":3}`, http.StatusBadRequest},
+		{`"name":"object-2", "policy":{"index":2, "name":"bad name"}`, http.StatusBadRequest},
+		{`"name":"object-2", "policy":{"index":2, "name":"Policy-0"}`, http.StatusBadRequest},
+		{`"name":"object-2", "policy":{"index":2, "policy_type":"unknown"}`, http.StatusBadRequest},
+		{`"name":"object-2", "policy":{"index":2, "default":true, "deprecated":true}`, http.StatusBadRequest},
+		// Conflicts with the other policies of the cluster
+		{`"name":"object-2", "policy":{"index":2, "name":"GOLD"}`, http.StatusConflict},
+		{`"name":"object-2", "policy":{"index":2, "aliases":["Yellow"]}`, http.StatusConflict},
+		{`"name":"object-2", "policy":{"index":2, "default":true}`, http.StatusConflict},
+	} {
+		r = addRing(t, id, test.body)
+		assert.Equal(t, test.status, r.StatusCode, test.body)
+	}
+}
+
+func TestClusterSwiftConf(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	for _, body := range []string{
+		`"name":"account"`,
+		`"name":"object-2", "policy":{"index":2, "name":"silver", "aliases":["grey", "gray"]}`,
+		`"name":"object", "policy":{"index":0, "name":"bronze", "deprecated":true}`,
+	} {
+		r := addRing(t, id, body)
+		assert.Equal(t, http.StatusCreated, r.StatusCode)
+	}
+
+	r, err := http.Get(ts.URL + "/clusters/" + id + "/swift.conf")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, r.StatusCode)
+	conf, err := ioutil.ReadAll(r.Body)
+	assert.Nil(t, err)
+
+	// Without a default the first policy not deprecated is
+	assert.Equal(t, "# Storage policies of cluster "+id+"\n"+
+		"\n[storage-policy:0]\nname = bronze\npolicy_type = replication\ndeprecated = yes\n"+
+		"\n[storage-policy:2]\nname = silver\naliases = grey, gray\npolicy_type = replication\ndefault = yes\n",
+		string(conf))
+
+	r, err = http.Get(ts.URL + "/clusters/123/swift.conf")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, r.StatusCode)
+}
+
+func TestClusterApplyPolicy(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	applyTopologyChanges(t, id, testTopology, "")
+	msg := applyTopologyChanges(t, id, testTopology+`  policy:
+    index: 0
+    name: gold
+    default: true
+`, "")
+	assert.Equal(t, 1, len(msg.Changes))
+	assert.Equal(t, []string{"policy.name: Policy-0 -> gold", "policy.default: false -> true"},
+		msg.Changes[0].Changes)
+
+	// Names of the policies can not be used twice
+	r := applyTopology(t, id, "application/x-yaml", testTopology+`  policy:
+    index: 0
+    name: gold
+- name: object-1
+  policy:
+    index: 1
+    name: GOLD
+  nodes: []
+`, "")
+	assert.Equal(t, http.StatusBadRequest, r.StatusCode)
+}
+
+const testEcPolicy = `"policy":{"index":1, "name":"ec42", "policy_type":"erasure_coding",
+	"ec_type":"liberasurecode_rs_vand", "ec_num_data_fragments":4, "ec_num_parity_fragments":2}`
+
+func TestRingAddErasureCoding(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	// One replica per fragment
+	r := addRing(t, id, `"name":"object-1", `+testEcPolicy)
+	assert.Equal(t, http.StatusCreated, r.StatusCode)
+	var ring RingInfoResponse
+	err := GetJsonFromResponse(r, &ring)
+	assert.Nil(t, err)
+	assert.Equal(t, 6.0, ring.Replicas)
+	assert.Equal(t, POLICY_DEFAULT_EC_SEGMENT_SIZE, ring.Policy.EcObjectSegmentSize)
+
+	r, err = http.Get(ts.URL + "/clusters/" + id + "/swift.conf")
+	assert.Nil(t, err)
+	conf, err := ioutil.ReadAll(r.Body)
+	assert.Nil(t, err)
+	assert.Contains(t, string(conf), "[storage-policy:1]\nname = ec42\npolicy_type = erasure_coding\n"+
+		"ec_type = liberasurecode_rs_vand\nec_num_data_fragments = 4\nec_num_parity_fragments = 2\n"+
+		"ec_object_segment_size = 1048576\ndefault = yes\n")
+
+	for _, body := range []string{
+		`"name":"object-2", "replicas":3, ` + strings.Replace(testEcPolicy, `"index":1`, `"index":2`, 1),
+		`"name":"object-2", "policy":{"index":2, "policy_type":"erasure_coding", "ec_type":"unknown",
+			"ec_num_data_fragments":4, "ec_num_parity_fragments":2}`,
+		`"name":"object-2", "policy":{"index":2, "policy_type":"erasure_coding",
+			"ec_type":"liberasurecode_rs_vand", "ec_num_data_fragments":4}`,
+		`"name":"object-2", "policy":{"index":2, "ec_num_data_fragments":4}`,
+	} {
+		r = addRing(t, id, body)
+		assert.Equal(t, http.StatusBadRequest, r.StatusCode, body)
+	}
+}
+
+func TestBuildRingErasureCodingFailureDomains(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	// 4+2 fragments need 3 nodes so that losing one loses 2 fragments
+	ringId := setupRingWithParameters(t, id, "object-1", testEcPolicy)
+	for _, ip := range []string{"127.0.0.1", "127.0.0.2"} {
+		nodeId := setupNode(t, ringId, ip, 1)
+		for _, name := range []string{"sdb1", "sdc1", "sdd1"} {
+			setupDevice(t, nodeId, name, 100)
+		}
+	}
+
+	topologies, err := clusterTopology(id)
+	assert.Nil(t, err)
+	_, err = buildRing(filepath.Join(ringManagerDir, id), topologies[0])
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Ring object-1 has 2 nodes, it needs 3")
+
+	nodeId := setupNode(t, ringId, "127.0.0.3", 1)
+	setupDevice(t, nodeId, "sdb1", 100)
+
+	result := runBuild(t, id)
+	assert.Equal(t, 1, result.Rings[0].Version)
+	builder, err := ringbuilder.Load(filepath.Join(ringManagerDir, id, "object-1.builder"))
+	assert.Nil(t, err)
+	assert.Equal(t, 6.0, builder.Replicas)
+}
+
+func TestClusterApplyErasureCoding(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	// The test topology has 3 nodes with 4 devices
+	ecTopology := strings.Replace(testTopology, "- name: object\n", `- name: object-1
+  policy:
+    index: 1
+    name: ec
+    policy_type: erasure_coding
+    ec_type: isa_l_rs_vand
+    ec_num_data_fragments: 3
+    ec_num_parity_fragments: 1
+`, 1)
+	r := applyTopology(t, id, "application/x-yaml", ecTopology, "")
+	assert.Equal(t, http.StatusBadRequest, r.StatusCode)
+
+	// Losing one of the 3 nodes loses 2 of the 4 fragments
+	ecTopology = strings.Replace(ecTopology, "ec_num_data_fragments: 3", "ec_num_data_fragments: 2", 1)
+	ecTopology = strings.Replace(ecTopology, "ec_num_parity_fragments: 1", "ec_num_parity_fragments: 2", 1)
+	applyTopologyChanges(t, id, ecTopology, "")
+	runBuild(t, id)
+
+	// Fragments are on the devices already
+	changed := strings.Replace(ecTopology, "ec_num_data_fragments: 2", "ec_num_data_fragments: 1", 1)
+	r = applyTopology(t, id, "application/x-yaml", changed, "")
+	assert.Equal(t, http.StatusConflict, r.StatusCode)
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringbuilder"
+)
+
+// RingReport returns the balance and dispersion of the ring file of the
+// current version of the ring, the one served to the nodes
+func RingReport(w http.ResponseWriter, r *http.Request) {
+	// Get the id from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var ring *RingEntry
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		ring, err = NewRingEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	report, err := ringReport(ring)
+	if os.IsNotExist(err) {
+		http.Error(w, "Ring has not been built", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write msg
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(&RingReportResponse{
+		Id:      ring.Info.Id,
+		Name:    ring.Info.Name,
+		Version: ring.Version,
+		Report:  report,
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// ringReport computes the report from the ring file.  Only the builder
+// knows when the partitions moved, composite rings have none.
+func ringReport(ring *RingEntry) (*ringbuilder.Report, error) {
+	data, err := ringbuilder.LoadRingData(ringFilePath(ring.Info.ClusterId, ring.Info.Name))
+	if err != nil {
+		return nil, err
+	}
+	b, err := ringbuilder.NewRingBuilderFromRing(data, ring.Info.MinPartHours)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ring.IsComposite() {
+		builder, err := builderBackend.Load(ringBuilderPath(ring.Info.ClusterId, ring.Info.Name))
+		if err != nil {
+			return nil, err
+		}
+		if len(builder.LastPartMoves) == len(b.LastPartMoves) {
+			b.LastPartMoves = builder.LastPartMoves
+			b.LastPartMovesEpoch = builder.LastPartMovesEpoch
+		}
+	}
+
+	return b.Report(), nil
+}
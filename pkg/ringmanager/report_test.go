@@ -0,0 +1,101 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringbuilder"
+)
+
+func getRingReport(t *testing.T, ringId string) *RingReportResponse {
+	r, err := http.Get(ts.URL + "/rings/" + ringId + "/report")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, r.StatusCode)
+
+	var report RingReportResponse
+	err = GetJsonFromResponse(r, &report)
+	assert.Nil(t, err)
+	return &report
+}
+
+func TestRingReport(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	r, err := http.Get(ts.URL + "/rings/12345/report")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, r.StatusCode)
+
+	ringId := setupTopology(t, id, "object", 3)
+	r, err = http.Get(ts.URL + "/rings/" + ringId + "/report")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, r.StatusCode)
+
+	runBuild(t, id)
+	report := getRingReport(t, ringId)
+	assert.Equal(t, ringId, report.Id)
+	assert.Equal(t, "object", report.Name)
+	assert.Equal(t, 1, report.Version)
+	assert.Equal(t, 1024, report.Parts)
+	assert.Equal(t, 0.0, report.Balance)
+	assert.Equal(t, 0.0, report.Dispersion)
+	assert.Equal(t, 3, len(report.Devices))
+	assert.Equal(t, 1024, report.Devices[0].Parts)
+	assert.Equal(t, "zone", report.Tiers[ringbuilder.TierZone].Tier)
+	assert.Equal(t, 3, report.Tiers[ringbuilder.TierZone].Domains)
+	assert.Equal(t, 0, report.Tiers[ringbuilder.TierZone].PartsSameDomain)
+	assert.NotNil(t, report.LastMoved)
+	assert.NotNil(t, report.Devices[0].LastMoved)
+
+	// A fourth zone takes partitions from the other devices
+	nodeId := setupNode(t, ringId, "127.0.0.4", 4)
+	setupDevice(t, nodeId, "sdb1", 100)
+	runBuild(t, id)
+	report = getRingReport(t, ringId)
+	assert.Equal(t, 2, report.Version)
+	assert.Equal(t, 4, len(report.Devices))
+	assert.True(t, report.Balance > 0)
+}
+
+func TestRingReportComposite(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	east := setupComponent(t, id, "east", 1)
+	west := setupComponent(t, id, "west", 2)
+	ringId := setupRingWithParameters(t, id, "object-1", `"components":["`+east+`", "`+west+`"]`)
+	runBuild(t, id)
+
+	// Each region holds 3 of the 6 replicas
+	report := getRingReport(t, ringId)
+	assert.Equal(t, 6.0, report.Replicas)
+	assert.Equal(t, 6, len(report.Devices))
+	assert.Equal(t, 2, report.Tiers[ringbuilder.TierRegion].Domains)
+	assert.Equal(t, 0, report.Tiers[ringbuilder.TierRegion].PartsAtRisk)
+	assert.Equal(t, 256, report.Tiers[ringbuilder.TierRegion].PartsSameDomain)
+	assert.Nil(t, report.LastMoved)
+
+	report = getRingReport(t, east)
+	assert.Equal(t, 3.0, report.Replicas)
+	assert.NotNil(t, report.LastMoved)
+}
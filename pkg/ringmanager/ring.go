@@ -17,14 +17,21 @@ package ringmanager
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 
 	"github.com/boltdb/bolt"
 	"github.com/gorilla/mux"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringbuilder"
 )
 
 func RingAdd(w http.ResponseWriter, r *http.Request) {
-	var msg RingAddRequest
+	// Builder parameters not in the request keep their default.  The
+	// replicas of erasure coded rings are given by their policy.
+	msg := RingAddRequest{
+		PartPower:    RING_DEFAULT_PART_POWER,
+		MinPartHours: RING_DEFAULT_MIN_PART_HOURS,
+	}
 	err := GetJsonFromRequest(r, &msg)
 	if err != nil {
 		http.Error(w, "request unable to be parsed", 422)
@@ -42,6 +49,35 @@ func RingAdd(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if msg.PartPower < 1 || msg.PartPower > RING_MAX_PART_POWER {
+		http.Error(w, ErrPartPower.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if msg.MinPartHours < 0 {
+		http.Error(w, ringbuilder.ErrMinPartHours.Error(), http.StatusBadRequest)
+		return
+	}
+
+	msg.Type, msg.Policy, err = newRingPolicy(msg.Name, msg.Type, msg.Policy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The replicas of a composite ring are the ones of its components
+	if len(msg.Components) == 0 {
+		msg.Replicas, err = ringReplicas(msg.Name, msg.Replicas, msg.Policy)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if msg.Replicas < 1 || msg.Replicas > ringbuilder.MaxReplicas {
+			http.Error(w, ringbuilder.ErrReplicas.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	// create a ring entry
 	ring := NewRingEntryFromRequest(&msg)
 
@@ -64,6 +100,31 @@ func RingAdd(w http.ResponseWriter, r *http.Request) {
 			return err
 		}
 
+		if ring.IsComposite() {
+			err = newCompositeRing(tx, cluster, ring)
+			if e, ok := err.(*topologyError); ok {
+				http.Error(w, e.msg, e.status)
+				return err
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return err
+			}
+		}
+
+		// The policies of the cluster end up in the same swift.conf
+		if ring.Info.Policy != nil {
+			policies, err := clusterPolicies(tx, cluster, "")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return err
+			}
+			err = validatePolicies(append(policies, ring.Info.Policy))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return err
+			}
+		}
+
 		// add ring to cluster
 		cluster.RingAdd(ring.Info.Id)
 
@@ -141,11 +202,72 @@ func getRingInfo(id string) (*RingInfoResponse, error) {
 }
 
 func RingDelete(w http.ResponseWriter, r *http.Request) {
-	// TODO
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.WriteHeader(http.StatusNotFound)
-	if err := json.NewEncoder(w).Encode(jsonErr{Code: http.StatusNotFound, Text: "Not Implemented yet"}); err != nil {
-		panic(err)
+	// Get the id from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// Do not archive the files while a build is writing them
+	buildLock.Lock()
+	defer buildLock.Unlock()
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		ring, err := NewRingEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		// A composite ring needs its components
+		cluster, err := NewClusterEntryFromId(tx, ring.Info.ClusterId)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+		composite, err := compositeOf(tx, cluster, ring.Info.Id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+		if composite != nil {
+			http.Error(w, fmt.Sprintf("Unable to delete ring [%v] because it is a component of ring %v",
+				ring.Info.Id, composite.Info.Name), http.StatusConflict)
+			return ErrConflict
+		}
+
+		err = ring.Delete(tx)
+		if err == ErrConflict {
+			http.Error(w, ring.ConflictString(), http.StatusConflict)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		// remove ring from cluster
+		cluster.RingDelete(ring.Info.Id)
+		err = cluster.Save(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		// Last, so that the ring is kept in the db if the files can
+		// not be moved
+		err = archiveRingFiles(ring)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
 	}
 
+	// Write msg
+	w.WriteHeader(http.StatusOK)
 }
@@ -0,0 +1,611 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringbuilder"
+)
+
+const (
+	RING_BUILDER_NATIVE = "native"
+	RING_BUILDER_SWIFT  = "swift-ring-builder"
+)
+
+// RingBuilder is a ring builder file opened by a RingBuilderBackend
+type RingBuilder interface {
+	Devices() ([]*ringbuilder.Device, error)
+	AddDevice(dev *ringbuilder.Device) (int, error)
+	RemoveDevice(id int) error
+	SetDeviceWeight(id int, weight float64) error
+	SetDeviceInfo(id int, dev *ringbuilder.Device) error
+	Rebalance() (*ringbuilder.RebalanceResult, error)
+	Save() error
+}
+
+// RingBuilderBackend creates and opens the ring builders used by BuildRing
+type RingBuilderBackend interface {
+	Create(path string, partPower int, replicas float64, minPartHours int) (RingBuilder, error)
+	Open(path string) (RingBuilder, error)
+
+	// Import writes the builder read from a swift file to path, with
+	// its ring file once rebalanced.  data is the uncompressed file the
+	// builder was read from, a ring file when ring is true.
+	Import(path string, builder *ringbuilder.RingBuilder, data []byte, ring bool) error
+
+	// Load reads the builder file at path, to compose composite rings
+	Load(path string) (*ringbuilder.RingBuilder, error)
+}
+
+// NewRingBuilderBackend returns the backend called name.  The native
+// backend is used unless the external swift-ring-builder is asked for.
+func NewRingBuilderBackend(name, swiftRingBuilderPath string) (RingBuilderBackend, error) {
+	switch name {
+	case "", RING_BUILDER_NATIVE:
+		return &nativeBackend{}, nil
+	case RING_BUILDER_SWIFT:
+		if _, err := exec.LookPath(swiftRingBuilderPath); err != nil {
+			return nil, fmt.Errorf("Unable to use %v: %v", swiftRingBuilderPath, err)
+		}
+		return &swiftBackend{command: swiftRingBuilderPath}, nil
+	default:
+		return nil, fmt.Errorf("Unknown ring builder backend %v", name)
+	}
+}
+
+// Builds the rings in Go using the ringbuilder package
+type nativeBackend struct{}
+
+type nativeBuilder struct {
+	path    string
+	builder *ringbuilder.RingBuilder
+}
+
+func (n *nativeBackend) Create(path string, partPower int, replicas float64, minPartHours int) (RingBuilder, error) {
+	builder, err := ringbuilder.NewRingBuilder(partPower, replicas, minPartHours)
+	if err != nil {
+		return nil, err
+	}
+
+	return &nativeBuilder{path: path, builder: builder}, nil
+}
+
+func (n *nativeBackend) Open(path string) (RingBuilder, error) {
+	builder, err := n.Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &nativeBuilder{path: path, builder: builder}, nil
+}
+
+func (n *nativeBackend) Import(path string, builder *ringbuilder.RingBuilder, data []byte, ring bool) error {
+	return (&nativeBuilder{path: path, builder: builder}).Save()
+}
+
+func (n *nativeBackend) Load(path string) (*ringbuilder.RingBuilder, error) {
+	builder, err := ringbuilder.Load(path)
+	if err == ringbuilder.ErrNotSaved && isSwiftBuilderFile(path) {
+		return nil, newBackendMismatch(path, RING_BUILDER_SWIFT)
+	}
+	return builder, err
+}
+
+func (n *nativeBuilder) Devices() ([]*ringbuilder.Device, error) {
+	return n.builder.Devices(), nil
+}
+
+func (n *nativeBuilder) AddDevice(dev *ringbuilder.Device) (int, error) {
+	return n.builder.AddDev(dev)
+}
+
+func (n *nativeBuilder) RemoveDevice(id int) error {
+	return n.builder.RemoveDev(id)
+}
+
+func (n *nativeBuilder) SetDeviceWeight(id int, weight float64) error {
+	return n.builder.SetDevWeight(id, weight)
+}
+
+func (n *nativeBuilder) SetDeviceInfo(id int, dev *ringbuilder.Device) error {
+	return n.builder.SetDevInfo(id, dev)
+}
+
+func (n *nativeBuilder) Rebalance() (*ringbuilder.RebalanceResult, error) {
+	return n.builder.Rebalance(time.Now().UnixNano())
+}
+
+// Save writes the builder file and, once it has been rebalanced, the
+// ring file next to it as swift-ring-builder does
+func (n *nativeBuilder) Save() error {
+	err := n.builder.Save(n.path)
+	if err != nil {
+		return err
+	}
+
+	if n.builder.Replica2Part2Dev == nil {
+		return nil
+	}
+	return n.builder.GetRing().Save(strings.TrimSuffix(n.path, ".builder") + ".ring.gz")
+}
+
+// Builds the rings by executing swift's swift-ring-builder command
+type swiftBackend struct {
+	command string
+}
+
+type swiftBuilder struct {
+	command string
+	path    string
+}
+
+var (
+	swiftAddRegexp       = regexp.MustCompile(`got id (\d+)`)
+	swiftRebalanceRegexp = regexp.MustCompile(`Reassigned (\d+) .* Balance is now ([0-9]+\.[0-9]+)`)
+)
+
+func (s *swiftBackend) Create(path string, partPower int, replicas float64, minPartHours int) (RingBuilder, error) {
+	builder := &swiftBuilder{command: s.command, path: path}
+	_, err := builder.run("create",
+		strconv.Itoa(partPower),
+		strconv.FormatFloat(replicas, 'f', -1, 64),
+		strconv.Itoa(minPartHours))
+	if err != nil {
+		return nil, err
+	}
+
+	return builder, nil
+}
+
+func (s *swiftBackend) Open(path string) (RingBuilder, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	if _, err := ringbuilder.Load(path); err == nil {
+		return nil, newBackendMismatch(path, RING_BUILDER_NATIVE)
+	}
+
+	return &swiftBuilder{command: s.command, path: path}, nil
+}
+
+// Import keeps the builder file as swift wrote it.  A ring file is turned
+// back into a builder by swift-ring-builder write_builder.
+func (s *swiftBackend) Import(path string, builder *ringbuilder.RingBuilder, data []byte, ring bool) error {
+	ringPath := strings.TrimSuffix(path, ".builder") + ".ring.gz"
+	if ring {
+		err := builder.GetRing().Save(ringPath)
+		if err != nil {
+			return err
+		}
+		_, err = (&swiftBuilder{command: s.command, path: ringPath}).run("write_builder",
+			strconv.Itoa(builder.MinPartHours))
+		return err
+	}
+
+	err := ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		return err
+	}
+	if builder.Replica2Part2Dev == nil {
+		return nil
+	}
+	_, err = (&swiftBuilder{command: s.command, path: path}).run("write_ring")
+	return err
+}
+
+func (s *swiftBackend) Load(path string) (*ringbuilder.RingBuilder, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	builder, err := ringbuilder.LoadSwiftBuilder(fp)
+	if err != nil {
+		if _, nativeErr := ringbuilder.Load(path); nativeErr == nil {
+			return nil, newBackendMismatch(path, RING_BUILDER_NATIVE)
+		}
+	}
+	return builder, err
+}
+
+// newBackendMismatch is the error for a builder file written by another
+// backend than the one configured
+func newBackendMismatch(path, backend string) error {
+	return fmt.Errorf("Builder %v was written by the %v ring builder backend, "+
+		"set ring_builder_backend to %v to build it", filepath.Base(path), backend, backend)
+}
+
+// isSwiftBuilderFile tells if the file at path is a builder pickled by
+// swift-ring-builder
+func isSwiftBuilderFile(path string) bool {
+	fp, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer fp.Close()
+
+	_, err = ringbuilder.LoadSwiftBuilder(fp)
+	return err == nil
+}
+
+// run executes swift-ring-builder on the builder file.  Exit status 1
+// is only a warning, for example when a rebalance had nothing to do.
+func (s *swiftBuilder) run(args ...string) (string, error) {
+	args = append([]string{s.path}, args...)
+	out, err := exec.Command(s.command, args...).CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.ExitStatus() == 1 {
+			err = nil
+		}
+	}
+	if err != nil {
+		if len(out) == 0 {
+			return "", err
+		}
+		return "", fmt.Errorf("%s", out)
+	}
+
+	return string(out), nil
+}
+
+func swiftFormatIp(ip string) string {
+	if strings.Contains(ip, ":") {
+		return "[" + ip + "]"
+	}
+	return ip
+}
+
+// swiftDeviceInfoArg returns the <ip>:<port>R<r_ip>:<r_port>/<name>_<meta>
+// form of the device used by swift-ring-builder
+func swiftDeviceInfoArg(dev *ringbuilder.Device) string {
+	arg := fmt.Sprintf("%s:%d", swiftFormatIp(dev.Ip), dev.Port)
+	if dev.ReplicationIp != "" {
+		replicationPort := dev.ReplicationPort
+		if replicationPort == 0 {
+			replicationPort = dev.Port
+		}
+		arg += fmt.Sprintf("R%s:%d", swiftFormatIp(dev.ReplicationIp), replicationPort)
+	}
+	arg += "/" + dev.Device
+	if dev.Meta != "" {
+		arg += "_" + dev.Meta
+	}
+
+	return arg
+}
+
+func swiftDeviceArg(dev *ringbuilder.Device) string {
+	//swift-ring-builder object.builder add r1z1-127.0.0.1:6010R127.0.0.1:6020/sdb1_meta 1
+	return fmt.Sprintf("r%dz%d-%s", dev.Region, dev.Zone, swiftDeviceInfoArg(dev))
+}
+
+// swiftSplitAddress splits the ip:port addresses, with ipv6 addresses
+// between brackets, shown by swift-ring-builder
+func swiftSplitAddress(addr string) (string, int, error) {
+	i := strings.LastIndex(addr, ":")
+	if i < 0 {
+		return "", 0, fmt.Errorf("Invalid address %v", addr)
+	}
+	port, err := strconv.Atoi(addr[i+1:])
+	if err != nil {
+		return "", 0, fmt.Errorf("Invalid address %v", addr)
+	}
+	return strings.Trim(addr[:i], "[]"), port, nil
+}
+
+// Devices parses the device table printed by swift-ring-builder:
+//
+//	Devices:   id region zone ip address:port replication ip:port  name weight partitions balance flags meta
+//	            0      1    1  127.0.0.1:6010      127.0.0.1:6010  sdb1 100.00       3072    0.00       meta
+func (s *swiftBuilder) Devices() ([]*ringbuilder.Device, error) {
+	out, err := s.run()
+	if err != nil {
+		return nil, err
+	}
+
+	devs := make([]*ringbuilder.Device, 0)
+	table := false
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "Devices:") {
+			table = true
+			continue
+		}
+		fields := strings.Fields(line)
+		if !table || len(fields) < 9 {
+			continue
+		}
+
+		// Devices marked for removal are already gone for us
+		meta := fields[9:]
+		if len(meta) > 0 && meta[0] == "DEL" {
+			continue
+		}
+
+		dev := &ringbuilder.Device{Device: fields[5], Meta: strings.Join(meta, " ")}
+		dev.Id, err = strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse device: %v", line)
+		}
+		dev.Region, _ = strconv.Atoi(fields[1])
+		dev.Zone, _ = strconv.Atoi(fields[2])
+		dev.Ip, dev.Port, err = swiftSplitAddress(fields[3])
+		if err != nil {
+			return nil, err
+		}
+		dev.ReplicationIp, dev.ReplicationPort, err = swiftSplitAddress(fields[4])
+		if err != nil {
+			return nil, err
+		}
+		dev.Weight, _ = strconv.ParseFloat(fields[6], 64)
+		dev.Parts, _ = strconv.Atoi(fields[7])
+		devs = append(devs, dev)
+	}
+
+	return devs, nil
+}
+
+func (s *swiftBuilder) AddDevice(dev *ringbuilder.Device) (int, error) {
+	out, err := s.run("add",
+		swiftDeviceArg(dev),
+		strconv.FormatFloat(dev.Weight, 'f', -1, 64))
+	if err != nil {
+		return 0, err
+	}
+
+	match := swiftAddRegexp.FindStringSubmatch(out)
+	if match == nil {
+		return 0, fmt.Errorf("Unable to find device id in: %s", out)
+	}
+	return strconv.Atoi(match[1])
+}
+
+func (s *swiftBuilder) RemoveDevice(id int) error {
+	_, err := s.run("remove", fmt.Sprintf("d%d", id))
+	return err
+}
+
+func (s *swiftBuilder) SetDeviceWeight(id int, weight float64) error {
+	_, err := s.run("set_weight", fmt.Sprintf("d%d", id), strconv.FormatFloat(weight, 'f', -1, 64))
+	return err
+}
+
+func (s *swiftBuilder) SetDeviceInfo(id int, dev *ringbuilder.Device) error {
+	search := fmt.Sprintf("d%d", id)
+	_, err := s.run("set_region", search, strconv.Itoa(dev.Region))
+	if err != nil {
+		return err
+	}
+	_, err = s.run("set_zone", search, strconv.Itoa(dev.Zone))
+	if err != nil {
+		return err
+	}
+	_, err = s.run("set_info", search, swiftDeviceInfoArg(dev))
+	return err
+}
+
+func (s *swiftBuilder) Rebalance() (*ringbuilder.RebalanceResult, error) {
+	out, err := s.run("rebalance")
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ringbuilder.RebalanceResult{}
+	if match := swiftRebalanceRegexp.FindStringSubmatch(out); match != nil {
+		result.ChangedParts, _ = strconv.Atoi(match[1])
+		result.Balance, _ = strconv.ParseFloat(match[2], 64)
+	}
+	return result, nil
+}
+
+// swift-ring-builder saves the builder and ring files after every command
+func (s *swiftBuilder) Save() error {
+	return nil
+}
+
+// newBuilderDevice returns the ring builder device for a device of the
+// node, with its current weight
+func newBuilderDevice(n *NodeEntry, d *DeviceEntry) (*ringbuilder.Device, error) {
+	port, err := strconv.Atoi(n.Info.Port)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid port %v on node %v", n.Info.Port, n.Info.Id)
+	}
+	replicationPort, err := strconv.Atoi(n.Info.ReplicationPort)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid replication port %v on node %v",
+			n.Info.ReplicationPort, n.Info.Id)
+	}
+
+	dev := &ringbuilder.Device{
+		Region:          n.Info.Region,
+		Zone:            n.Info.Zone,
+		Ip:              n.Info.Ip,
+		Port:            port,
+		ReplicationIp:   n.Info.ReplicationIP,
+		ReplicationPort: replicationPort,
+		Device:          d.Info.Name,
+		Weight:          float64(d.Info.Weight.Current),
+		Meta:            d.Info.Meta,
+	}
+
+	return dev, nil
+}
+
+func ringBuilderPath(clusterId, ringName string) string {
+	return filepath.Join(ringManagerDir, clusterId, ringName+".builder")
+}
+
+func ringFilePath(clusterId, ringName string) string {
+	return filepath.Join(ringManagerDir, clusterId, ringName+".ring.gz")
+}
+
+func ringCompositePath(clusterId, ringName string) string {
+	return filepath.Join(ringManagerDir, clusterId, ringName+".composite.json")
+}
+
+// ringSourcePath is the file the ring file is built from: the builder,
+// or the metadata of a composite ring
+func ringSourcePath(ring *RingEntry) string {
+	if ring.IsComposite() {
+		return ringCompositePath(ring.Info.ClusterId, ring.Info.Name)
+	}
+	return ringBuilderPath(ring.Info.ClusterId, ring.Info.Name)
+}
+
+// ringVersionPath is where the files of a version of the ring are kept
+func ringVersionPath(ring *RingEntry, version int) string {
+	return filepath.Join(ringManagerDir, ring.Info.ClusterId, "versions", ring.Info.Id,
+		strconv.Itoa(version))
+}
+
+// saveRingVersion keeps a copy of the source and ring files built in dir
+// as the version of the ring and returns the hash of the ring file
+func saveRingVersion(ring *RingEntry, version int, dir string) (string, error) {
+	versionPath := ringVersionPath(ring, version)
+	err := os.MkdirAll(versionPath, 0774)
+	if err != nil {
+		return "", err
+	}
+
+	for _, path := range ringFiles(ring) {
+		err = CopyFile(filepath.Join(dir, filepath.Base(path)), filepath.Join(versionPath, filepath.Base(path)))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return PathHash(filepath.Join(versionPath, ring.Info.Name+".ring.gz"))
+}
+
+// ringFiles returns the current source and ring files of the ring
+func ringFiles(ring *RingEntry) []string {
+	return []string{
+		ringSourcePath(ring),
+		ringFilePath(ring.Info.ClusterId, ring.Info.Name),
+	}
+}
+
+// copyRingFiles copies the current source and ring files of the ring,
+// if it has been built, to dir
+func copyRingFiles(ring *RingEntry, dir string) error {
+	for _, path := range ringFiles(ring) {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+
+		err := CopyFile(path, filepath.Join(dir, filepath.Base(path)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// moveRingFiles makes the source and ring files built in dir the current
+// files of the ring
+func moveRingFiles(ring *RingEntry, dir string) error {
+	for _, path := range ringFiles(ring) {
+		err := os.Rename(filepath.Join(dir, filepath.Base(path)), path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restoreRingVersion makes the files of the version current again
+func restoreRingVersion(ring *RingEntry, version int) error {
+	versionPath := ringVersionPath(ring, version)
+	for _, path := range ringFiles(ring) {
+		err := CopyFile(filepath.Join(versionPath, filepath.Base(path)), path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ringArchivePath is where the files of a deleted ring are kept
+func ringArchivePath(ring *RingEntry) string {
+	return filepath.Join(ringManagerDir, ring.Info.ClusterId, "archive", ring.Info.Id)
+}
+
+// archiveRingFiles moves the source and ring files and the versions of a
+// deleted ring out of the way, so that a new ring with the same name
+// starts from scratch
+func archiveRingFiles(ring *RingEntry) error {
+	archivePath := ringArchivePath(ring)
+	for _, move := range []struct{ from, to string }{
+		{ringSourcePath(ring), filepath.Base(ringSourcePath(ring))},
+		{ringFilePath(ring.Info.ClusterId, ring.Info.Name), ring.Info.Name + ".ring.gz"},
+		{filepath.Dir(ringVersionPath(ring, 0)), "versions"},
+	} {
+		if _, err := os.Stat(move.from); os.IsNotExist(err) {
+			continue
+		}
+
+		err := os.MkdirAll(archivePath, 0774)
+		if err != nil {
+			return err
+		}
+		err = os.Rename(move.from, filepath.Join(archivePath, move.to))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ringPartsByDevice returns the number of partition replicas held by each
+// device, by builder id, in the last ring built.  It is empty if the ring
+// has never been built.
+func ringPartsByDevice(ring *RingEntry) (map[int]int, error) {
+	return ringFilePartsByDevice(ringFilePath(ring.Info.ClusterId, ring.Info.Name))
+}
+
+// ringFilePartsByDevice returns the number of partition replicas held by
+// each device of the ring file at path, if any
+func ringFilePartsByDevice(path string) (map[int]int, error) {
+	parts := make(map[int]int)
+
+	data, err := ringbuilder.LoadRingData(path)
+	if os.IsNotExist(err) {
+		return parts, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, d := range data.Devs {
+		if d != nil {
+			parts[d.Id] = d.Parts
+		}
+	}
+	return parts, nil
+}
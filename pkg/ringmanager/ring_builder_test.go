@@ -0,0 +1,156 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringbuilder"
+)
+
+// A fake swift-ring-builder printing the builder the way swift does
+const fakeSwiftRingBuilder = `#!/bin/sh
+cat <<OUT
+object.builder, build version 4
+1024 partitions, 3.000000 replicas, 1 regions, 2 zones, 3 devices, 0.00 balance, 0.00 dispersion
+The minimum number of hours before a partition can be reassigned is 1 (0:00:00 remaining)
+The overload factor is 0.00% (0.000000)
+Ring file object.ring.gz is up-to-date
+Devices:   id region zone      ip address:port replication ip:port  name weight partitions balance flags meta
+            0      1    1      127.0.0.1:6010      127.0.0.1:6020  sdb1 100.00       1536    0.00       
+            1      1    2         [::1]:6010          [::1]:6020  sdb2  50.00       1536    0.00       ssd disk
+            2      1    2      127.0.0.2:6010      127.0.0.2:6010  sdb3   0.00          0    0.00   DEL 
+OUT
+`
+
+func TestSwiftBuilderDevices(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ringmanager")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	command := filepath.Join(dir, "swift-ring-builder")
+	err = ioutil.WriteFile(command, []byte(fakeSwiftRingBuilder), 0755)
+	assert.Nil(t, err)
+	path := filepath.Join(dir, "object.builder")
+	err = ioutil.WriteFile(path, []byte{}, 0644)
+	assert.Nil(t, err)
+
+	backend, err := NewRingBuilderBackend(RING_BUILDER_SWIFT, command)
+	assert.Nil(t, err)
+	builder, err := backend.Open(path)
+	assert.Nil(t, err)
+
+	devs, err := builder.Devices()
+	assert.Nil(t, err)
+	assert.Equal(t, []*ringbuilder.Device{
+		{
+			Id:              0,
+			Region:          1,
+			Zone:            1,
+			Ip:              "127.0.0.1",
+			Port:            6010,
+			ReplicationIp:   "127.0.0.1",
+			ReplicationPort: 6020,
+			Device:          "sdb1",
+			Weight:          100,
+			Parts:           1536,
+		},
+		{
+			Id:              1,
+			Region:          1,
+			Zone:            2,
+			Ip:              "::1",
+			Port:            6010,
+			ReplicationIp:   "::1",
+			ReplicationPort: 6020,
+			Device:          "sdb2",
+			Weight:          50,
+			Meta:            "ssd disk",
+			Parts:           1536,
+		},
+	}, devs)
+}
+
+func TestNewRingBuilderBackendInvalid(t *testing.T) {
+	_, err := NewRingBuilderBackend("python", "")
+	assert.NotNil(t, err)
+	_, err = NewRingBuilderBackend(RING_BUILDER_SWIFT, "/nonexistent/swift-ring-builder")
+	assert.NotNil(t, err)
+
+	// The server does not start with another backend than configured
+	v := viper.New()
+	v.Set("ring_builder_backend", RING_BUILDER_SWIFT)
+	v.Set("swift_ring_builder_path", "/nonexistent/swift-ring-builder")
+	_, err = NewRouter(v)
+	assert.NotNil(t, err)
+}
+
+func TestBuilderBackendMismatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ringmanager")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	native, err := NewRingBuilderBackend(RING_BUILDER_NATIVE, "")
+	assert.Nil(t, err)
+	command := filepath.Join(dir, "swift-ring-builder")
+	err = ioutil.WriteFile(command, []byte(fakeSwiftRingBuilder), 0755)
+	assert.Nil(t, err)
+	swift, err := NewRingBuilderBackend(RING_BUILDER_SWIFT, command)
+	assert.Nil(t, err)
+
+	// A builder pickled by swift
+	_, err = native.Open(filepath.Join(importTestdata, "object.builder"))
+	assert.Contains(t, err.Error(), "set ring_builder_backend to "+RING_BUILDER_SWIFT)
+	_, err = native.Load(filepath.Join(importTestdata, "object.builder"))
+	assert.Contains(t, err.Error(), "set ring_builder_backend to "+RING_BUILDER_SWIFT)
+
+	// A builder saved by the native backend
+	path := filepath.Join(dir, "object.builder")
+	builder, err := native.Create(path, 8, 3, 1)
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = builder.AddDevice(&ringbuilder.Device{Zone: i, Ip: "127.0.0.1", Port: 6010 + i,
+			Device: "sdb1", Weight: 100})
+		assert.Nil(t, err)
+	}
+	_, err = builder.Rebalance()
+	assert.Nil(t, err)
+	assert.Nil(t, builder.Save())
+
+	_, err = swift.Open(path)
+	assert.Contains(t, err.Error(), "set ring_builder_backend to "+RING_BUILDER_NATIVE)
+	_, err = swift.Load(path)
+	assert.Contains(t, err.Error(), "set ring_builder_backend to "+RING_BUILDER_NATIVE)
+}
+
+func TestSwiftDeviceArg(t *testing.T) {
+	dev := &ringbuilder.Device{
+		Region:          2,
+		Zone:            3,
+		Ip:              "::1",
+		Port:            6010,
+		ReplicationIp:   "10.0.0.1",
+		ReplicationPort: 6020,
+		Device:          "sdb1",
+		Meta:            "ssd",
+	}
+	assert.Equal(t, "r2z3-[::1]:6010R10.0.0.1:6020/sdb1_ssd", swiftDeviceArg(dev))
+}
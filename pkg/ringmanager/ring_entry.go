@@ -25,11 +25,26 @@ import (
 	"github.com/lpabon/godbc"
 )
 
+const (
+	RING_DEFAULT_PART_POWER     = 10
+	RING_DEFAULT_REPLICAS       = 3
+	RING_DEFAULT_MIN_PART_HOURS = 1
+
+	// Largest part power of a new ring.  The builder holds two bytes per
+	// partition and replica, 32MiB per replica at this size.
+	RING_MAX_PART_POWER = 24
+)
+
 type RingEntry struct {
 	Entry
 
 	Info  RingInfo
 	Nodes sort.StringSlice
+
+	// Version the current builder and ring files come from, and the
+	// last version built
+	Version     int
+	LastVersion int
 }
 
 func NewRingEntry() *RingEntry {
@@ -46,6 +61,12 @@ func NewRingEntryFromRequest(req *RingAddRequest) *RingEntry {
 	ring.Info.Id = GenUUID()
 	ring.Info.Name = req.Name
 	ring.Info.ClusterId = req.ClusterId
+	ring.Info.PartPower = req.PartPower
+	ring.Info.Replicas = req.Replicas
+	ring.Info.MinPartHours = req.MinPartHours
+	ring.Info.Type = req.Type
+	ring.Info.Policy = req.Policy
+	ring.Info.Components = append([]string(nil), req.Components...)
 
 	return ring
 }
@@ -126,9 +147,16 @@ func (r *RingEntry) IsDeleteOk() bool {
 	return true
 }
 
+// IsComposite is true when the ring is composed of component rings
+// instead of being built from its own nodes
+func (r *RingEntry) IsComposite() bool {
+	return len(r.Info.Components) > 0
+}
+
 func (r *RingEntry) ConflictString() string {
 	return fmt.Sprintf("Unable to delete ring [%v] because it contains nodes", r.Info.Id)
 }
+
 func (r *RingEntry) Delete(tx *bolt.Tx) error {
 	godbc.Require(tx != nil)
 
@@ -138,6 +166,25 @@ func (r *RingEntry) Delete(tx *bolt.Tx) error {
 		return ErrConflict
 	}
 
+	err := r.Deregister(tx)
+	if err != nil {
+		return err
+	}
+
+	// The versions go away with the ring
+	for version := 1; version <= r.LastVersion; version++ {
+		entry, err := NewRingVersionEntryFromId(tx, r.Info.Id, version)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		err = entry.Delete(tx)
+		if err != nil {
+			return err
+		}
+	}
+
 	return EntryDelete(tx, r, r.Info.Id)
 }
 
@@ -146,8 +193,14 @@ func (r *RingEntry) NewInfoResponse() (*RingInfoResponse, error) {
 	info.ClusterId = r.Info.ClusterId
 	info.Id = r.Info.Id
 	info.Name = r.Info.Name
+	info.PartPower = r.Info.PartPower
+	info.Replicas = r.Info.Replicas
+	info.MinPartHours = r.Info.MinPartHours
+	info.Type, info.Policy = ringPolicy(&r.Info.RingAddRequest)
+	info.Components = r.Info.Components
 	//info.Nodes = make(sort.StringSlice, 0)
 	info.Nodes = r.Nodes
+	info.Version = r.Version
 	return info, nil
 }
 
@@ -171,6 +224,14 @@ func (r *RingEntry) Unmarshal(buffer []byte) error {
 		r.Nodes = make(sort.StringSlice, 0)
 	}
 
+	// Rings saved before the builder parameters could be set were
+	// always built with the defaults
+	if r.Info.PartPower == 0 {
+		r.Info.PartPower = RING_DEFAULT_PART_POWER
+		r.Info.Replicas = RING_DEFAULT_REPLICAS
+		r.Info.MinPartHours = RING_DEFAULT_MIN_PART_HOURS
+	}
+
 	return nil
 }
 
@@ -0,0 +1,208 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringbuilder"
+)
+
+func TestRingAddDefaults(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	ringId := setupRing(t, id, "account")
+
+	r, err := http.Get(ts.URL + "/rings/" + ringId)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+
+	var msg RingInfoResponse
+	err = GetJsonFromResponse(r, &msg)
+	assert.Nil(t, err)
+	assert.Equal(t, RING_DEFAULT_PART_POWER, msg.PartPower)
+	assert.Equal(t, float64(RING_DEFAULT_REPLICAS), msg.Replicas)
+	assert.Equal(t, RING_DEFAULT_MIN_PART_HOURS, msg.MinPartHours)
+}
+
+func TestRingAddBuilderParameters(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	body := []byte(`{"name":"object", "cluster":"` + id + `", "part_power":8, "replicas":2.5, "min_part_hours":0}`)
+	r, err := http.Post(ts.URL+"/rings", "application/json", bytes.NewBuffer(body))
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusCreated)
+
+	var info RingInfo
+	err = GetJsonFromResponse(r, &info)
+	assert.Nil(t, err)
+
+	r, err = http.Get(ts.URL + "/rings/" + info.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+
+	var msg RingInfoResponse
+	err = GetJsonFromResponse(r, &msg)
+	assert.Nil(t, err)
+	assert.Equal(t, 8, msg.PartPower)
+	assert.Equal(t, 2.5, msg.Replicas)
+	assert.Equal(t, 0, msg.MinPartHours)
+
+	// The builder is created with the ring parameters
+	for z := 1; z <= 3; z++ {
+		nodeId := setupNode(t, info.Id, fmt.Sprintf("127.0.0.%d", z), z)
+		setupDevice(t, nodeId, "sdb1", 100)
+	}
+	runBuild(t, id)
+
+	builder, err := ringbuilder.Load(filepath.Join(ringManagerDir, id, "object.builder"))
+	assert.Nil(t, err)
+	assert.Equal(t, 8, builder.PartPower)
+	assert.Equal(t, 2.5, builder.Replicas)
+	assert.Equal(t, 0, builder.MinPartHours)
+}
+
+func TestRingReplicasMoreThanDevices(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	ringId := setupRingWithParameters(t, id, "object", `"replicas":2.5`)
+	for z := 1; z <= 2; z++ {
+		nodeId := setupNode(t, ringId, fmt.Sprintf("127.0.0.%d", z), z)
+		setupDevice(t, nodeId, "sdb1", 100)
+	}
+
+	topologies, err := clusterTopology(id)
+	assert.Nil(t, err)
+	_, err = buildRing(filepath.Join(ringManagerDir, id), topologies[0])
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Ring object has 2 devices with weight, it needs one for each of its 3 replicas")
+
+	nodeId := setupNode(t, ringId, "127.0.0.3", 3)
+	setupDevice(t, nodeId, "sdb1", 100)
+	runBuild(t, id)
+}
+
+func TestRingAddInvalidBuilderParameters(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	for _, params := range []string{
+		`"part_power":0`,
+		`"part_power":25`,
+		`"replicas":0.5`,
+		`"replicas":65`,
+		`"min_part_hours":-1`,
+	} {
+		body := []byte(`{"name":"object", "cluster":"` + id + `", ` + params + `}`)
+		r, err := http.Post(ts.URL+"/rings", "application/json", bytes.NewBuffer(body))
+		assert.Nil(t, err)
+		assert.Equal(t, r.StatusCode, http.StatusBadRequest, params)
+	}
+}
+
+func TestRingDeleteIdNotFound(t *testing.T) {
+
+	// setup and teardown test case
+	_, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	res := httpDelete(t, ts.URL+"/rings/12345")
+	assert.Equal(t, res.StatusCode, http.StatusNotFound)
+}
+
+func TestRingDeleteNodeExists(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	ringId := setupRing(t, id, "account")
+	setupNode(t, ringId, "127.0.0.1", 1)
+
+	res := httpDelete(t, ts.URL+"/rings/"+ringId)
+	assert.Equal(t, res.StatusCode, http.StatusConflict)
+
+	body, err := ioutil.ReadAll(res.Body)
+	assert.Nil(t, err)
+	assert.Contains(t, string(body), "because it contains nodes")
+}
+
+func TestRingDelete(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	ringId := setupTopology(t, id, "object", 3)
+
+	runBuild(t, id)
+
+	var ring RingInfoResponse
+	r, err := http.Get(ts.URL + "/rings/" + ringId)
+	assert.Nil(t, err)
+	err = GetJsonFromResponse(r, &ring)
+	assert.Nil(t, err)
+	for _, nodeId := range ring.Nodes {
+		res := httpDelete(t, ts.URL+"/nodes/"+nodeId+"?force=true")
+		assert.Equal(t, res.StatusCode, http.StatusOK)
+	}
+
+	res := httpDelete(t, ts.URL+"/rings/"+ringId)
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+
+	r, err = http.Get(ts.URL + "/rings/" + ringId)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusNotFound)
+
+	r, err = http.Get(ts.URL + "/clusters/" + id)
+	assert.Nil(t, err)
+	var cluster ClusterInfoResponse
+	err = GetJsonFromResponse(r, &cluster)
+	assert.Nil(t, err)
+	assert.Empty(t, cluster.Rings)
+
+	// The files are archived
+	clusterPath := filepath.Join(ringManagerDir, id)
+	for _, name := range []string{"object.builder", "object.ring.gz"} {
+		_, err = os.Stat(filepath.Join(clusterPath, name))
+		assert.True(t, os.IsNotExist(err), name)
+		_, err = os.Stat(filepath.Join(clusterPath, "archive", ringId, name))
+		assert.Nil(t, err, name)
+	}
+	_, err = os.Stat(filepath.Join(clusterPath, "archive", ringId, "versions", "1", "object.ring.gz"))
+	assert.Nil(t, err)
+
+	// A ring with the same name can be added again
+	setupRing(t, id, "object")
+}
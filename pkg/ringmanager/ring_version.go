@@ -0,0 +1,243 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+)
+
+func RingVersionList(w http.ResponseWriter, r *http.Request) {
+	// Get the id from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	list := &RingVersionListResponse{Versions: make([]*RingVersionSummary, 0)}
+	err := db.View(func(tx *bolt.Tx) error {
+		ring, err := NewRingEntryFromId(tx, id)
+		if err != nil {
+			return err
+		}
+		list.Current = ring.Version
+
+		for version := 1; version <= ring.LastVersion; version++ {
+			entry, err := NewRingVersionEntryFromId(tx, id, version)
+			if err == ErrNotFound {
+				// Expired
+				continue
+			} else if err != nil {
+				return err
+			}
+			list.Versions = append(list.Versions, entry.NewSummary())
+		}
+
+		return nil
+	})
+	if err == ErrNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write msg
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		panic(err)
+	}
+}
+
+func RingVersionInformation(w http.ResponseWriter, r *http.Request) {
+	// Get the id and version from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+	version, _ := strconv.Atoi(vars["version"])
+
+	var info RingVersionInfo
+	err := db.View(func(tx *bolt.Tx) error {
+		entry, err := NewRingVersionEntryFromId(tx, id, version)
+		if err != nil {
+			return err
+		}
+		info = entry.Info
+
+		return nil
+	})
+	if err == ErrNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write msg
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(&info); err != nil {
+		panic(err)
+	}
+}
+
+// RingRollback makes an older version of the ring current again.  The
+// devices get back the builder ids and weights they had in that version,
+// and the next build brings the builder up to date with the db again.
+func RingRollback(w http.ResponseWriter, r *http.Request) {
+	// Get the id and version from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+	version, _ := strconv.Atoi(vars["version"])
+
+	// Do not replace the files while a build is writing them
+	buildLock.Lock()
+	defer buildLock.Unlock()
+
+	var info *RingInfoResponse
+	err := db.Update(func(tx *bolt.Tx) error {
+		ring, err := NewRingEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		entry, err := NewRingVersionEntryFromId(tx, id, version)
+		if err == ErrNotFound {
+			http.Error(w, "Ring version does not exist", http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		err = rollbackRingDevices(tx, ring, entry)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		ring.Version = version
+		err = ring.Save(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		// Last, so that the db is not changed if the files can not be
+		// restored
+		err = restoreRingVersion(ring, version)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		info, err = ring.NewInfoResponse()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// Write msg
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		panic(err)
+	}
+}
+
+// rollbackRingDevices gives the devices of the ring the builder ids and
+// weights they had in the version.  Devices added since then are added to
+// the builder again by the next build.
+func rollbackRingDevices(tx *bolt.Tx, ring *RingEntry, version *RingVersionEntry) error {
+	built := make(map[string]*RingVersionDevice)
+	for _, d := range version.Info.Devices {
+		built[d.DeviceId] = d
+	}
+
+	for _, nodeId := range ring.Nodes {
+		node, err := NewNodeEntryFromId(tx, nodeId)
+		if err != nil {
+			return err
+		}
+
+		for _, deviceId := range node.Devices {
+			device, err := NewDeviceEntryFromId(tx, deviceId)
+			if err != nil {
+				return err
+			}
+
+			if d, ok := built[deviceId]; ok {
+				device.BuilderId = d.BuilderId
+				device.InBuilder = true
+				device.Info.Weight.Current = d.Weight
+			} else {
+				device.InBuilder = false
+				device.Info.Weight.Current = 0
+			}
+
+			err = device.Save(tx)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// expireRingVersions forgets the versions of the ring older than the last
+// ringVersionsKept ones and returns them, for their files to be removed
+func expireRingVersions(tx *bolt.Tx, ring *RingEntry) ([]int, error) {
+	expired := make([]int, 0)
+	if ringVersionsKept <= 0 {
+		return expired, nil
+	}
+
+	for version := ring.LastVersion - ringVersionsKept; version >= 1; version-- {
+		if version == ring.Version {
+			continue
+		}
+
+		entry, err := NewRingVersionEntryFromId(tx, ring.Info.Id, version)
+		if err == ErrNotFound {
+			// The older ones expired already
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		err = entry.Delete(tx)
+		if err != nil {
+			return nil, err
+		}
+		expired = append(expired, version)
+	}
+
+	return expired, nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/lpabon/godbc"
+)
+
+// RingVersionEntry is an immutable build of a ring.  Its builder and ring
+// files are kept under the versions directory of the cluster.
+type RingVersionEntry struct {
+	Info RingVersionInfo
+}
+
+func NewRingVersionEntry() *RingVersionEntry {
+	entry := &RingVersionEntry{}
+	entry.Info.Devices = make([]*RingVersionDevice, 0)
+
+	return entry
+}
+
+func NewRingVersionEntryFromId(tx *bolt.Tx, ringId string, version int) (*RingVersionEntry, error) {
+	godbc.Require(tx != nil)
+
+	entry := NewRingVersionEntry()
+	err := EntryLoad(tx, entry, ringVersionKey(ringId, version))
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func ringVersionKey(ringId string, version int) string {
+	return fmt.Sprintf("%v/%v", ringId, version)
+}
+
+func (v *RingVersionEntry) BucketName() string {
+	return BOLTDB_BUCKET_RINGVERSION
+}
+
+func (v *RingVersionEntry) Save(tx *bolt.Tx) error {
+	godbc.Require(tx != nil)
+	godbc.Require(len(v.Info.RingId) > 0)
+	godbc.Require(v.Info.Version > 0)
+
+	return EntrySave(tx, v, ringVersionKey(v.Info.RingId, v.Info.Version))
+}
+
+func (v *RingVersionEntry) Delete(tx *bolt.Tx) error {
+	godbc.Require(tx != nil)
+
+	return EntryDelete(tx, v, ringVersionKey(v.Info.RingId, v.Info.Version))
+}
+
+func (v *RingVersionEntry) Marshal() ([]byte, error) {
+	var buffer bytes.Buffer
+	enc := gob.NewEncoder(&buffer)
+	err := enc.Encode(*v)
+
+	return buffer.Bytes(), err
+}
+
+func (v *RingVersionEntry) Unmarshal(buffer []byte) error {
+	dec := gob.NewDecoder(bytes.NewReader(buffer))
+	err := dec.Decode(v)
+	if err != nil {
+		return err
+	}
+
+	// Make sure to setup arrays if nil
+	if v.Info.Devices == nil {
+		v.Info.Devices = make([]*RingVersionDevice, 0)
+	}
+
+	return nil
+}
+
+// NewSummary returns the version without its topology
+func (v *RingVersionEntry) NewSummary() *RingVersionSummary {
+	return &RingVersionSummary{
+		Version:      v.Info.Version,
+		Created:      v.Info.Created,
+		Author:       v.Info.Author,
+		RingHash:     v.Info.RingHash,
+		ChangedParts: v.Info.ChangedParts,
+		Balance:      v.Info.Balance,
+		Devices:      len(v.Info.Devices),
+	}
+}
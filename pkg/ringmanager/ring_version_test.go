@@ -0,0 +1,202 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+func getRingVersions(t *testing.T, ringId string) *RingVersionListResponse {
+	r, err := http.Get(ts.URL + "/rings/" + ringId + "/versions")
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+
+	var list RingVersionListResponse
+	err = GetJsonFromResponse(r, &list)
+	assert.Nil(t, err)
+	return &list
+}
+
+func TestRingVersions(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	ringId := setupTopology(t, id, "object", 3)
+
+	// No build yet
+	list := getRingVersions(t, ringId)
+	assert.Equal(t, 0, list.Current)
+	assert.Empty(t, list.Versions)
+
+	msg := runBuildWithQuery(t, id, "?author=alice")
+	assert.Equal(t, 1, msg.Rings[0].Version)
+	ringPath := filepath.Join(ringManagerDir, id, "object.ring.gz")
+	hash, err := PathHash(ringPath)
+	assert.Nil(t, err)
+
+	nodeId := setupNode(t, ringId, "127.0.0.4", 4)
+	setupDevice(t, nodeId, "sdb1", 100)
+	msg = runBuildWithQuery(t, id, "?author=bob")
+	assert.Equal(t, 2, msg.Rings[0].Version)
+
+	list = getRingVersions(t, ringId)
+	assert.Equal(t, 2, list.Current)
+	assert.Equal(t, 2, len(list.Versions))
+	assert.Equal(t, "alice", list.Versions[0].Author)
+	assert.Equal(t, hash, list.Versions[0].RingHash)
+	assert.Equal(t, 3, list.Versions[0].Devices)
+	assert.Equal(t, "bob", list.Versions[1].Author)
+	assert.Equal(t, 4, list.Versions[1].Devices)
+	assert.False(t, list.Versions[1].Created.Before(list.Versions[0].Created))
+
+	// The topology is kept with the version
+	r, err := http.Get(ts.URL + "/rings/" + ringId + "/versions/1")
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+	var info RingVersionInfo
+	err = GetJsonFromResponse(r, &info)
+	assert.Nil(t, err)
+	assert.Equal(t, ringId, info.RingId)
+	assert.Equal(t, 3, len(info.Devices))
+	assert.Equal(t, "sdb1", info.Devices[0].Name)
+	assert.Equal(t, uint64(100), info.Devices[0].Weight)
+
+	r, err = http.Get(ts.URL + "/rings/" + ringId + "/versions/3")
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusNotFound)
+
+	r, err = http.Get(ts.URL + "/rings/12345/versions")
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusNotFound)
+}
+
+func TestRingVersionsExpire(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	ringVersionsKept = 2
+	defer func() { ringVersionsKept = 0 }()
+
+	ringId := setupTopology(t, id, "object", 3)
+	runBuild(t, id)
+	for z := 4; z <= 5; z++ {
+		nodeId := setupNode(t, ringId, fmt.Sprintf("127.0.0.%d", z), z)
+		setupDevice(t, nodeId, "sdb1", 100)
+		runBuild(t, id)
+	}
+
+	list := getRingVersions(t, ringId)
+	assert.Equal(t, 3, list.Current)
+	assert.Equal(t, 2, len(list.Versions))
+	assert.Equal(t, 2, list.Versions[0].Version)
+	assert.Equal(t, 3, list.Versions[1].Version)
+
+	r, err := http.Get(ts.URL + "/rings/" + ringId + "/versions/1")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, r.StatusCode)
+	_, err = os.Stat(filepath.Join(ringManagerDir, id, "versions", ringId, "1"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(ringManagerDir, id, "versions", ringId, "2"))
+	assert.Nil(t, err)
+}
+
+func TestRingRollback(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	ringId := setupTopology(t, id, "object", 3)
+	runBuild(t, id)
+	ringPath := filepath.Join(ringManagerDir, id, "object.ring.gz")
+	hash, err := PathHash(ringPath)
+	assert.Nil(t, err)
+
+	nodeId := setupNode(t, ringId, "127.0.0.4", 4)
+	deviceId := setupDevice(t, nodeId, "sdb1", 100)
+	runBuild(t, id)
+
+	r, err := http.Post(ts.URL+"/rings/"+ringId+"/rollback/1", "application/json", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+
+	var ring RingInfoResponse
+	err = GetJsonFromResponse(r, &ring)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, ring.Version)
+
+	// The files of the first build are back
+	rolledBack, err := PathHash(ringPath)
+	assert.Nil(t, err)
+	assert.Equal(t, hash, rolledBack)
+
+	// The device added since then is not in the builder anymore
+	var device *DeviceEntry
+	err = db.View(func(tx *bolt.Tx) error {
+		var err error
+		device, err = NewDeviceEntryFromId(tx, deviceId)
+		return err
+	})
+	assert.Nil(t, err)
+	assert.False(t, device.InBuilder)
+
+	// and the next build adds it again as a new version
+	msg := runBuild(t, id)
+	assert.Equal(t, 3, msg.Rings[0].Version)
+	assert.Equal(t, []string{deviceId}, msg.Rings[0].DevicesAdded)
+	assert.Empty(t, msg.Rings[0].DevicesRemoved)
+
+	list := getRingVersions(t, ringId)
+	assert.Equal(t, 3, list.Current)
+	assert.Equal(t, 3, len(list.Versions))
+
+	r, err = http.Post(ts.URL+"/rings/"+ringId+"/rollback/7", "application/json", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusNotFound)
+}
+
+func TestRingDeleteVersions(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	ringId := setupTopology(t, id, "object", 3)
+	runBuild(t, id)
+
+	res := httpDelete(t, ts.URL+"/clusters/"+id+"?cascade=true")
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+
+	err := db.View(func(tx *bolt.Tx) error {
+		_, err := NewRingVersionEntryFromId(tx, ringId, 1)
+		return err
+	})
+	assert.Equal(t, ErrNotFound, err)
+
+	_, err = os.Stat(filepath.Join(ringManagerDir, id))
+	assert.True(t, os.IsNotExist(err))
+}
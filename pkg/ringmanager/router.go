@@ -29,6 +29,10 @@ import (
 var db *bolt.DB
 var dbReadOnly bool
 var ringManagerDir string
+var builderBackend RingBuilderBackend
+var deviceWeightStep *weightStep
+var partitionSize uint64
+var ringVersionsKept int
 
 const (
 	ASYNC_ROUTE           = "/queue"
@@ -36,19 +40,52 @@ const (
 	BOLTDB_BUCKET_RING    = "RING"
 	BOLTDB_BUCKET_NODE    = "NODE"
 	BOLTDB_BUCKET_DEVICE  = "DEVICE"
+
+	BOLTDB_BUCKET_RINGVERSION = "RINGVERSION"
+	BOLTDB_BUCKET_AGENT       = "AGENT"
 )
 
 type App struct {
 	asyncManager *rest.AsyncHttpManager
+	buildJobs    *buildJobs
 }
 
-func NewRouter(conf *viper.Viper) *mux.Router {
+var app = &App{}
+
+func NewRouter(conf *viper.Viper) (*mux.Router, error) {
 
 	var err error
 	ringManagerDir = conf.GetString("ringmanager_dir")
 	dbfilename := conf.GetString("dbfilename")
 	dbFilePath := filepath.Join(ringManagerDir, dbfilename)
 
+	// Builds run in the background
+	app.asyncManager = rest.NewAsyncHttpManager(ASYNC_ROUTE)
+	app.buildJobs = newBuildJobs()
+
+	// Setup the ring builder backend
+	builderBackend, err = NewRingBuilderBackend(conf.GetString("ring_builder_backend"),
+		conf.GetString("swift_ring_builder_path"))
+	if err != nil {
+		return nil, err
+	}
+
+	// How much the weight of a device changes on every build
+	deviceWeightStep, err = parseWeightStep(conf.GetString("weight_step"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Versions of each ring kept for rollbacks, all of them if 0
+	ringVersionsKept = conf.GetInt("ring_versions")
+
+	// Average size of a replica of a partition, to estimate the data
+	// moved by a rebalance
+	partitionSize = DEFAULT_PARTITION_SIZE
+	if size := conf.GetInt64("partition_size"); size > 0 {
+		partitionSize = uint64(size)
+	}
+
 	// Setup BoltDB database
 	db, err = bolt.Open(dbFilePath, 0600, &bolt.Options{Timeout: 3 * time.Second})
 	if err != nil {
@@ -91,6 +128,20 @@ func NewRouter(conf *viper.Viper) *mux.Router {
 				return err
 			}
 
+			// Create Ring Version Bucket
+			_, err = tx.CreateBucketIfNotExists([]byte(BOLTDB_BUCKET_RINGVERSION))
+			if err != nil {
+				//logger.LogError("Unable to create ring version bucket in DB")
+				return err
+			}
+
+			// Create Agent Bucket
+			_, err = tx.CreateBucketIfNotExists([]byte(BOLTDB_BUCKET_AGENT))
+			if err != nil {
+				//logger.LogError("Unable to create agent bucket in DB")
+				return err
+			}
+
 			return nil
 
 		})
@@ -113,5 +164,5 @@ func NewRouter(conf *viper.Viper) *mux.Router {
 
 	}
 
-	return router
+	return router, nil
 }
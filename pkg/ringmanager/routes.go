@@ -73,6 +73,30 @@ var routes = Routes{
 		"/rings/{id:[A-Fa-f0-9]+}",
 		RingInformation,
 	},
+	Route{
+		"RingVersionList",
+		"GET",
+		"/rings/{id:[A-Fa-f0-9]+}/versions",
+		RingVersionList,
+	},
+	Route{
+		"RingVersionInfo",
+		"GET",
+		"/rings/{id:[A-Fa-f0-9]+}/versions/{version:[0-9]+}",
+		RingVersionInformation,
+	},
+	Route{
+		"RingRollback",
+		"POST",
+		"/rings/{id:[A-Fa-f0-9]+}/rollback/{version:[0-9]+}",
+		RingRollback,
+	},
+	Route{
+		"RingReport",
+		"GET",
+		"/rings/{id:[A-Fa-f0-9]+}/report",
+		RingReport,
+	},
 	Route{
 		"RingDelete",
 		"DELETE",
@@ -93,6 +117,18 @@ var routes = Routes{
 		"/nodes/{id:[A-Fa-f0-9]+}",
 		NodeInformation,
 	},
+	Route{
+		"NodeUpdate",
+		"PATCH",
+		"/nodes/{id:[A-Fa-f0-9]+}",
+		NodeUpdate,
+	},
+	Route{
+		"NodeDrain",
+		"POST",
+		"/nodes/{id:[A-Fa-f0-9]+}/drain",
+		NodeDrain,
+	},
 	Route{
 		"NodeDelete",
 		"DELETE",
@@ -113,6 +149,18 @@ var routes = Routes{
 		"/devices/{id:[A-Fa-f0-9]+}",
 		DeviceInformation,
 	},
+	Route{
+		"DeviceUpdate",
+		"PATCH",
+		"/devices/{id:[A-Fa-f0-9]+}",
+		DeviceUpdate,
+	},
+	Route{
+		"DeviceSetWeight",
+		"PUT",
+		"/devices/{id:[A-Fa-f0-9]+}/weight",
+		DeviceSetWeight,
+	},
 	Route{
 		"DeviceDelete",
 		"DELETE",
@@ -127,10 +175,64 @@ var routes = Routes{
 		"/buildring/{id:[A-Fa-f0-9]+}",
 		BuildRing,
 	},
+	Route{
+		"QueueStatus",
+		"GET",
+		ASYNC_ROUTE + "/{id:[A-Fa-f0-9]+}",
+		QueueStatus,
+	},
+	Route{
+		"BuildJobInfo",
+		"GET",
+		"/buildjobs/{id:[A-Fa-f0-9]+}",
+		BuildJobInformation,
+	},
+	Route{
+		"ClusterExport",
+		"GET",
+		"/clusters/{id:[A-Fa-f0-9]+}/export",
+		ClusterExport,
+	},
+	Route{
+		"ClusterApply",
+		"POST",
+		"/clusters/{id:[A-Fa-f0-9]+}/apply",
+		ClusterApply,
+	},
+	Route{
+		"ClusterSwiftConf",
+		"GET",
+		"/clusters/{id:[A-Fa-f0-9]+}/swift.conf",
+		ClusterSwiftConf,
+	},
+	Route{
+		"ClusterImport",
+		"POST",
+		"/clusters/{id:[A-Fa-f0-9]+}/import",
+		ClusterImport,
+	},
+	Route{
+		"DeploymentStatus",
+		"GET",
+		"/clusters/{id:[A-Fa-f0-9]+}/deployment",
+		DeploymentStatus,
+	},
+	Route{
+		"DeploymentReport",
+		"POST",
+		"/clusters/{id:[A-Fa-f0-9]+}/deployment",
+		DeploymentReport,
+	},
 	Route{
 		"DownloadRing",
 		"GET",
 		"/downloadring/{id:[A-Fa-f0-9]+}/{ring}",
 		DownloadRing,
 	},
+	Route{
+		"DownloadRingHead",
+		"HEAD",
+		"/downloadring/{id:[A-Fa-f0-9]+}/{ring}",
+		DownloadRing,
+	},
 }
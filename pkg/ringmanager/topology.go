@@ -0,0 +1,833 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringbuilder"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	TOPOLOGY_ADD    = "add"
+	TOPOLOGY_UPDATE = "update"
+	TOPOLOGY_REMOVE = "remove"
+
+	TOPOLOGY_RING   = "ring"
+	TOPOLOGY_NODE   = "node"
+	TOPOLOGY_DEVICE = "device"
+)
+
+// topologyError is a topology that can not be applied to the cluster as
+// it is, with the status to answer
+type topologyError struct {
+	status int
+	msg    string
+}
+
+func (e *topologyError) Error() string {
+	return e.msg
+}
+
+func newTopologyConflict(format string, a ...interface{}) error {
+	return &topologyError{status: http.StatusConflict, msg: fmt.Sprintf(format, a...)}
+}
+
+func (n *TopologyNode) address() string {
+	return n.Ip + ":" + n.Port
+}
+
+// failureDomains returns the number of servers and of devices with weight
+// of the ring
+func (r *TopologyRing) failureDomains() (int, int) {
+	servers := make(map[string]bool)
+	devices := 0
+	for _, node := range r.Nodes {
+		for _, device := range node.Devices {
+			if device.Weight > 0 {
+				servers[node.Ip] = true
+				devices++
+			}
+		}
+	}
+	return len(servers), devices
+}
+
+func nodeAddress(node *NodeEntry) string {
+	return node.Info.Ip + ":" + node.Info.Port
+}
+
+// validateTopology checks the document and fills in the defaults of the
+// missing fields, the same way adding each item would
+func validateTopology(t *Topology) error {
+	rings := make(map[string]*TopologyRing)
+	composites := make([]*TopologyRing, 0)
+	policies := make([]*StoragePolicy, 0)
+	for _, ring := range t.Rings {
+		if ring == nil || len(ring.Name) == 0 {
+			return fmt.Errorf("Ring name missing")
+		}
+		if rings[ring.Name] != nil {
+			return fmt.Errorf("Ring %v is in the topology twice", ring.Name)
+		}
+		rings[ring.Name] = ring
+
+		if ring.MinPartHours == nil {
+			minPartHours := RING_DEFAULT_MIN_PART_HOURS
+			ring.MinPartHours = &minPartHours
+		}
+		if *ring.MinPartHours < 0 {
+			return fmt.Errorf("Ring %v: %v", ring.Name, ringbuilder.ErrMinPartHours)
+		}
+
+		var err error
+		ring.Type, ring.Policy, err = newRingPolicy(ring.Name, ring.Type, ring.Policy)
+		if err != nil {
+			return err
+		}
+		if ring.Policy != nil {
+			policies = append(policies, ring.Policy)
+		}
+
+		// Checked once every ring is known
+		if len(ring.Components) > 0 {
+			if len(ring.Nodes) > 0 {
+				return fmt.Errorf("Ring %v is composite, its nodes belong to its components", ring.Name)
+			}
+			composites = append(composites, ring)
+			continue
+		}
+
+		if ring.PartPower == 0 {
+			ring.PartPower = RING_DEFAULT_PART_POWER
+		}
+		if ring.PartPower < 1 || ring.PartPower > RING_MAX_PART_POWER {
+			return fmt.Errorf("Ring %v: %v", ring.Name, ErrPartPower)
+		}
+		ring.Replicas, err = ringReplicas(ring.Name, ring.Replicas, ring.Policy)
+		if err != nil {
+			return err
+		}
+		if ring.Replicas < 1 || ring.Replicas > ringbuilder.MaxReplicas {
+			return fmt.Errorf("Ring %v: %v", ring.Name, ringbuilder.ErrReplicas)
+		}
+
+		nodes := make(map[string]bool)
+		for _, node := range ring.Nodes {
+			if node == nil || len(node.Ip) == 0 {
+				return fmt.Errorf("Ring %v: node ip missing", ring.Name)
+			}
+			if !isValidPort(node.Port) {
+				return fmt.Errorf("Ring %v: node %v has an invalid port", ring.Name, node.Ip)
+			}
+			if nodes[node.address()] {
+				return fmt.Errorf("Ring %v: node %v is in the topology twice", ring.Name, node.address())
+			}
+			nodes[node.address()] = true
+
+			if node.Region < 1 {
+				node.Region = 1
+			}
+			if node.Zone < 1 {
+				node.Zone = 1
+			}
+			if node.ReplicationIP == "" {
+				node.ReplicationIP = node.Ip
+			}
+			if node.ReplicationPort == "" {
+				node.ReplicationPort = node.Port
+			} else if !isValidPort(node.ReplicationPort) {
+				return fmt.Errorf("Ring %v: node %v has an invalid replication port", ring.Name, node.address())
+			}
+
+			devices := make(map[string]bool)
+			for _, device := range node.Devices {
+				if device == nil || len(device.Name) == 0 {
+					return fmt.Errorf("Ring %v: node %v: device name missing", ring.Name, node.address())
+				}
+				if devices[device.Name] {
+					return fmt.Errorf("Ring %v: node %v: device %v is in the topology twice",
+						ring.Name, node.address(), device.Name)
+				}
+				devices[device.Name] = true
+			}
+		}
+
+		// Rings are often declared before their nodes
+		if len(ring.Nodes) > 0 {
+			nodes, devices := ring.failureDomains()
+			err = checkFailureDomains(ring.Name, ring.Policy, nodes, devices)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err := validateComposites(rings, composites)
+	if err != nil {
+		return err
+	}
+	return validatePolicies(policies)
+}
+
+// validateComposites gives the composite rings the part power and
+// replicas of their components, each a component ring of the topology
+// composed into a single ring
+func validateComposites(rings map[string]*TopologyRing, composites []*TopologyRing) error {
+	composed := make(map[string]string)
+	for _, ring := range composites {
+		components := make([]*RingAddRequest, len(ring.Components))
+		nodes, devices := 0, 0
+		for i, name := range ring.Components {
+			c, ok := rings[name]
+			if !ok {
+				return fmt.Errorf("Ring %v: component ring %v is not in the topology", ring.Name, name)
+			}
+			if other, ok := composed[name]; ok && other != ring.Name {
+				return fmt.Errorf("Ring %v is a component of rings %v and %v", name, other, ring.Name)
+			}
+			composed[name] = ring.Name
+
+			components[i] = &RingAddRequest{
+				Name:      c.Name,
+				PartPower: c.PartPower,
+				Replicas:  c.Replicas,
+				Type:      c.Type,
+			}
+			n, d := c.failureDomains()
+			nodes += n
+			devices += d
+		}
+
+		partPower, replicas, err := compositeParameters(ring.Name, ring.Type, components)
+		if err != nil {
+			return err
+		}
+		replicas, err = ringReplicas(ring.Name, replicas, ring.Policy)
+		if err != nil {
+			return err
+		}
+		if (ring.PartPower != 0 && ring.PartPower != partPower) ||
+			(ring.Replicas != 0 && ring.Replicas != replicas) {
+			return fmt.Errorf("Ring %v: a composite ring has the part power and replicas of its components",
+				ring.Name)
+		}
+		ring.PartPower = partPower
+		ring.Replicas = replicas
+
+		if nodes > 0 {
+			err = checkFailureDomains(ring.Name, ring.Policy, nodes, devices)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// topologyApply brings the cluster to the topology inside the
+// transaction.  Devices that still hold partitions are only removed when
+// forced.  It returns the changes made and the rings removed, whose files
+// are archived once everything else succeeded.
+type topologyApply struct {
+	tx      *bolt.Tx
+	force   bool
+	changes []*TopologyChange
+	removed []*RingEntry
+}
+
+func (a *topologyApply) change(action, kind, name, id string, changes ...string) {
+	a.changes = append(a.changes, &TopologyChange{
+		Action:  action,
+		Kind:    kind,
+		Name:    name,
+		Id:      id,
+		Changes: changes,
+	})
+}
+
+// topologyDiff describes a field going from one value to another, if it changed
+func topologyDiff(changes []string, field string, from, to interface{}) []string {
+	if from == to {
+		return changes
+	}
+	return append(changes, fmt.Sprintf("%v: %v -> %v", field, from, to))
+}
+
+// policyDiff adds the changes of the storage policy of a ring.  Its
+// index is given by the name of the ring and never changes.
+func policyDiff(changes []string, from, to *StoragePolicy) []string {
+	if from == nil || to == nil {
+		if from != nil || to != nil {
+			changes = append(changes, "policy changed")
+		}
+		return changes
+	}
+	changes = topologyDiff(changes, "policy.name", from.Name, to.Name)
+	changes = topologyDiff(changes, "policy.aliases",
+		strings.Join(from.Aliases, ", "), strings.Join(to.Aliases, ", "))
+	changes = topologyDiff(changes, "policy.default", from.Default, to.Default)
+	changes = topologyDiff(changes, "policy.deprecated", from.Deprecated, to.Deprecated)
+	changes = topologyDiff(changes, "policy.policy_type", from.PolicyType, to.PolicyType)
+	return ecPolicyDiff(changes, from, to)
+}
+
+func ecPolicyDiff(changes []string, from, to *StoragePolicy) []string {
+	if from == nil || to == nil {
+		return changes
+	}
+	changes = topologyDiff(changes, "policy.ec_type", from.EcType, to.EcType)
+	changes = topologyDiff(changes, "policy.ec_num_data_fragments",
+		from.EcNumDataFragments, to.EcNumDataFragments)
+	changes = topologyDiff(changes, "policy.ec_num_parity_fragments",
+		from.EcNumParityFragments, to.EcNumParityFragments)
+	changes = topologyDiff(changes, "policy.ec_object_segment_size",
+		from.EcObjectSegmentSize, to.EcObjectSegmentSize)
+	return changes
+}
+
+func (a *topologyApply) cluster(cluster *ClusterEntry, t *Topology) error {
+	existing := make(map[string]*RingEntry)
+	ringNames := make(map[string]string)
+	for _, ringId := range cluster.Info.Rings {
+		ring, err := NewRingEntryFromId(a.tx, ringId)
+		if err != nil {
+			return err
+		}
+		existing[ring.Info.Name] = ring
+		ringNames[ring.Info.Id] = ring.Info.Name
+	}
+
+	rings := make(map[string]*RingEntry)
+	for _, doc := range t.Rings {
+		ring, ok := existing[doc.Name]
+		delete(existing, doc.Name)
+
+		if !ok {
+			ring = NewRingEntryFromRequest(&RingAddRequest{
+				ClusterId:    cluster.Info.Id,
+				Name:         doc.Name,
+				PartPower:    doc.PartPower,
+				Replicas:     doc.Replicas,
+				MinPartHours: *doc.MinPartHours,
+				Type:         doc.Type,
+				Policy:       doc.Policy,
+			})
+			err := ring.Register(a.tx)
+			if err != nil {
+				return err
+			}
+			cluster.RingAdd(ring.Info.Id)
+			a.change(TOPOLOGY_ADD, TOPOLOGY_RING, doc.Name, ring.Info.Id)
+		} else {
+			err := a.updateRing(ring, doc)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := a.nodes(ring, doc)
+		if err != nil {
+			return err
+		}
+		err = ring.Save(a.tx)
+		if err != nil {
+			return err
+		}
+		rings[doc.Name] = ring
+	}
+
+	// Components may come after their composite ring in the topology
+	for _, doc := range t.Rings {
+		err := a.components(rings[doc.Name], doc, rings, ringNames)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Rings left are not in the topology anymore
+	names := make([]string, 0, len(existing))
+	for name := range existing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ring := existing[name]
+		err := a.nodes(ring, &TopologyRing{Name: name})
+		if err != nil {
+			return err
+		}
+		err = ring.Delete(a.tx)
+		if err != nil {
+			return err
+		}
+		cluster.RingDelete(ring.Info.Id)
+		a.removed = append(a.removed, ring)
+		a.change(TOPOLOGY_REMOVE, TOPOLOGY_RING, name, ring.Info.Id)
+	}
+
+	return cluster.Save(a.tx)
+}
+
+func (a *topologyApply) updateRing(ring *RingEntry, doc *TopologyRing) error {
+	changes := topologyDiff(nil, "part_power", ring.Info.PartPower, doc.PartPower)
+	changes = topologyDiff(changes, "replicas", ring.Info.Replicas, doc.Replicas)
+
+	// The builder is created with the part power and replicas of the
+	// ring on the first build
+	if len(changes) > 0 && ring.LastVersion > 0 {
+		return newTopologyConflict("Ring %v is built, its part power and replicas can not change",
+			ring.Info.Name)
+	}
+	changes = topologyDiff(changes, "min_part_hours", ring.Info.MinPartHours, *doc.MinPartHours)
+	changes = topologyDiff(changes, "type", ring.Info.Type, doc.Type)
+
+	// Fragments already stored are unreadable with other parameters
+	ecChanges := ecPolicyDiff(nil, ring.Info.Policy, doc.Policy)
+	if len(ecChanges) > 0 && ring.LastVersion > 0 {
+		return newTopologyConflict("Ring %v is built, its erasure coding parameters can not change",
+			ring.Info.Name)
+	}
+	changes = policyDiff(changes, ring.Info.Policy, doc.Policy)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	ring.Info.PartPower = doc.PartPower
+	ring.Info.Replicas = doc.Replicas
+	ring.Info.MinPartHours = *doc.MinPartHours
+	ring.Info.Type = doc.Type
+	ring.Info.Policy = doc.Policy
+	a.change(TOPOLOGY_UPDATE, TOPOLOGY_RING, ring.Info.Name, ring.Info.Id, changes...)
+
+	return nil
+}
+
+// components makes the rings named by the document the components of the
+// ring, the rings of the topology being already added.  names are the
+// rings of the cluster before the topology, by id.
+func (a *topologyApply) components(ring *RingEntry, doc *TopologyRing,
+	rings map[string]*RingEntry, names map[string]string) error {
+
+	ids := make([]string, len(doc.Components))
+	for i, name := range doc.Components {
+		ids[i] = rings[name].Info.Id
+	}
+	from := make([]string, len(ring.Info.Components))
+	for i, id := range ring.Info.Components {
+		from[i] = names[id]
+	}
+	if strings.Join(from, ", ") == strings.Join(doc.Components, ", ") {
+		return nil
+	}
+
+	// The composite metadata keeps the components it was composed from
+	if ring.LastVersion > 0 {
+		return newTopologyConflict("Ring %v is built, its components can not change", ring.Info.Name)
+	}
+	if _, ok := names[ring.Info.Id]; ok {
+		a.change(TOPOLOGY_UPDATE, TOPOLOGY_RING, ring.Info.Name, ring.Info.Id,
+			topologyDiff(nil, "components", strings.Join(from, ", "), strings.Join(doc.Components, ", "))...)
+	}
+	ring.Info.Components = ids
+
+	return ring.Save(a.tx)
+}
+
+// nodes brings the nodes of the ring to the ones of the document.  The
+// ring still needs to be saved.
+func (a *topologyApply) nodes(ring *RingEntry, doc *TopologyRing) error {
+	parts, err := ringPartsByDevice(ring)
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]*NodeEntry)
+	order := make([]string, 0, len(ring.Nodes))
+	for _, nodeId := range ring.Nodes {
+		node, err := NewNodeEntryFromId(a.tx, nodeId)
+		if err != nil {
+			return err
+		}
+		if _, ok := existing[nodeAddress(node)]; !ok {
+			order = append(order, nodeAddress(node))
+		}
+		existing[nodeAddress(node)] = node
+	}
+
+	for _, docNode := range doc.Nodes {
+		name := ring.Info.Name + "/" + docNode.address()
+		node, ok := existing[docNode.address()]
+		delete(existing, docNode.address())
+
+		if !ok {
+			node = NewNodeEntryFromRequest(&NodeAddRequest{
+				RingId:          ring.Info.Id,
+				Region:          docNode.Region,
+				Zone:            docNode.Zone,
+				Ip:              docNode.Ip,
+				ReplicationIP:   docNode.ReplicationIP,
+				Port:            docNode.Port,
+				ReplicationPort: docNode.ReplicationPort,
+			})
+			err := node.Register(a.tx)
+			if err != nil {
+				return err
+			}
+			ring.NodeAdd(node.Info.Id)
+			a.change(TOPOLOGY_ADD, TOPOLOGY_NODE, name, node.Info.Id)
+		} else {
+			changes := topologyDiff(nil, "region", node.Info.Region, docNode.Region)
+			changes = topologyDiff(changes, "zone", node.Info.Zone, docNode.Zone)
+			changes = topologyDiff(changes, "replicationIP", node.Info.ReplicationIP, docNode.ReplicationIP)
+			changes = topologyDiff(changes, "replicationPort", node.Info.ReplicationPort, docNode.ReplicationPort)
+			if len(changes) > 0 {
+				node.Update(&NodeUpdateRequest{
+					Region:          &docNode.Region,
+					Zone:            &docNode.Zone,
+					ReplicationIP:   &docNode.ReplicationIP,
+					ReplicationPort: &docNode.ReplicationPort,
+				})
+				a.change(TOPOLOGY_UPDATE, TOPOLOGY_NODE, name, node.Info.Id, changes...)
+			}
+		}
+
+		err := a.devices(node, docNode, name, parts)
+		if err != nil {
+			return err
+		}
+		err = node.Save(a.tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Nodes left are not in the topology anymore
+	for _, address := range order {
+		node, ok := existing[address]
+		if !ok {
+			continue
+		}
+		name := ring.Info.Name + "/" + address
+		err := a.devices(node, &TopologyNode{}, name, parts)
+		if err != nil {
+			return err
+		}
+		err = node.Delete(a.tx)
+		if err != nil {
+			return err
+		}
+		ring.NodeDelete(node.Info.Id)
+		a.change(TOPOLOGY_REMOVE, TOPOLOGY_NODE, name, node.Info.Id)
+	}
+
+	return nil
+}
+
+// devices brings the devices of the node to the ones of the document.
+// The node still needs to be saved.
+func (a *topologyApply) devices(node *NodeEntry, doc *TopologyNode, nodeName string, parts map[int]int) error {
+	existing := make(map[string]*DeviceEntry)
+	order := make([]string, 0, len(node.Devices))
+	for _, deviceId := range node.Devices {
+		device, err := NewDeviceEntryFromId(a.tx, deviceId)
+		if err != nil {
+			return err
+		}
+		existing[device.Info.Name] = device
+		order = append(order, device.Info.Name)
+	}
+
+	for _, docDevice := range doc.Devices {
+		name := nodeName + "/" + docDevice.Name
+		device, ok := existing[docDevice.Name]
+		delete(existing, docDevice.Name)
+
+		if !ok {
+			req := &DeviceAddRequest{NodeId: node.Info.Id, Weight: docDevice.Weight}
+			req.Name = docDevice.Name
+			req.Meta = docDevice.Meta
+			device = NewDeviceEntryFromRequest(req)
+			err := device.Register(a.tx)
+			if err != nil {
+				return err
+			}
+			node.DeviceAdd(device.Info.Id)
+			a.change(TOPOLOGY_ADD, TOPOLOGY_DEVICE, name, device.Info.Id)
+		} else {
+			// Like setting the weight, the builds move the current
+			// weight to the target
+			changes := topologyDiff(nil, "meta", device.Info.Meta, docDevice.Meta)
+			changes = topologyDiff(changes, "weight", device.Info.Weight.Target, docDevice.Weight)
+			if len(changes) == 0 {
+				continue
+			}
+			device.Info.Meta = docDevice.Meta
+			device.Info.Weight.Target = docDevice.Weight
+			a.change(TOPOLOGY_UPDATE, TOPOLOGY_DEVICE, name, device.Info.Id, changes...)
+		}
+
+		err := device.Save(a.tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Devices left are not in the topology anymore
+	for _, deviceName := range order {
+		device, ok := existing[deviceName]
+		if !ok {
+			continue
+		}
+		err := deviceDelete(a.tx, node, device, parts, a.force)
+		if err == ErrConflict {
+			return newTopologyConflict("%v: %v", nodeName, device.ConflictString(parts[device.BuilderId]))
+		} else if err != nil {
+			return err
+		}
+		a.change(TOPOLOGY_REMOVE, TOPOLOGY_DEVICE, nodeName+"/"+deviceName, device.Info.Id)
+	}
+
+	return nil
+}
+
+// NewTopology returns the topology of the cluster.  Rings are sorted by
+// name, nodes by address and devices by name, so that the document of a
+// cluster only changes with the cluster.
+func NewTopology(tx *bolt.Tx, cluster *ClusterEntry) (*Topology, error) {
+	topology := &Topology{
+		Rings: make([]*TopologyRing, 0, len(cluster.Info.Rings)),
+	}
+	names := make(map[string]string)
+
+	for _, ringId := range cluster.Info.Rings {
+		ring, err := NewRingEntryFromId(tx, ringId)
+		if err != nil {
+			return nil, err
+		}
+
+		minPartHours := ring.Info.MinPartHours
+		docRing := &TopologyRing{
+			Components:   ring.Info.Components,
+			Name:         ring.Info.Name,
+			PartPower:    ring.Info.PartPower,
+			Replicas:     ring.Info.Replicas,
+			MinPartHours: &minPartHours,
+			Nodes:        make([]*TopologyNode, 0, len(ring.Nodes)),
+		}
+		docRing.Type, docRing.Policy = ringPolicy(&ring.Info.RingAddRequest)
+
+		for _, nodeId := range ring.Nodes {
+			node, err := NewNodeEntryFromId(tx, nodeId)
+			if err != nil {
+				return nil, err
+			}
+
+			docNode := &TopologyNode{
+				Region:          node.Info.Region,
+				Zone:            node.Info.Zone,
+				Ip:              node.Info.Ip,
+				Port:            node.Info.Port,
+				ReplicationIP:   node.Info.ReplicationIP,
+				ReplicationPort: node.Info.ReplicationPort,
+				Devices:         make([]*TopologyDevice, 0, len(node.Devices)),
+			}
+
+			for _, deviceId := range node.Devices {
+				device, err := NewDeviceEntryFromId(tx, deviceId)
+				if err != nil {
+					return nil, err
+				}
+
+				docNode.Devices = append(docNode.Devices, &TopologyDevice{
+					Name:   device.Info.Name,
+					Meta:   device.Info.Meta,
+					Weight: device.Info.Weight.Target,
+				})
+			}
+			sort.Slice(docNode.Devices, func(i, j int) bool {
+				return docNode.Devices[i].Name < docNode.Devices[j].Name
+			})
+			docRing.Nodes = append(docRing.Nodes, docNode)
+		}
+		sort.Slice(docRing.Nodes, func(i, j int) bool {
+			return docRing.Nodes[i].address() < docRing.Nodes[j].address()
+		})
+		topology.Rings = append(topology.Rings, docRing)
+		names[ring.Info.Id] = ring.Info.Name
+	}
+
+	// Components are named like the rings of the document
+	for _, docRing := range topology.Rings {
+		components := make([]string, len(docRing.Components))
+		for i, id := range docRing.Components {
+			components[i] = names[id]
+		}
+		if len(components) > 0 {
+			docRing.Components = components
+		}
+	}
+	sort.Slice(topology.Rings, func(i, j int) bool {
+		return topology.Rings[i].Name < topology.Rings[j].Name
+	})
+
+	return topology, nil
+}
+
+// ClusterExport returns the topology of the cluster, as JSON or as YAML
+// with ?format=yaml or a YAML Accept header.  The document can be applied
+// back to a cluster.
+func ClusterExport(w http.ResponseWriter, r *http.Request) {
+	// Get the id from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var topology *Topology
+	err := db.View(func(tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		topology, err = NewTopology(tx, cluster)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// Write msg
+	if IsYaml(r.URL.Query().Get("format")) || IsYaml(r.Header.Get("Accept")) {
+		data, err := yaml.Marshal(topology)
+		if err != nil {
+			panic(err)
+		}
+		w.Header().Set("Content-Type", "application/x-yaml; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(topology); err != nil {
+		panic(err)
+	}
+}
+
+// ClusterApply brings the rings, nodes and devices of the cluster to a
+// topology document in JSON or YAML, in one transaction.  With ?plan the
+// changes are only listed, and with ?force devices holding partitions can
+// be removed.
+func ClusterApply(w http.ResponseWriter, r *http.Request) {
+	// Get the id from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+	plan := GetBoolFromQuery(r, "plan")
+
+	var topology Topology
+	err := GetDocumentFromRequest(r, &topology)
+	if err != nil {
+		http.Error(w, "request unable to be parsed", 422)
+		return
+	}
+
+	// check information in the document
+	err = validateTopology(&topology)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Do not archive the files of removed rings while a build is
+	// writing them
+	buildLock.Lock()
+	defer buildLock.Unlock()
+
+	apply := &topologyApply{
+		force:   GetBoolFromQuery(r, "force"),
+		changes: make([]*TopologyChange, 0),
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		apply.tx = tx
+		err = apply.cluster(cluster, &topology)
+		if e, ok := err.(*topologyError); ok {
+			http.Error(w, e.msg, e.status)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		// Nothing is kept when only planning
+		if plan {
+			return ErrDryRun
+		}
+
+		// Last, so that the rings are kept in the db if the files can
+		// not be moved
+		for _, ring := range apply.removed {
+			err = archiveRingFiles(ring)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil && err != ErrDryRun {
+		return
+	}
+
+	// Write msg
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	msg := &TopologyApplyResponse{
+		ClusterId: id,
+		Plan:      plan,
+		Changes:   apply.changes,
+	}
+	if err := json.NewEncoder(w).Encode(msg); err != nil {
+		panic(err)
+	}
+}
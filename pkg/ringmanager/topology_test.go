@@ -0,0 +1,303 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+const testTopology = `
+rings:
+- name: object
+  part_power: 8
+  min_part_hours: 0
+  nodes:
+  - ip: 127.0.0.1
+    port: "6010"
+    zone: 1
+    devices:
+    - name: sdb1
+      weight: 100
+    - name: sdc1
+      weight: 100
+  - ip: 127.0.0.2
+    port: "6010"
+    zone: 2
+    devices:
+    - name: sdb1
+      weight: 100
+  - ip: 127.0.0.3
+    port: "6010"
+    zone: 3
+    devices:
+    - name: sdb1
+      weight: 100
+`
+
+func applyTopology(t *testing.T, id, contentType, body, query string) *http.Response {
+	r, err := http.Post(ts.URL+"/clusters/"+id+"/apply"+query, contentType, bytes.NewBufferString(body))
+	assert.Nil(t, err)
+	return r
+}
+
+func applyTopologyChanges(t *testing.T, id, body, query string) *TopologyApplyResponse {
+	r := applyTopology(t, id, "application/x-yaml", body, query)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+
+	var msg TopologyApplyResponse
+	err := GetJsonFromResponse(r, &msg)
+	assert.Nil(t, err)
+	return &msg
+}
+
+// changeCount counts the changes by action and kind, like "add device"
+func changeCount(msg *TopologyApplyResponse) map[string]int {
+	count := make(map[string]int)
+	for _, change := range msg.Changes {
+		count[change.Action+" "+change.Kind]++
+	}
+	return count
+}
+
+func TestClusterApply(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	// Plan first, nothing changes
+	msg := applyTopologyChanges(t, id, testTopology, "?plan=true")
+	assert.True(t, msg.Plan)
+	assert.Equal(t, map[string]int{"add ring": 1, "add node": 3, "add device": 4}, changeCount(msg))
+	info, err := getClusterInfo(id)
+	assert.Nil(t, err)
+	assert.Empty(t, info.Rings)
+
+	msg = applyTopologyChanges(t, id, testTopology, "")
+	assert.False(t, msg.Plan)
+	assert.Equal(t, map[string]int{"add ring": 1, "add node": 3, "add device": 4}, changeCount(msg))
+	assert.Equal(t, "object/127.0.0.1:6010/sdc1", msg.Changes[3].Name)
+
+	info, err = getClusterInfo(id)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(info.Rings))
+	ring, err := getRingInfo(info.Rings[0])
+	assert.Nil(t, err)
+	assert.Equal(t, 8, ring.PartPower)
+	assert.Equal(t, 0, ring.MinPartHours)
+	assert.Equal(t, float64(RING_DEFAULT_REPLICAS), ring.Replicas)
+	assert.Equal(t, 3, len(ring.Nodes))
+
+	// Applying again changes nothing
+	msg = applyTopologyChanges(t, id, testTopology, "")
+	assert.Empty(t, msg.Changes)
+
+	// The topology is complete enough to build
+	result := runBuild(t, id)
+	assert.Equal(t, 4, len(result.Rings[0].DevicesAdded))
+
+	// Move a node, reweight a device, drop a device and add a node
+	changed := `
+rings:
+- name: object
+  part_power: 8
+  min_part_hours: 0
+  nodes:
+  - ip: 127.0.0.1
+    port: "6010"
+    zone: 1
+    devices:
+    - name: sdb1
+      weight: 200
+  - ip: 127.0.0.2
+    port: "6010"
+    zone: 4
+    devices:
+    - name: sdb1
+      weight: 100
+  - ip: 127.0.0.3
+    port: "6010"
+    zone: 3
+    devices:
+    - name: sdb1
+      weight: 100
+  - ip: 127.0.0.4
+    port: "6010"
+    zone: 5
+    devices:
+    - name: sdb1
+      weight: 100
+`
+	// sdc1 holds partitions
+	r := applyTopology(t, id, "application/x-yaml", changed, "")
+	assert.Equal(t, r.StatusCode, http.StatusConflict)
+
+	msg = applyTopologyChanges(t, id, changed, "?force=true")
+	assert.Equal(t, map[string]int{
+		"update device": 1,
+		"remove device": 1,
+		"update node":   1,
+		"add node":      1,
+		"add device":    1,
+	}, changeCount(msg))
+	for _, change := range msg.Changes {
+		switch change.Action + " " + change.Name {
+		case "update object/127.0.0.1:6010/sdb1":
+			assert.Equal(t, []string{"weight: 100 -> 200"}, change.Changes)
+		case "update object/127.0.0.2:6010":
+			assert.Equal(t, []string{"zone: 2 -> 4"}, change.Changes)
+		}
+	}
+
+	ring, err = getRingInfo(ring.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(ring.Nodes))
+	err = db.View(func(tx *bolt.Tx) error {
+		for _, nodeId := range ring.Nodes {
+			node, err := NewNodeEntryFromId(tx, nodeId)
+			assert.Nil(t, err)
+			assert.Equal(t, 1, len(node.Devices))
+			if node.Info.Ip == "127.0.0.2" {
+				assert.Equal(t, 4, node.Info.Zone)
+			}
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+
+	// The builder parameters of a built ring are fixed
+	r = applyTopology(t, id, "application/x-yaml", `{"rings":[{"name":"object", "part_power":9}]}`, "?force=true")
+	assert.Equal(t, r.StatusCode, http.StatusConflict)
+
+	// Remove the ring, in JSON this time
+	r = applyTopology(t, id, "application/json", `{"rings":[]}`, "?force=true")
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+	var removed TopologyApplyResponse
+	err = GetJsonFromResponse(r, &removed)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"remove ring": 1, "remove node": 4, "remove device": 4}, changeCount(&removed))
+
+	info, err = getClusterInfo(id)
+	assert.Nil(t, err)
+	assert.Empty(t, info.Rings)
+	_, err = os.Stat(filepath.Join(ringManagerDir, id, "archive", ring.Id, "object.builder"))
+	assert.Nil(t, err)
+}
+
+func TestClusterApplyInvalid(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	for _, doc := range []string{
+		`{"rings":[{"name":"object"}, {"name":"object"}]}`,
+		`{"rings":[{"name":""}]}`,
+		`{"rings":[{"name":"object", "replicas":-1}]}`,
+		`{"rings":[{"name":"object", "nodes":[{"ip":"127.0.0.1", "port":"abc"}]}]}`,
+		`{"rings":[{"name":"object", "nodes":[{"ip":"127.0.0.1", "port":"6010"}, {"ip":"127.0.0.1", "port":"6010"}]}]}`,
+		`{"rings":[{"name":"object", "nodes":[{"ip":"127.0.0.1", "port":"6010", "devices":[{"name":"sdb1"}, {"name":"sdb1"}]}]}]}`,
+	} {
+		r := applyTopology(t, id, "application/json", doc, "")
+		assert.Equal(t, r.StatusCode, http.StatusBadRequest, doc)
+	}
+
+	r := applyTopology(t, id, "application/json", "rings: []", "")
+	assert.Equal(t, r.StatusCode, 422)
+
+	r = applyTopology(t, "123", "application/json", `{"rings":[]}`, "")
+	assert.Equal(t, r.StatusCode, http.StatusNotFound)
+}
+
+func TestClusterExport(t *testing.T) {
+
+	// setup and teardown test case
+	id, tearDown := setupDatabase(t)
+	defer tearDown(t)
+
+	applyTopologyChanges(t, id, testTopology, "")
+
+	// Defaults are spelled out
+	r, err := http.Get(ts.URL + "/clusters/" + id + "/export")
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+	assert.Equal(t, "application/json; charset=UTF-8", r.Header.Get("Content-Type"))
+	var topology Topology
+	err = GetJsonFromResponse(r, &topology)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(topology.Rings))
+	ring := topology.Rings[0]
+	assert.Equal(t, "object", ring.Name)
+	assert.Equal(t, 8, ring.PartPower)
+	assert.Equal(t, float64(RING_DEFAULT_REPLICAS), ring.Replicas)
+	assert.Equal(t, 0, *ring.MinPartHours)
+	assert.Equal(t, 3, len(ring.Nodes))
+	for i, ip := range []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"} {
+		assert.Equal(t, ip, ring.Nodes[i].Ip)
+		assert.Equal(t, 1, ring.Nodes[i].Region)
+		assert.Equal(t, i+1, ring.Nodes[i].Zone)
+		assert.Equal(t, ip, ring.Nodes[i].ReplicationIP)
+	}
+	assert.Equal(t, []*TopologyDevice{
+		&TopologyDevice{Name: "sdb1", Weight: 100},
+		&TopologyDevice{Name: "sdc1", Weight: 100},
+	}, ring.Nodes[0].Devices)
+
+	// Same document in YAML, either way of asking
+	r, err = http.Get(ts.URL + "/clusters/" + id + "/export?format=yaml")
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusOK)
+	assert.Equal(t, "application/x-yaml; charset=UTF-8", r.Header.Get("Content-Type"))
+	body, err := ioutil.ReadAll(r.Body)
+	assert.Nil(t, err)
+	r.Body.Close()
+
+	req, err := http.NewRequest("GET", ts.URL+"/clusters/"+id+"/export", nil)
+	assert.Nil(t, err)
+	req.Header.Set("Accept", "application/x-yaml")
+	r, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	same, err := ioutil.ReadAll(r.Body)
+	assert.Nil(t, err)
+	r.Body.Close()
+	assert.Equal(t, string(body), string(same))
+
+	var fromYaml Topology
+	err = yaml.Unmarshal(body, &fromYaml)
+	assert.Nil(t, err)
+	assert.Equal(t, topology, fromYaml)
+
+	// Applying the export changes nothing, and it can seed another cluster
+	msg := applyTopologyChanges(t, id, string(body), "")
+	assert.Empty(t, msg.Changes)
+
+	other := setupCluster(t)
+	msg = applyTopologyChanges(t, other, string(body), "")
+	assert.Equal(t, map[string]int{"add ring": 1, "add node": 3, "add device": 4}, changeCount(msg))
+
+	r, err = http.Get(ts.URL + "/clusters/123/export")
+	assert.Nil(t, err)
+	assert.Equal(t, r.StatusCode, http.StatusNotFound)
+}
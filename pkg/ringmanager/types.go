@@ -15,7 +15,12 @@ limitations under the License.
 */
 package ringmanager
 
-import "sort"
+import (
+	"sort"
+	"time"
+
+	"github.com/thiagodasilva/swift-ring-manager/pkg/ringbuilder"
+)
 
 // TODO: not sure we need this yet
 type EntryState string
@@ -33,9 +38,48 @@ type ClusterListResponse struct {
 	Clusters []string `json:"clusters"`
 }
 
+type ClusterDeleteResponse struct {
+	Id      string   `json:"id"`
+	DryRun  bool     `json:"dry_run"`
+	Rings   []string `json:"rings"`
+	Nodes   []string `json:"nodes"`
+	Devices []string `json:"devices"`
+}
+
 type RingAddRequest struct {
-	ClusterId string `json:"cluster"`
-	Name      string `json:"name"`
+	ClusterId    string  `json:"cluster"`
+	Name         string  `json:"name"`
+	PartPower    int     `json:"part_power"`
+	Replicas     float64 `json:"replicas"`
+	MinPartHours int     `json:"min_part_hours"`
+
+	// account, container or object, by default given by the name.  Only
+	// object rings have a storage policy.
+	Type   string         `json:"type"`
+	Policy *StoragePolicy `json:"policy,omitempty"`
+
+	// Ids of the component rings of a composite ring, in order.  The
+	// composite ring has no nodes, its part power and replicas are the
+	// ones of its components.
+	Components []string `json:"components,omitempty"`
+}
+
+// StoragePolicy is the [storage-policy:N] section of swift.conf for the
+// object ring object-N, or object for policy 0
+type StoragePolicy struct {
+	Index      int      `json:"index" yaml:"index"`
+	Name       string   `json:"name" yaml:"name"`
+	Aliases    []string `json:"aliases,omitempty" yaml:"aliases,omitempty"`
+	Default    bool     `json:"default" yaml:"default,omitempty"`
+	Deprecated bool     `json:"deprecated" yaml:"deprecated,omitempty"`
+	PolicyType string   `json:"policy_type" yaml:"policy_type"`
+
+	// Erasure coding parameters.  The ring holds one replica per
+	// fragment.
+	EcType               string `json:"ec_type,omitempty" yaml:"ec_type,omitempty"`
+	EcNumDataFragments   int    `json:"ec_num_data_fragments,omitempty" yaml:"ec_num_data_fragments,omitempty"`
+	EcNumParityFragments int    `json:"ec_num_parity_fragments,omitempty" yaml:"ec_num_parity_fragments,omitempty"`
+	EcObjectSegmentSize  int    `json:"ec_object_segment_size,omitempty" yaml:"ec_object_segment_size,omitempty"`
 }
 
 type RingInfo struct {
@@ -45,7 +89,57 @@ type RingInfo struct {
 
 type RingInfoResponse struct {
 	RingInfo
-	Nodes sort.StringSlice `json:"nodes"`
+	Nodes   sort.StringSlice `json:"nodes"`
+	Version int              `json:"version"`
+}
+
+// RingVersionDevice is a device of the ring as it was built
+type RingVersionDevice struct {
+	DeviceId        string `json:"device"`
+	NodeId          string `json:"node"`
+	BuilderId       int    `json:"builder_id"`
+	Region          int    `json:"region"`
+	Zone            int    `json:"zone"`
+	Ip              string `json:"ip"`
+	Port            string `json:"port"`
+	ReplicationIP   string `json:"replicationIP"`
+	ReplicationPort string `json:"replicationPort"`
+	Name            string `json:"name"`
+	Meta            string `json:"meta"`
+	Weight          uint64 `json:"weight"`
+}
+
+// RingImportResponse is the ring added from a swift builder or ring
+// file, with the devices created for it
+type RingImportResponse struct {
+	RingInfoResponse
+	Devices []string `json:"devices"`
+}
+
+type RingVersionInfo struct {
+	RingId       string               `json:"ring"`
+	Version      int                  `json:"version"`
+	Created      time.Time            `json:"created"`
+	Author       string               `json:"author"`
+	RingHash     string               `json:"ring_hash"`
+	ChangedParts int                  `json:"changed_parts"`
+	Balance      float64              `json:"balance"`
+	Devices      []*RingVersionDevice `json:"devices"`
+}
+
+type RingVersionSummary struct {
+	Version      int       `json:"version"`
+	Created      time.Time `json:"created"`
+	Author       string    `json:"author"`
+	RingHash     string    `json:"ring_hash"`
+	ChangedParts int       `json:"changed_parts"`
+	Balance      float64   `json:"balance"`
+	Devices      int       `json:"devices"`
+}
+
+type RingVersionListResponse struct {
+	Current  int                   `json:"current"`
+	Versions []*RingVersionSummary `json:"versions"`
 }
 
 type NodeAddRequest struct {
@@ -58,6 +152,16 @@ type NodeAddRequest struct {
 	ReplicationPort string `json:"replicationPort"`
 }
 
+// NodeUpdateRequest only changes the fields set in the request
+type NodeUpdateRequest struct {
+	Region          *int    `json:"region"`
+	Zone            *int    `json:"zone"`
+	Ip              *string `json:"ip"`
+	ReplicationIP   *string `json:"replicationIP"`
+	Port            *string `json:"port"`
+	ReplicationPort *string `json:"replicationPort"`
+}
+
 type NodeInfo struct {
 	NodeAddRequest
 	Id string `json:"id"`
@@ -66,6 +170,14 @@ type NodeInfo struct {
 type NodeInfoResponse struct {
 	NodeInfo
 	Devices sort.StringSlice `json:"devices"`
+	Drain   *NodeDrainStatus `json:"drain,omitempty"`
+}
+
+// NodeDrainStatus is the progress of a node being drained
+type NodeDrainStatus struct {
+	Devices int    `json:"devices"`
+	Weight  uint64 `json:"weight"`
+	Parts   int    `json:"parts"`
 }
 
 type Device struct {
@@ -79,6 +191,12 @@ type DeviceAddRequest struct {
 	NodeId string `json:"node"`
 }
 
+// DeviceUpdateRequest only changes the fields set in the request
+type DeviceUpdateRequest struct {
+	Name *string `json:"name"`
+	Meta *string `json:"meta"`
+}
+
 type DeviceInfo struct {
 	Device
 	Weight DeviceWeight `json:"weight"`
@@ -89,7 +207,170 @@ type DeviceInfoResponse struct {
 	DeviceInfo
 }
 
+type DeviceWeightRequest struct {
+	Weight *uint64 `json:"weight"`
+}
+
 type DeviceWeight struct {
 	Current uint64 `json:"current"`
 	Target  uint64 `json:"target"`
 }
+
+type RingBuildResult struct {
+	Id                string   `json:"id"`
+	Name              string   `json:"name"`
+	Version           int      `json:"version"`
+	Created           bool     `json:"created"`
+	Unchanged         bool     `json:"unchanged"`
+	DevicesAdded      []string `json:"devices_added"`
+	DevicesRemoved    []string `json:"devices_removed"`
+	DevicesReweighted []string `json:"devices_reweighted"`
+	DevicesUpdated    []string `json:"devices_updated"`
+	DevicesDrained    []string `json:"devices_drained"`
+	ChangedParts      int      `json:"changed_parts"`
+	Balance           float64  `json:"balance"`
+
+	// Only in dry runs
+	Estimate *RingMoveEstimate `json:"estimate,omitempty"`
+}
+
+// RingMoveEstimate is what the rebalance of a dry run would move, and the
+// placement of the partitions before and after it.  Rings never built have
+// nothing to move.
+type RingMoveEstimate struct {
+	PartsMoved       int     `json:"parts_moved"`
+	ReplicasMoved    int     `json:"replicas_moved"`
+	BytesMoved       uint64  `json:"bytes_moved"`
+	BalanceBefore    float64 `json:"balance_before"`
+	BalanceAfter     float64 `json:"balance_after"`
+	DispersionBefore float64 `json:"dispersion_before"`
+	DispersionAfter  float64 `json:"dispersion_after"`
+}
+
+// RingReportResponse is the placement of the partitions in the ring file
+// of the version of the ring
+type RingReportResponse struct {
+	Id      string `json:"id"`
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+	*ringbuilder.Report
+}
+
+type BuildRingResponse struct {
+	DryRun        bool               `json:"dry_run"`
+	PartitionSize uint64             `json:"partition_size,omitempty"`
+	Rings         []*RingBuildResult `json:"rings"`
+}
+
+type BuildJobResponse struct {
+	Id        string             `json:"id"`
+	ClusterId string             `json:"cluster"`
+	Author    string             `json:"author"`
+	Status    string             `json:"status"`
+	Output    []string           `json:"output"`
+	Error     string             `json:"error,omitempty"`
+	Result    *BuildRingResponse `json:"result,omitempty"`
+}
+
+// AgentRingStatus is a ring installed on a storage node
+type AgentRingStatus struct {
+	Name    string `json:"name"`
+	Etag    string `json:"etag"`
+	Version int    `json:"version"`
+}
+
+type AgentReportRequest struct {
+	Node  string             `json:"node"`
+	Rings []*AgentRingStatus `json:"rings"`
+}
+
+type AgentInfo struct {
+	ClusterId string             `json:"cluster"`
+	Node      string             `json:"node"`
+	Rings     []*AgentRingStatus `json:"rings"`
+	Reported  time.Time          `json:"reported"`
+}
+
+// NodeDeployment is the ring last downloaded by a node, and the ring its
+// agent last reported as installed
+type NodeDeployment struct {
+	FetchedEtag  string    `json:"fetched_etag"`
+	Fetched      time.Time `json:"fetched"`
+	ReportedEtag string    `json:"reported_etag"`
+	Reported     time.Time `json:"reported"`
+}
+
+type NodeDeploymentStatus struct {
+	NodeDeployment
+	Id     string `json:"id"`
+	Ip     string `json:"ip"`
+	Port   string `json:"port"`
+	Status string `json:"status"`
+}
+
+type RingDeploymentStatus struct {
+	Id      string                  `json:"id"`
+	Name    string                  `json:"name"`
+	Etag    string                  `json:"etag"`
+	Version int                     `json:"version"`
+	Nodes   []*NodeDeploymentStatus `json:"nodes"`
+}
+
+type DeploymentResponse struct {
+	ClusterId string                  `json:"cluster"`
+	Rings     []*RingDeploymentStatus `json:"rings"`
+
+	// Every node runs the current build of every ring, so the next
+	// rebalance can go ahead
+	Current bool `json:"current"`
+}
+
+// Topology is the whole layout of a cluster, as a document to apply.
+// Rings are known by name, nodes by ip and port within their ring and
+// devices by name within their node.
+type Topology struct {
+	Rings []*TopologyRing `json:"rings" yaml:"rings"`
+}
+
+type TopologyRing struct {
+	Name         string          `json:"name" yaml:"name"`
+	PartPower    int             `json:"part_power,omitempty" yaml:"part_power,omitempty"`
+	Replicas     float64         `json:"replicas,omitempty" yaml:"replicas,omitempty"`
+	MinPartHours *int            `json:"min_part_hours,omitempty" yaml:"min_part_hours,omitempty"`
+	Type         string          `json:"type,omitempty" yaml:"type,omitempty"`
+	Policy       *StoragePolicy  `json:"policy,omitempty" yaml:"policy,omitempty"`
+	Components   []string        `json:"components,omitempty" yaml:"components,omitempty"`
+	Nodes        []*TopologyNode `json:"nodes" yaml:"nodes"`
+}
+
+type TopologyNode struct {
+	Region          int               `json:"region,omitempty" yaml:"region,omitempty"`
+	Zone            int               `json:"zone,omitempty" yaml:"zone,omitempty"`
+	Ip              string            `json:"ip" yaml:"ip"`
+	Port            string            `json:"port" yaml:"port"`
+	ReplicationIP   string            `json:"replicationIP,omitempty" yaml:"replicationIP,omitempty"`
+	ReplicationPort string            `json:"replicationPort,omitempty" yaml:"replicationPort,omitempty"`
+	Devices         []*TopologyDevice `json:"devices" yaml:"devices"`
+}
+
+type TopologyDevice struct {
+	Name   string `json:"name" yaml:"name"`
+	Meta   string `json:"meta,omitempty" yaml:"meta,omitempty"`
+	Weight uint64 `json:"weight" yaml:"weight"`
+}
+
+// TopologyChange is a ring, node or device added, updated or removed by
+// applying a topology
+type TopologyChange struct {
+	Action  string   `json:"action"`
+	Kind    string   `json:"kind"`
+	Name    string   `json:"name"`
+	Id      string   `json:"id"`
+	Changes []string `json:"changes,omitempty"`
+}
+
+type TopologyApplyResponse struct {
+	ClusterId string            `json:"cluster"`
+	Plan      bool              `json:"plan"`
+	Changes   []*TopologyChange `json:"changes"`
+}
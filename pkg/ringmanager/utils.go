@@ -24,9 +24,14 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/lpabon/godbc"
+	"gopkg.in/yaml.v2"
 )
 
 // Return a 16-byte uuid
@@ -60,12 +65,39 @@ func GetJsonFromRequest(r *http.Request, v interface{}) error {
 	return jsonFromBody(r.Body, v)
 }
 
+// IsYaml tells if the media type, like the content type of a request, is
+// YAML rather than JSON
+func IsYaml(mediaType string) bool {
+	return strings.Contains(mediaType, "yaml")
+}
+
+// Unmarshal a JSON or YAML document from the request, depending on its
+// content type
+func GetDocumentFromRequest(r *http.Request, v interface{}) error {
+	defer r.Body.Close()
+	if !IsYaml(r.Header.Get("Content-Type")) {
+		return jsonFromBody(r.Body, v)
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(body, v)
+}
+
 // Unmarshal JSON from response
 func GetJsonFromResponse(r *http.Response, v interface{}) error {
 	defer r.Body.Close()
 	return jsonFromBody(r.Body, v)
 }
 
+// Returns the boolean value of the query parameter, false when missing
+func GetBoolFromQuery(r *http.Request, name string) bool {
+	value, err := strconv.ParseBool(r.URL.Query().Get(name))
+	return err == nil && value
+}
+
 // Check if a sorted string list has a string
 func SortedStringHas(s sort.StringSlice, x string) bool {
 	index := s.Search(x)
@@ -97,3 +129,105 @@ func FileHash(file *os.File) (result string, err error) {
 	result = hex.EncodeToString(hash.Sum(nil))
 	return result, nil
 }
+
+type fileHashCacheEntry struct {
+	info os.FileInfo
+	hash string
+}
+
+var fileHashCache = struct {
+	sync.Mutex
+	entries map[string]*fileHashCacheEntry
+}{entries: make(map[string]*fileHashCacheEntry)}
+
+// CachedFileHash returns the sha256 hash of the opened file at path,
+// only hashing it again when the file was replaced or modified since the
+// last call.  It also returns the stat of the file.
+func CachedFileHash(path string, file *os.File) (string, os.FileInfo, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return "", nil, err
+	}
+
+	fileHashCache.Lock()
+	cached, ok := fileHashCache.entries[path]
+	fileHashCache.Unlock()
+	if ok && os.SameFile(cached.info, info) &&
+		cached.info.ModTime().Equal(info.ModTime()) &&
+		cached.info.Size() == info.Size() {
+		return cached.hash, info, nil
+	}
+
+	hash, err := FileHash(file)
+	if err != nil {
+		return "", nil, err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", nil, err
+	}
+
+	fileHashCache.Lock()
+	fileHashCache.entries[path] = &fileHashCacheEntry{info: info, hash: hash}
+	fileHashCache.Unlock()
+
+	return hash, info, nil
+}
+
+// EtagMatches checks if the etag is in the list of an If-None-Match
+// header.  Quoted and weak etags are accepted.
+func EtagMatches(header, etag string) bool {
+	for _, value := range strings.Split(header, ",") {
+		value = strings.TrimSpace(value)
+		if value == "*" {
+			return true
+		}
+		value = strings.TrimPrefix(value, "W/")
+		if strings.Trim(value, `"`) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// CopyFile atomically replaces dst with a copy of src
+func CopyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Chmod(0644)
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(out.Name(), dst)
+}
+
+// PathHash returns the sha256 hash of the file at path
+func PathHash(path string) (string, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fp.Close()
+
+	return FileHash(fp)
+}
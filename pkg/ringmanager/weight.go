@@ -0,0 +1,87 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// weightStep limits how much the weight of a device moves toward its
+// target on every build, so that disks are filled and emptied gradually
+type weightStep struct {
+	value   float64
+	percent bool
+}
+
+// parseWeightStep parses a step in units, like "100", or in percent of
+// the larger of the current and target weights, like "10%".  An empty or
+// zero step does not limit the change.
+func parseWeightStep(s string) (*weightStep, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	step := &weightStep{}
+	if strings.HasSuffix(s, "%") {
+		step.percent = true
+		s = strings.TrimSuffix(s, "%")
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 || (step.percent && value > 100) {
+		return nil, fmt.Errorf("Invalid weight step %v", s)
+	}
+	if value == 0 {
+		return nil, nil
+	}
+	step.value = value
+
+	return step, nil
+}
+
+// next returns the weight after one step from current toward target
+func (s *weightStep) next(current, target uint64) uint64 {
+	if s == nil || current == target {
+		return target
+	}
+
+	delta := s.value
+	if s.percent {
+		base := current
+		if target > base {
+			base = target
+		}
+		delta = math.Ceil(float64(base) * s.value / 100)
+	}
+	if delta < 1 {
+		delta = 1
+	}
+
+	if target > current {
+		if float64(target-current) <= delta {
+			return target
+		}
+		return current + uint64(delta)
+	}
+	if float64(current-target) <= delta {
+		return target
+	}
+	return current - uint64(delta)
+}
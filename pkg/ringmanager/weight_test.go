@@ -0,0 +1,70 @@
+/*
+Copyright 2017 The swift-ring-master Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ringmanager
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseWeightStep(t *testing.T) {
+	for _, s := range []string{"", "0", "0%"} {
+		step, err := parseWeightStep(s)
+		assert.Nil(t, err, s)
+		assert.Nil(t, step, s)
+	}
+
+	step, err := parseWeightStep("100")
+	assert.Nil(t, err)
+	assert.Equal(t, &weightStep{value: 100}, step)
+
+	step, err = parseWeightStep(" 10% ")
+	assert.Nil(t, err)
+	assert.Equal(t, &weightStep{value: 10, percent: true}, step)
+
+	for _, s := range []string{"abc", "-1", "101%", "10 %"} {
+		_, err := parseWeightStep(s)
+		assert.NotNil(t, err, s)
+	}
+
+	// The server does not start without the limit it was asked for
+	v := viper.New()
+	v.Set("weight_step", "10 %")
+	_, err = NewRouter(v)
+	assert.NotNil(t, err)
+}
+
+func TestWeightStepNext(t *testing.T) {
+	var unlimited *weightStep
+	assert.Equal(t, uint64(1000), unlimited.next(0, 1000))
+	assert.Equal(t, uint64(0), unlimited.next(1000, 0))
+
+	units := &weightStep{value: 300}
+	assert.Equal(t, uint64(300), units.next(0, 1000))
+	assert.Equal(t, uint64(1000), units.next(900, 1000))
+	assert.Equal(t, uint64(700), units.next(1000, 0))
+	assert.Equal(t, uint64(0), units.next(200, 0))
+	assert.Equal(t, uint64(500), units.next(500, 500))
+
+	// Percent of the larger weight, so draining does not stall
+	percent := &weightStep{value: 25, percent: true}
+	assert.Equal(t, uint64(250), percent.next(0, 1000))
+	assert.Equal(t, uint64(750), percent.next(1000, 0))
+	assert.Equal(t, uint64(1), percent.next(2, 0))
+	assert.Equal(t, uint64(0), percent.next(1, 0))
+}